@@ -0,0 +1,203 @@
+package chatspace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streamwest-1629/chatspace/app/voicevox"
+	"go.uber.org/zap"
+)
+
+const (
+	afkActionMove       = "move"
+	afkActionDisconnect = "disconnect"
+)
+
+type afkReason int
+
+const (
+	// スピーカーミュートを続けている
+	afkReasonDeaf afkReason = iota
+	// 休憩時間を通して話さず、メッセージも送っていない
+	afkReasonIdle
+)
+
+func (r afkReason) String() string {
+	switch r {
+	case afkReasonDeaf:
+		return "deaf"
+	case afkReasonIdle:
+		return "idle"
+	}
+	return fmt.Sprintf("afkReason(%d)", int(r))
+}
+
+// afkWatch is the pending warning or removal of a member who seems away.
+type afkWatch struct {
+	reason afkReason
+	warned bool
+	event  *ScheduledEvent
+}
+
+// listenSpeaking marks the members speaking in the voice channel as active.
+func (ss *ServerStatus) listenSpeaking(vc *voicevox.ManagedDiscordVoiceConnection) {
+	now := time.Now()
+	for memberId := range ss.memberIDs {
+		ss.lastActiveAt[memberId] = now
+		delete(ss.idleBreaks, memberId)
+	}
+	ss.stopSpeaking = vc.OnSpeaking(func(userID string) {
+		// 音声接続のゴルーチンを止めないように別のゴルーチンで処理する
+		go func() {
+			ss.lock.Lock()
+			defer ss.lock.Unlock()
+			if ss.voiceConn == vc {
+				ss.markActive(userID, time.Now())
+			}
+		}()
+	})
+}
+
+// markActive records a sign that the member is present
+func (ss *ServerStatus) markActive(userID string, now time.Time) {
+	if _, exist := ss.memberIDs[userID]; !exist {
+		return
+	}
+	ss.lastActiveAt[userID] = now
+	delete(ss.idleBreaks, userID)
+	if watch, exist := ss.afkWatches[userID]; exist && watch.reason == afkReasonIdle {
+		ss.cancelAFK(userID)
+		if watch.warned {
+			ss.logger.Debug("member away came back", zap.String("userID", userID))
+		}
+	}
+}
+
+// onSelfDeafChange starts or stops watching the self-deafened member
+func (ss *ServerStatus) onSelfDeafChange(userID string, selfDeaf bool, now time.Time) {
+	watch, watching := ss.afkWatches[userID]
+	if !selfDeaf {
+		if watching && watch.reason == afkReasonDeaf {
+			ss.cancelAFK(userID)
+		}
+		ss.markActive(userID, now)
+		return
+	}
+	if ss.config.AFKDeafMinutes == 0 || watching {
+		return
+	}
+	ss.afkWatches[userID] = &afkWatch{
+		reason: afkReasonDeaf,
+		event: ss.schedules.Schedule(now.Add(time.Duration(ss.config.AFKDeafMinutes)*timeStep), func() {
+			ss.lock.Lock()
+			defer ss.lock.Unlock()
+			ss.warnAFK(userID, afkReasonDeaf)
+		}),
+	}
+}
+
+// watchDeafMembers starts watching the members already self-deafened when the room opens
+func (ss *ServerStatus) watchDeafMembers(now time.Time) {
+	guild, err := ss.sess.State.Guild(ss.guildID)
+	if err != nil {
+		return
+	}
+	for _, vs := range guild.VoiceStates {
+		if _, exist := ss.memberIDs[vs.UserID]; exist && vs.ChannelID == ss.channelID && vs.SelfDeaf {
+			ss.onSelfDeafChange(vs.UserID, true, now)
+		}
+	}
+}
+
+// checkIdleBreaks counts the breaks which the members spent without any sign
+func (ss *ServerStatus) checkIdleBreaks(now time.Time) {
+	// 発言を聞けない部屋ではメンバーが話しているか分からない
+	if ss.config.AFKIdleBreaks == 0 || ss.breakStartedAt.IsZero() || ss.voiceConn == nil {
+		return
+	}
+	for memberId := range ss.memberIDs {
+		lastActiveAt, exist := ss.lastActiveAt[memberId]
+		if !exist {
+			ss.lastActiveAt[memberId] = now
+			continue
+		}
+		if !lastActiveAt.Before(ss.breakStartedAt) {
+			delete(ss.idleBreaks, memberId)
+			continue
+		}
+		ss.idleBreaks[memberId]++
+		if _, watching := ss.afkWatches[memberId]; !watching && ss.idleBreaks[memberId] >= ss.config.AFKIdleBreaks {
+			ss.warnAFK(memberId, afkReasonIdle)
+		}
+	}
+}
+
+// warnAFK asks the member whether they are present and removes them after the grace time
+func (ss *ServerStatus) warnAFK(userID string, reason afkReason) {
+	if _, exist := ss.memberIDs[userID]; !exist || ss.isClosed {
+		delete(ss.afkWatches, userID)
+		return
+	}
+
+	ss.logger.Info("warn member away", zap.String("userID", userID), zap.Stringer("reason", reason))
+	name, err := ss.memberName(userID)
+	if err != nil {
+		ss.logger.Error("cannot get user status", zap.Error(err))
+		name = "メンバー"
+	}
+	ss.announce(false, fmt.Sprintf("%sさん、いますか？", name))
+	ss.announce(false, fmt.Sprintf("%d分以内に反応がなければ離席として扱います。", ss.config.AFKGraceMinutes))
+
+	action := "スピーカーミュートを解除してください"
+	if reason == afkReasonIdle {
+		action = "メッセージを送ってください"
+	}
+	if _, err := ss.sess.ChannelMessageSend(ss.channelID, fmt.Sprintf("💤<@%s> さん、いますか？%d分以内に%s。", userID, ss.config.AFKGraceMinutes, action)); err != nil {
+		ss.logger.Error("failed send message", zap.String("channelID", ss.channelID), zap.Error(err))
+	}
+
+	ss.afkWatches[userID] = &afkWatch{
+		reason: reason,
+		warned: true,
+		event: ss.schedules.Schedule(time.Now().Add(time.Duration(ss.config.AFKGraceMinutes)*timeStep), func() {
+			ss.removeAFK(userID, reason)
+		}),
+	}
+}
+
+// removeAFK moves the member away out of the room. (セッションからは続くボイス状態の更新で抜ける)
+func (ss *ServerStatus) removeAFK(userID string, reason afkReason) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	delete(ss.afkWatches, userID)
+	if _, exist := ss.memberIDs[userID]; !exist || ss.isClosed {
+		return
+	}
+
+	var afkChannelID *string
+	if ss.config.AFKAction == afkActionMove {
+		if guild, err := ss.sess.State.Guild(ss.guildID); err == nil && guild.AfkChannelID != "" {
+			afkChannelID = &guild.AfkChannelID
+		}
+	}
+	if err := ss.sess.GuildMemberMove(ss.guildID, userID, afkChannelID); err != nil {
+		ss.logger.Error("cannot remove member away", zap.String("userID", userID), zap.Error(err))
+		return
+	}
+	ss.logger.Info("removed member away", zap.String("userID", userID), zap.Stringer("reason", reason), zap.Bool("movedToAFK", afkChannelID != nil))
+
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "💤離席として扱いました",
+		Description: fmt.Sprintf("<@%s> さんの反応がなかったので作業部屋から外しました。戻ったらまた入室してください。", userID),
+	})
+}
+
+// cancelAFK stops watching the member
+func (ss *ServerStatus) cancelAFK(userID string) {
+	if watch, exist := ss.afkWatches[userID]; exist {
+		watch.event.Cancel()
+		delete(ss.afkWatches, userID)
+	}
+}
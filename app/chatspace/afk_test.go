@@ -0,0 +1,81 @@
+package chatspace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streamwest-1629/chatspace/app/voicevox"
+	"go.uber.org/zap"
+)
+
+func TestCheckIdleBreaks(t *testing.T) {
+
+	config := DefaultGuildConfig.clone()
+	config.AFKIdleBreaks = 2
+	breakStartedAt := time.Date(2023, 1, 2, 9, 45, 0, 0, time.UTC)
+	ss := &ServerStatus{
+		logger:         zap.NewNop(),
+		voiceConn:      &voicevox.ManagedDiscordVoiceConnection{},
+		config:         config,
+		memberIDs:      map[string]struct{}{"idle": {}, "active": {}, "joined": {}},
+		lastActiveAt:   map[string]time.Time{"idle": breakStartedAt.Add(-time.Minute), "active": breakStartedAt.Add(time.Minute)},
+		idleBreaks:     map[string]int{"active": 1},
+		afkWatches:     map[string]*afkWatch{},
+		breakStartedAt: breakStartedAt,
+	}
+
+	now := breakStartedAt.Add(15 * time.Minute)
+	ss.checkIdleBreaks(now)
+
+	if ss.idleBreaks["idle"] != 1 || len(ss.afkWatches) != 0 {
+		t.Errorf("idle member is warned too early: %+v", ss.idleBreaks)
+	}
+	if _, exist := ss.idleBreaks["active"]; exist {
+		t.Errorf("idle breaks of active member is not reset: %+v", ss.idleBreaks)
+	}
+	if lastActiveAt := ss.lastActiveAt["joined"]; !lastActiveAt.Equal(now) {
+		t.Errorf("member without record is not started: %v", lastActiveAt)
+	}
+
+	// 音声接続がない部屋では数えない
+	ss.voiceConn = nil
+	ss.checkIdleBreaks(now)
+	if ss.idleBreaks["idle"] != 1 {
+		t.Errorf("idle breaks is counted without voice connection: %+v", ss.idleBreaks)
+	}
+
+	// 発言・メッセージがあれば数え直す
+	ss.markActive("idle", now)
+	if _, exist := ss.idleBreaks["idle"]; exist {
+		t.Errorf("idle breaks is not reset by activity: %+v", ss.idleBreaks)
+	}
+}
+
+func TestWatchDeafMembers(t *testing.T) {
+
+	scheduler := NewScheduler()
+	defer scheduler.Stop()
+	ss := newTestRoom(t, scheduler, newLocalStores(t.TempDir()))
+	ss.config.AFKDeafMinutes = 10
+	ss.memberIDs["deaf"] = struct{}{}
+	if err := ss.sess.State.GuildAdd(&discordgo.Guild{
+		ID: "guild",
+		VoiceStates: []*discordgo.VoiceState{
+			{UserID: "deaf", ChannelID: "room", SelfDeaf: true},
+			{UserID: "a", ChannelID: "room"},
+			{UserID: "elsewhere", ChannelID: "other", SelfDeaf: true},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 部屋を開いたときや再起動から戻ったときに、すでに聞こえない状態の人も見張る
+	ss.watchDeafMembers(time.Now())
+	if watch, exist := ss.afkWatches["deaf"]; !exist || watch.reason != afkReasonDeaf {
+		t.Errorf("self-deafened member is not watched: %+v", ss.afkWatches)
+	}
+	if len(ss.afkWatches) != 1 {
+		t.Errorf("unexpected watched members: %+v", ss.afkWatches)
+	}
+}
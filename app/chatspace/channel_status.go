@@ -0,0 +1,37 @@
+package chatspace
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// setVoiceChannelStatus sets the status of the voice channel. (discordgo にまだ API がないので直接呼ぶ)
+func setVoiceChannelStatus(sess *discordgo.Session, channelID, status string) error {
+	endpoint := discordgo.EndpointChannel(channelID) + "/voice-status"
+	_, err := sess.RequestWithBucketID("PUT", endpoint, map[string]string{"status": status}, endpoint)
+	return err
+}
+
+// channelStatusText returns the voice channel status of the current phase
+func (ss *ServerStatus) channelStatusText() string {
+	switch {
+	case ss.isClosed || ss.finished || ss.mode != serverStatusModeWork:
+		return ""
+	case ss.isPaused():
+		return "⏸️作業を一時停止中"
+	}
+	return fmt.Sprintf("🍅作業中（%sまで）", ss.config.formatClock(ss.phaseEndAt))
+}
+
+// updateChannelStatus shows the current phase as the voice channel status
+func (ss *ServerStatus) updateChannelStatus() {
+	if !ss.config.ChannelStatus {
+		return
+	}
+	status := ss.channelStatusText()
+	if err := setVoiceChannelStatus(ss.sess, ss.channelID, status); err != nil {
+		ss.logger.Error("cannot set voice channel status", zap.String("status", status), zap.Error(err))
+	}
+}
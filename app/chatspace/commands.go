@@ -0,0 +1,648 @@
+package chatspace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// スラッシュコマンドの定義 (起動時に登録する。サーバーごとの記録や部屋を扱うので DM では使えない)
+var applicationCommands = []*discordgo.ApplicationCommand{
+	{
+		Name:         "stats",
+		Description:  "作業時間とポモドーロの記録を表示します",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "member",
+				Description: "記録を表示するメンバー (省略時は自分)",
+			},
+		},
+	},
+	{
+		Name:         "goal",
+		Description:  "作業の目標を宣言・振り返ります",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "今回 (休憩中は次回) の作業の目標を宣言します",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "取り組むこと",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "result",
+				Description: "直前の作業の結果を書き込みます",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "どうだったか",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "history",
+				Description: "これまでの目標と結果を表示します",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "member",
+						Description: "表示するメンバー (省略時は自分)",
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:         "pomodoro",
+		Description:  "入室している作業部屋のタイマーを操作します",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "pause",
+				Description: "タイマーを一時停止します",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "resume",
+				Description: "一時停止したタイマーを再開します",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "skip",
+				Description: "今の作業時間・休憩時間を終えて次に進みます",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "extend",
+				Description: "今の作業時間・休憩時間を延長します",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "minutes",
+						Description: "延長する分数",
+						Required:    true,
+						MinValue:    &minExtendMinutes,
+						MaxValue:    maxExtendMinutes,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "stop",
+				Description: "セッションを終了してミュートを解除します",
+			},
+		},
+	},
+	{
+		Name:         "timer",
+		Description:  "作業部屋に入らずに自分だけのポモドーロを使います",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "start",
+				Description: "個人タイマーを始めます (切り替えは DM でお知らせします)",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "work",
+						Description: "作業時間の分数 (省略時はサーバーの設定)",
+						MinValue:    &minTimerMinutes,
+						MaxValue:    maxTimerMinutes,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "break",
+						Description: "休憩時間の分数 (省略時はサーバーの設定)",
+						MinValue:    &minTimerMinutes,
+						MaxValue:    maxTimerMinutes,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "cycles",
+						Description: "作業の回数 (省略時はサーバーの設定)",
+						MinValue:    &minTimerCycles,
+						MaxValue:    maxTimerCycles,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "stop",
+				Description: "個人タイマーを止めます",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "個人タイマーの状態を表示します",
+			},
+		},
+	},
+	{
+		Name:         "topic",
+		Description:  "休憩の始めに紹介する話題・運動を管理します",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "話題・運動を追加します",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "話題や運動の内容",
+						Required:    true,
+						MaxLength:   maxTopicLength,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "kind",
+						Description: "種類 (省略時は話題)",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "話題", Value: topicKindTalk},
+							{Name: "運動・深呼吸", Value: topicKindActivity},
+						},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "コマンドで追加した話題・運動を削除します",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "削除する話題や運動の内容",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "紹介する話題・運動の一覧を表示します",
+			},
+		},
+	},
+	{
+		Name:                     "config",
+		Description:              "このサーバーのポモドーロの設定を表示・変更します",
+		DMPermission:             new(bool),
+		DefaultMemberPermissions: &configPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "今の設定を表示します",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "設定を変更します (次のセッションから反映されます)",
+				Options:     configCommandOptions(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "コマンドで変更した設定を元に戻します",
+			},
+		},
+	},
+}
+
+var (
+	// /config はサーバーの管理権限を持つメンバーだけが使える
+	configPermission int64   = discordgo.PermissionManageServer
+	minExtendMinutes float64 = 1
+	maxExtendMinutes float64 = 120
+	minTimerMinutes  float64 = 1
+	maxTimerMinutes  float64 = 180
+	minTimerCycles   float64 = 1
+	maxTimerCycles   float64 = 12
+)
+
+const maxTopicLength = 200
+
+func (st *serviceState) onInteraction(event *discordgo.InteractionCreate) {
+	// DM からのコマンドにはメンバー情報がない
+	if event.Type != discordgo.InteractionApplicationCommand || event.Member == nil {
+		return
+	}
+
+	data := event.ApplicationCommandData()
+	options := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, option := range data.Options {
+		options[option.Name] = option
+	}
+
+	switch data.Name {
+	case "stats":
+		memberID := event.Member.User.ID
+		if option, exist := options["member"]; exist {
+			memberID = option.UserValue(nil).ID
+		}
+		st.respond(event.Interaction, st.statsEmbed(event.GuildID, memberID))
+
+	case "goal":
+		if len(data.Options) == 0 {
+			break
+		}
+		subCommand := data.Options[0]
+		subOptions := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+		for _, option := range subCommand.Options {
+			subOptions[option.Name] = option
+		}
+		st.goal(event, subCommand.Name, subOptions)
+
+	case "pomodoro":
+		if len(data.Options) == 0 {
+			break
+		}
+		subCommand := data.Options[0]
+		subOptions := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+		for _, option := range subCommand.Options {
+			subOptions[option.Name] = option
+		}
+		st.pomodoro(event, subCommand.Name, subOptions)
+
+	case "timer":
+		if len(data.Options) == 0 {
+			break
+		}
+		subCommand := data.Options[0]
+		subOptions := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+		for _, option := range subCommand.Options {
+			subOptions[option.Name] = option
+		}
+		st.timer(event, subCommand.Name, subOptions)
+
+	case "topic":
+		if len(data.Options) == 0 {
+			break
+		}
+		subCommand := data.Options[0]
+		subOptions := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+		for _, option := range subCommand.Options {
+			subOptions[option.Name] = option
+		}
+		st.topic(event, subCommand.Name, subOptions)
+
+	case "config":
+		if len(data.Options) == 0 {
+			break
+		}
+		st.configure(event, data.Options[0].Name, data.Options[0].Options)
+	}
+}
+
+func (st *serviceState) goal(event *discordgo.InteractionCreate, subCommand string, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	authorID := event.Member.User.ID
+
+	if subCommand == "history" {
+		memberID := authorID
+		if option, exist := options["member"]; exist {
+			memberID = option.UserValue(nil).ID
+		}
+		st.respond(event.Interaction, st.goalHistoryEmbed(event.GuildID, memberID))
+		return
+	}
+
+	serverStatus := st.roomOfMember(event.GuildID, authorID)
+	if serverStatus == nil {
+		st.respond(event.Interaction, &discordgo.MessageEmbed{
+			Title: "😑作業部屋に入室しているときのみ利用できます",
+		})
+		return
+	}
+
+	text := options["text"].StringValue()
+	switch subCommand {
+	case "set":
+		cycle, err := serverStatus.SetGoal(authorID, text)
+		if err != nil {
+			st.logger.Error("cannot save goal", zap.Error(err))
+			st.respond(event.Interaction, &discordgo.MessageEmbed{Title: "🤯目標を保存できませんでした"})
+			return
+		}
+		st.respond(event.Interaction, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("🎯%d回目の作業の目標", cycle),
+			Description: fmt.Sprintf("<@%s>: %s", authorID, text),
+		})
+
+	case "result":
+		cycle, err := serverStatus.SetOutcome(authorID, text)
+		if err != nil {
+			st.logger.Warn("cannot save outcome", zap.Error(err))
+			st.respond(event.Interaction, &discordgo.MessageEmbed{Title: "🤔振り返る作業がまだありません"})
+			return
+		}
+		st.respond(event.Interaction, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("📝%d回目の作業の結果", cycle),
+			Description: fmt.Sprintf("<@%s>: %s", authorID, text),
+		})
+	}
+}
+
+func (st *serviceState) pomodoro(event *discordgo.InteractionCreate, subCommand string, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	authorID := event.Member.User.ID
+
+	serverStatus := st.roomOfMember(event.GuildID, authorID)
+	if serverStatus == nil {
+		st.respond(event.Interaction, &discordgo.MessageEmbed{
+			Title: "😑作業部屋に入室しているときのみ利用できます",
+		})
+		return
+	}
+	if !st.config.Guild(event.GuildID).canControl(event.Member, serverStatus.Owner()) {
+		st.respond(event.Interaction, &discordgo.MessageEmbed{
+			Title: "🙅タイマーを操作する権限がありません",
+		})
+		return
+	}
+
+	// 切り替えはミュートやロールの変更で3秒以上かかることがあるので先に応答しておく
+	if !st.deferResponse(event.Interaction) {
+		return
+	}
+
+	var err error
+	embed := &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("<@%s> が操作しました", authorID),
+	}
+	switch subCommand {
+	case "pause":
+		embed.Title = "⏸️タイマーを一時停止しました"
+		err = serverStatus.Pause()
+	case "resume":
+		embed.Title = "▶️タイマーを再開しました"
+		err = serverStatus.ResumePhase()
+	case "skip":
+		embed.Title = "⏭️次に進みました"
+		err = serverStatus.Skip()
+	case "extend":
+		minutes := int(options["minutes"].IntValue())
+		embed.Title = fmt.Sprintf("⏩%d分延長しました", minutes)
+		err = serverStatus.Extend(minutes)
+	case "stop":
+		embed.Title = "⏹️セッションを終了しました"
+		err = serverStatus.EndSession()
+	default:
+		return
+	}
+
+	switch {
+	case err == nil:
+		st.logger.Info("controlled pomodoro", zap.String("subCommand", subCommand), zap.String("userID", authorID), zap.String("channelID", serverStatus.channelID))
+		st.followup(event.Interaction, embed)
+	case errors.Is(err, errSessionFinished):
+		st.followup(event.Interaction, &discordgo.MessageEmbed{Title: "🤔セッションはすでに終了しています"})
+	case errors.Is(err, errAlreadyPaused):
+		st.followup(event.Interaction, &discordgo.MessageEmbed{Title: "🤔タイマーはすでに一時停止中です"})
+	case errors.Is(err, errNotPaused):
+		st.followup(event.Interaction, &discordgo.MessageEmbed{Title: "🤔タイマーは一時停止していません"})
+	default:
+		st.logger.Error("cannot control pomodoro", zap.String("subCommand", subCommand), zap.Error(err))
+		st.followup(event.Interaction, &discordgo.MessageEmbed{Title: "🤯タイマーを操作できませんでした"})
+	}
+}
+
+func (st *serviceState) timer(event *discordgo.InteractionCreate, subCommand string, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	authorID := event.Member.User.ID
+	key := timerKey(event.GuildID, authorID)
+	pt, running := st.timers[key]
+
+	switch subCommand {
+	case "start":
+		if running {
+			st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{Title: "🤔個人タイマーはすでに動いています"})
+			return
+		}
+
+		config := st.config.Guild(event.GuildID)
+		timer := &timerRecord{
+			UserID:           authorID,
+			WorkMinutes:      config.WorkMinutes,
+			BreakMinutes:     config.BreakMinutes,
+			LongBreakMinutes: config.LongBreakMinutes,
+			CyclesPerSession: config.CyclesPerSession,
+		}
+		if option, exist := options["work"]; exist {
+			timer.WorkMinutes = int(option.IntValue())
+		}
+		if option, exist := options["break"]; exist {
+			timer.BreakMinutes = int(option.IntValue())
+			timer.LongBreakMinutes = 0
+		}
+		if option, exist := options["cycles"]; exist {
+			timer.CyclesPerSession = int(option.IntValue())
+		}
+
+		st.startTimer(sessionRecord{GuildID: event.GuildID, Timer: timer}, (*personalTimer).start)
+		st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{
+			Title:       "⏱️個人タイマーを始めました",
+			Description: fmt.Sprintf("作業%d分・休憩%d分で進めます。切り替えは DM でお知らせします。", timer.WorkMinutes, timer.BreakMinutes),
+		})
+
+	case "stop":
+		if !running {
+			st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{Title: "🤔個人タイマーは動いていません"})
+			return
+		}
+		pt.stop()
+		delete(st.timers, key)
+		st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{
+			Title:       "⏹️個人タイマーを止めました",
+			Description: fmt.Sprintf("%d回目の作業まで進みました。お疲れ様でした。", pt.record.Cycle),
+		})
+
+	case "status":
+		if !running {
+			st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{Title: "🤔個人タイマーは動いていません"})
+			return
+		}
+		st.respondEphemeral(event.Interaction, pt.statusEmbed(time.Now()))
+	}
+}
+
+func (st *serviceState) topic(event *discordgo.InteractionCreate, subCommand string, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	authorID := event.Member.User.ID
+	config := st.config.Guild(event.GuildID)
+
+	if subCommand == "list" {
+		st.respondEphemeral(event.Interaction, st.topicListEmbed(event.GuildID, config))
+		return
+	}
+	// 話題はサーバー全体のものなのでセッションを始めた人でも変更できない
+	if !config.canControl(event.Member, "") {
+		st.respond(event.Interaction, &discordgo.MessageEmbed{
+			Title: "🙅話題を変更する権限がありません",
+		})
+		return
+	}
+
+	text := strings.TrimSpace(options["text"].StringValue())
+	switch subCommand {
+	case "add":
+		topic := breakTopic{Kind: topicKindTalk, Text: text, AddedBy: authorID}
+		if option, exist := options["kind"]; exist {
+			topic.Kind = option.StringValue()
+		}
+		switch err := st.stores.topics.add(event.GuildID, topic); {
+		case err == nil:
+			st.logger.Info("added break topic", zap.String("guildID", event.GuildID), zap.String("userID", authorID))
+			st.respond(event.Interaction, &discordgo.MessageEmbed{
+				Title:       "📝話題を追加しました",
+				Description: text,
+			})
+		case errors.Is(err, errTopicExists):
+			st.respond(event.Interaction, &discordgo.MessageEmbed{Title: "🤔同じ話題がすでにあります"})
+		default:
+			st.logger.Error("cannot add break topic", zap.Error(err))
+			st.respond(event.Interaction, &discordgo.MessageEmbed{Title: "🤯話題を保存できませんでした"})
+		}
+
+	case "remove":
+		switch err := st.stores.topics.remove(event.GuildID, text); {
+		case err == nil:
+			st.logger.Info("removed break topic", zap.String("guildID", event.GuildID), zap.String("userID", authorID))
+			st.respond(event.Interaction, &discordgo.MessageEmbed{
+				Title:       "🗑️話題を削除しました",
+				Description: text,
+			})
+		case errors.Is(err, errTopicNotFound):
+			st.respond(event.Interaction, &discordgo.MessageEmbed{Title: "🤔コマンドで追加した話題に見つかりません"})
+		default:
+			st.logger.Error("cannot remove break topic", zap.Error(err))
+			st.respond(event.Interaction, &discordgo.MessageEmbed{Title: "🤯話題を保存できませんでした"})
+		}
+	}
+}
+
+func (st *serviceState) topicListEmbed(guildID string, config GuildConfig) *discordgo.MessageEmbed {
+	topics, err := st.stores.topics.guild(guildID)
+	if err != nil {
+		st.logger.Error("cannot load break topics", zap.Error(err))
+		return &discordgo.MessageEmbed{Title: "🤯話題を読み込めませんでした"}
+	}
+
+	base := config.topicPool(nil)
+	source := "組み込みの話題"
+	if config.TopicsFile != "" {
+		source = "設定ファイルの話題"
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:  "💬休憩中の話題",
+		Fields: []*discordgo.MessageEmbedField{},
+	}
+	if !config.BreakTopics {
+		embed.Description = "このサーバーでは休憩中の話題を紹介しない設定です。"
+	}
+	if len(base) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%s (%d件)", source, len(base)),
+			Value: topicLines(base),
+		})
+	}
+	if len(topics.Topics) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("コマンドで追加した話題 (%d件)", len(topics.Topics)),
+			Value: topicLines(topics.Topics),
+		})
+	}
+	return embed
+}
+
+// topicLines lists the topics within the length of an embed field.
+func topicLines(topics []breakTopic) string {
+	// 埋め込みのフィールドは1024文字まで (省略の表示の分を空けておく)
+	const maxFieldLength = 1024 - 32
+
+	builder := strings.Builder{}
+	for i, topic := range topics {
+		mark := "💬"
+		if topic.Kind == topicKindActivity {
+			mark = "🧘"
+		}
+		line := fmt.Sprintf("%s %s\n", mark, topic.Text)
+		if builder.Len()+len(line) > maxFieldLength {
+			builder.WriteString(fmt.Sprintf("…他%d件", len(topics)-i))
+			break
+		}
+		builder.WriteString(line)
+	}
+	return builder.String()
+}
+
+// roomOfMember returns the room the member is in.
+func (st *serviceState) roomOfMember(guildID, userID string) *ServerStatus {
+	for _, ss := range st.serverStatuses {
+		if ss.guildID == guildID && ss.hasMember(userID) {
+			return ss
+		}
+	}
+	return nil
+}
+
+// respondEphemeral responds with a message only the member can see.
+func (st *serviceState) respondEphemeral(interaction *discordgo.Interaction, embed *discordgo.MessageEmbed) {
+	if err := st.sess.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
+		},
+	}); err != nil {
+		st.logger.Error("failed respond interaction", zap.Error(err))
+	}
+}
+
+// deferResponse tells Discord that the response follows. It reports whether it succeeded.
+func (st *serviceState) deferResponse(interaction *discordgo.Interaction) bool {
+	if err := st.sess.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		st.logger.Error("failed respond interaction", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// followup answers the interaction responded by deferResponse.
+func (st *serviceState) followup(interaction *discordgo.Interaction, embed *discordgo.MessageEmbed) {
+	if _, err := st.sess.FollowupMessageCreate(interaction, false, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	}); err != nil {
+		st.logger.Error("failed send followup message", zap.Error(err))
+	}
+}
+
+func (st *serviceState) respond(interaction *discordgo.Interaction, embed *discordgo.MessageEmbed) {
+	if err := st.sess.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+		},
+	}); err != nil {
+		st.logger.Error("failed respond interaction", zap.Error(err))
+	}
+}
@@ -0,0 +1,532 @@
+package chatspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Config is the chatspace configuration loaded from a JSON file.
+//
+//	{
+//	  "default": {"workMinutes": 45, "breakMinutes": 15, "timeZone": "Asia/Tokyo", "locale": "ja"},
+//	  "guilds": {"<guildID>": {
+//	    "workMinutes": 25, "breakMinutes": 5, "longBreakMinutes": 15, "longBreakEvery": 4,
+//	    "managedChannels": [{"channelID": "<channelID>"}, {"namePattern": "^もくもく"}, {"categoryID": "<categoryID>"}],
+//	    "muteMode": "soft", "workRoleID": "<roleID>", "exemptRoleIDs": ["<roleID>"], "channelStatus": true,
+//	    "schedules": [{"channelID": "<channelID>", "weekdays": ["mon", "tue", "wed", "thu", "fri"], "start": "09:00", "end": "12:00"}],
+//	    "afkDeafMinutes": 10, "afkIdleBreaks": 2, "afkAction": "move",
+//	    "topicsFile": "topics.txt", "topicHistory": 10
+//	  }}
+//	}
+//
+// Fields omitted in a guild entry are taken from "default". /config overrides the file.
+type Config struct {
+	Default GuildConfig
+	Guilds  map[string]GuildConfig
+	// /config で変更した設定とそれを反映した設定
+	settings  map[string]guildSettings
+	overrides map[string]GuildConfig
+}
+
+// GuildConfig is the pomodoro setting of a guild. Lengths are in minutes.
+type GuildConfig struct {
+	WorkMinutes  int `json:"workMinutes"`
+	BreakMinutes int `json:"breakMinutes"`
+	// LongBreakMinutes falls back to BreakMinutes if not set.
+	LongBreakMinutes int `json:"longBreakMinutes"`
+	// LongBreakEvery takes a long break after every N work phases (0 disables it).
+	LongBreakEvery int `json:"longBreakEvery"`
+	// CyclesPerSession ends the session after N work phases (0 means unlimited).
+	CyclesPerSession int `json:"cyclesPerSession"`
+	// ManagedChannels chooses the pomodoro rooms (ManagedChannelName if empty).
+	ManagedChannels []ManagedChannelRule `json:"managedChannels"`
+	// TimeZone is the IANA time zone of the clock times in announcements.
+	TimeZone string `json:"timeZone"`
+	// Locale chooses the format of clock times and dates: "ja" (15時4分) or "en" (3:04 PM).
+	Locale string `json:"locale"`
+	// GoalChannelID is the text channel for the goal threads (only /goal if empty).
+	GoalChannelID string `json:"goalChannelID"`
+	// WorkWarnings are the minutes before the end of work phases to announce the remaining time.
+	WorkWarnings []int `json:"workWarnings"`
+	// BreakWarnings are the minutes before the end of breaks to announce that work resumes soon.
+	BreakWarnings []int `json:"breakWarnings"`
+	// WorkChime and BreakChime are played when work phases and breaks start (WAV 以外は ffmpeg が必要)
+	WorkChime  string `json:"workChime"`
+	BreakChime string `json:"breakChime"`
+	// MuteMode is "server" or "soft" (WorkRoleID を付けるだけでミュートしない)
+	MuteMode string `json:"muteMode"`
+	// WorkRoleID is the role (such as "作業中") given to members during work phases.
+	WorkRoleID string `json:"workRoleID"`
+	// ChannelStatus shows the phase as the status of the voice channel.
+	ChannelStatus bool `json:"channelStatus"`
+	// ExemptRoleIDs and ExemptUserIDs are the members never muted.
+	ExemptRoleIDs []string `json:"exemptRoleIDs"`
+	ExemptUserIDs []string `json:"exemptUserIDs"`
+	// ExemptBots keeps bot accounts unmuted.
+	ExemptBots bool `json:"exemptBots"`
+	// ControlRoleIDs are the roles allowed to control sessions with /pomodoro (空なら始めたメンバーだけ)
+	ControlRoleIDs []string `json:"controlRoleIDs"`
+	// Schedules are the sessions opened at fixed times.
+	Schedules []SessionSchedule `json:"schedules"`
+	// AFKDeafMinutes treats members self-deafened for the minutes as away (0 disables it).
+	AFKDeafMinutes int `json:"afkDeafMinutes"`
+	// AFKIdleBreaks treats members silent through the breaks as away (0 disables it).
+	AFKIdleBreaks int `json:"afkIdleBreaks"`
+	// AFKGraceMinutes is the time from the spoken warning until the member away is removed.
+	AFKGraceMinutes int `json:"afkGraceMinutes"`
+	// AFKAction is "move" (to the AFK channel) or "disconnect".
+	AFKAction string `json:"afkAction"`
+	// BreakTopics suggests a topic or a stretch at each break (off by default).
+	BreakTopics bool `json:"breakTopics"`
+	// TopicsFile is a text file of topics, one per line (see parseTopics).
+	TopicsFile string `json:"topicsFile"`
+	// TopicHistory is the number of recent picks not suggested again.
+	TopicHistory int `json:"topicHistory"`
+	location     *time.Location
+	topics       []breakTopic
+}
+
+// ManagedChannelRule matches voice channels satisfying every field set.
+type ManagedChannelRule struct {
+	ChannelID   string `json:"channelID"`
+	NamePattern string `json:"namePattern"`
+	CategoryID  string `json:"categoryID"`
+	namePattern *regexp.Regexp
+}
+
+// SessionSchedule opens a session from Start to End (such as "09:00").
+type SessionSchedule struct {
+	ChannelID string `json:"channelID"`
+	// Weekdays are such as "mon" and "fri". The session is opened every day if it is empty.
+	Weekdays []string `json:"weekdays"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	// Name is the title of the Discord scheduled events made for the sessions.
+	Name     string `json:"name"`
+	weekdays map[time.Weekday]struct{}
+	// minutes since midnight
+	startMinute, endMinute int
+}
+
+var DefaultGuildConfig = GuildConfig{
+	WorkMinutes:     45,
+	BreakMinutes:    15,
+	TimeZone:        "Asia/Tokyo",
+	Locale:          "ja",
+	WorkWarnings:    []int{5},
+	BreakWarnings:   []int{1},
+	MuteMode:        muteModeServer,
+	ExemptBots:      true,
+	AFKGraceMinutes: 2,
+	AFKAction:       afkActionMove,
+	TopicHistory:    5,
+}
+
+const (
+	muteModeServer = "server"
+	muteModeSoft   = "soft"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+var supportedLocales = map[string]struct{}{
+	"ja": {},
+	"en": {},
+}
+
+type configFile struct {
+	Default json.RawMessage            `json:"default"`
+	Guilds  map[string]json.RawMessage `json:"guilds"`
+}
+
+// LoadConfig reads the configuration file. The default configuration is used if path is empty.
+func LoadConfig(path string) (*Config, error) {
+
+	config := &Config{
+		Default: DefaultGuildConfig.clone(),
+		Guilds:  map[string]GuildConfig{},
+	}
+	if path == "" {
+		if err := config.Default.compile(); err != nil {
+			return nil, fmt.Errorf("invalid default config: %w", err)
+		}
+		return config, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	file := configFile{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse config file: %w", err)
+	}
+
+	if file.Default != nil {
+		if err := json.Unmarshal(file.Default, &config.Default); err != nil {
+			return nil, fmt.Errorf("cannot parse default config: %w", err)
+		}
+	}
+	if err := config.Default.compile(); err != nil {
+		return nil, fmt.Errorf("invalid default config: %w", err)
+	}
+	if err := config.Default.validate(); err != nil {
+		return nil, fmt.Errorf("invalid default config: %w", err)
+	}
+
+	for guildID, raw := range file.Guilds {
+		// 未指定の項目は default の値を引き継ぐ
+		guildConfig := config.Default.clone()
+		if err := json.Unmarshal(raw, &guildConfig); err != nil {
+			return nil, fmt.Errorf("cannot parse config of guild %s: %w", guildID, err)
+		}
+		if err := guildConfig.compile(); err != nil {
+			return nil, fmt.Errorf("invalid config of guild %s: %w", guildID, err)
+		}
+		if err := guildConfig.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config of guild %s: %w", guildID, err)
+		}
+		config.Guilds[guildID] = guildConfig
+	}
+
+	return config, nil
+}
+
+// Guild returns the configuration of the guild.
+func (c *Config) Guild(guildID string) GuildConfig {
+	if guildConfig, exist := c.overrides[guildID]; exist {
+		return guildConfig
+	}
+	if guildConfig, exist := c.Guilds[guildID]; exist {
+		return guildConfig
+	}
+	return c.Default
+}
+
+// clone copies the slices so that unmarshalling into the copy leaves gc unchanged.
+func (gc GuildConfig) clone() GuildConfig {
+	gc.ManagedChannels = append([]ManagedChannelRule(nil), gc.ManagedChannels...)
+	gc.WorkWarnings = append([]int(nil), gc.WorkWarnings...)
+	gc.BreakWarnings = append([]int(nil), gc.BreakWarnings...)
+	gc.ExemptRoleIDs = append([]string(nil), gc.ExemptRoleIDs...)
+	gc.ExemptUserIDs = append([]string(nil), gc.ExemptUserIDs...)
+	gc.ControlRoleIDs = append([]string(nil), gc.ControlRoleIDs...)
+	gc.Schedules = append([]SessionSchedule(nil), gc.Schedules...)
+	for i := range gc.Schedules {
+		gc.Schedules[i].Weekdays = append([]string(nil), gc.Schedules[i].Weekdays...)
+	}
+	return gc
+}
+
+func (gc GuildConfig) validate() error {
+	switch {
+	case gc.WorkMinutes <= 0:
+		return fmt.Errorf("workMinutes must be positive")
+	case gc.BreakMinutes <= 0:
+		return fmt.Errorf("breakMinutes must be positive")
+	case gc.LongBreakEvery < 0:
+		return fmt.Errorf("longBreakEvery must not be negative")
+	case gc.CyclesPerSession < 0:
+		return fmt.Errorf("cyclesPerSession must not be negative")
+	case gc.AFKDeafMinutes < 0:
+		return fmt.Errorf("afkDeafMinutes must not be negative")
+	case gc.AFKIdleBreaks < 0:
+		return fmt.Errorf("afkIdleBreaks must not be negative")
+	case gc.AFKGraceMinutes < 0:
+		return fmt.Errorf("afkGraceMinutes must not be negative")
+	case gc.TopicHistory < 0:
+		return fmt.Errorf("topicHistory must not be negative")
+	}
+	switch gc.AFKAction {
+	case afkActionMove, afkActionDisconnect:
+	default:
+		return fmt.Errorf("unsupported afkAction: %s", gc.AFKAction)
+	}
+	if _, exist := supportedLocales[gc.Locale]; !exist {
+		return fmt.Errorf("unsupported locale: %s", gc.Locale)
+	}
+	switch gc.MuteMode {
+	case muteModeServer:
+	case muteModeSoft:
+		// ロールがなければ作業中を知らせる手段がない
+		if gc.WorkRoleID == "" {
+			return fmt.Errorf("soft muteMode requires workRoleID")
+		}
+	default:
+		return fmt.Errorf("unsupported muteMode: %s", gc.MuteMode)
+	}
+	for i, rule := range gc.ManagedChannels {
+		if rule.ChannelID == "" && rule.NamePattern == "" && rule.CategoryID == "" {
+			return fmt.Errorf("managedChannels[%d] matches every channel", i)
+		}
+	}
+	for i, schedule := range gc.Schedules {
+		if schedule.ChannelID == "" {
+			return fmt.Errorf("channelID of schedules[%d] is required", i)
+		}
+	}
+	for i, minutes := range gc.WorkWarnings {
+		if minutes <= 0 {
+			return fmt.Errorf("workWarnings[%d] must be positive", i)
+		}
+	}
+	for i, minutes := range gc.BreakWarnings {
+		if minutes <= 0 {
+			return fmt.Errorf("breakWarnings[%d] must be positive", i)
+		}
+	}
+	for _, chime := range []string{gc.WorkChime, gc.BreakChime} {
+		if chime == "" {
+			continue
+		}
+		if _, err := os.Stat(chime); err != nil {
+			return fmt.Errorf("cannot find chime file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (gc *GuildConfig) compile() error {
+	location, err := time.LoadLocation(gc.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid timeZone: %w", err)
+	}
+	gc.location = location
+
+	for i := range gc.ManagedChannels {
+		rule := &gc.ManagedChannels[i]
+		if rule.NamePattern == "" {
+			continue
+		}
+		namePattern, err := regexp.Compile(rule.NamePattern)
+		if err != nil {
+			return fmt.Errorf("invalid namePattern of managedChannels[%d]: %w", i, err)
+		}
+		rule.namePattern = namePattern
+	}
+
+	for i := range gc.Schedules {
+		if err := gc.Schedules[i].compile(); err != nil {
+			return fmt.Errorf("invalid schedules[%d]: %w", i, err)
+		}
+	}
+
+	gc.topics = nil
+	if gc.TopicsFile != "" {
+		f, err := os.Open(gc.TopicsFile)
+		if err != nil {
+			return fmt.Errorf("cannot open topics file: %w", err)
+		}
+		defer f.Close()
+		if gc.topics, err = parseTopics(f); err != nil {
+			return fmt.Errorf("invalid topicsFile: %w", err)
+		}
+	}
+	return nil
+}
+
+func (schedule *SessionSchedule) compile() error {
+	schedule.weekdays = map[time.Weekday]struct{}{}
+	for _, name := range schedule.Weekdays {
+		weekday, exist := weekdayNames[strings.ToLower(name)]
+		if !exist {
+			return fmt.Errorf("unknown weekday: %s", name)
+		}
+		schedule.weekdays[weekday] = struct{}{}
+	}
+
+	start, err := time.Parse("15:04", schedule.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := time.Parse("15:04", schedule.End)
+	if err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+	schedule.startMinute = start.Hour()*60 + start.Minute()
+	schedule.endMinute = end.Hour()*60 + end.Minute()
+	if schedule.endMinute <= schedule.startMinute {
+		return fmt.Errorf("end must be after start")
+	}
+	return nil
+}
+
+// next returns the first session of the schedule which has not ended at now.
+func (schedule SessionSchedule) next(location *time.Location, now time.Time) (start, end time.Time, exist bool) {
+	local := now.In(location)
+	for days := 0; days <= 7; days++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+days, 0, 0, 0, 0, location)
+		if _, exist := schedule.weekdays[day.Weekday()]; len(schedule.weekdays) > 0 && !exist {
+			continue
+		}
+		start = time.Date(day.Year(), day.Month(), day.Day(), 0, schedule.startMinute, 0, 0, location)
+		end = time.Date(day.Year(), day.Month(), day.Day(), 0, schedule.endMinute, 0, 0, location)
+		if end.After(now) {
+			return start, end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// IsManagedChannel reports whether the channel is run as a pomodoro room.
+func (gc GuildConfig) IsManagedChannel(ch *discordgo.Channel) bool {
+	if len(gc.ManagedChannels) == 0 {
+		return ch.Name == ManagedChannelName
+	}
+	for _, rule := range gc.ManagedChannels {
+		if rule.matches(ch) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule ManagedChannelRule) matches(ch *discordgo.Channel) bool {
+	switch {
+	case rule.ChannelID != "" && rule.ChannelID != ch.ID:
+		return false
+	case rule.CategoryID != "" && rule.CategoryID != ch.ParentID:
+		return false
+	case rule.namePattern != nil && !rule.namePattern.MatchString(ch.Name):
+		return false
+	}
+	return true
+}
+
+// softMute reports whether work phases are signalled without server mute.
+func (gc GuildConfig) softMute() bool {
+	return gc.MuteMode == muteModeSoft
+}
+
+// isExempt reports whether the member is never muted.
+func (gc GuildConfig) isExempt(member *discordgo.Member) bool {
+	if member.User != nil {
+		if gc.ExemptBots && member.User.Bot {
+			return true
+		}
+		for _, userID := range gc.ExemptUserIDs {
+			if userID == member.User.ID {
+				return true
+			}
+		}
+	}
+	for _, roleID := range member.Roles {
+		for _, exemptRoleID := range gc.ExemptRoleIDs {
+			if roleID == exemptRoleID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canControl reports whether the member is allowed to control the session started by ownerID.
+func (gc GuildConfig) canControl(member *discordgo.Member, ownerID string) bool {
+	if member.Permissions&(discordgo.PermissionManageChannels|discordgo.PermissionAdministrator) != 0 {
+		return true
+	}
+	if len(gc.ControlRoleIDs) == 0 {
+		return ownerID != "" && member.User.ID == ownerID
+	}
+	for _, roleID := range member.Roles {
+		for _, controlRoleID := range gc.ControlRoleIDs {
+			if roleID == controlRoleID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextScheduledSession returns the first session of the schedule which has not ended at now.
+func (gc GuildConfig) nextScheduledSession(schedule SessionSchedule, now time.Time) (start, end time.Time, exist bool) {
+	location := gc.location
+	if location == nil {
+		location = time.UTC
+	}
+	return schedule.next(location, now)
+}
+
+// localTime returns the time in the time zone of the guild.
+func (gc GuildConfig) localTime(t time.Time) time.Time {
+	if gc.location != nil {
+		return t.In(gc.location)
+	}
+	return t
+}
+
+// dateKey returns the date in the time zone of the guild used as a key of the stats.
+func (gc GuildConfig) dateKey(t time.Time) string {
+	return gc.localTime(t).Format(dateLayout)
+}
+
+// formatClock formats the time of day in the time zone and locale of the guild.
+func (gc GuildConfig) formatClock(t time.Time) string {
+	t = gc.localTime(t)
+	switch gc.Locale {
+	case "en":
+		return t.Format("3:04 PM")
+	}
+	return fmt.Sprintf("%d時%d分", t.Hour(), t.Minute())
+}
+
+// formatDate formats the month and day in the time zone and locale of the guild.
+func (gc GuildConfig) formatDate(t time.Time) string {
+	t = gc.localTime(t)
+	switch gc.Locale {
+	case "en":
+		return t.Format("Jan 2")
+	}
+	return t.Format("1/2")
+}
+
+// cycleLabel shows the cycle out of the cycles of the session, or nothing if it is unlimited.
+func (gc GuildConfig) cycleLabel(cycle int) string {
+	if gc.CyclesPerSession == 0 {
+		return ""
+	}
+	return fmt.Sprintf("（%d/%d）", cycle, gc.CyclesPerSession)
+}
+
+func (gc GuildConfig) workTime() time.Duration {
+	return time.Duration(gc.WorkMinutes) * timeStep
+}
+
+// isLongBreak reports whether the break after the cycle-th work phase is a long one.
+func (gc GuildConfig) isLongBreak(cycle int) bool {
+	return gc.LongBreakEvery > 0 && cycle > 0 && cycle%gc.LongBreakEvery == 0
+}
+
+// breakMinutes returns the length of the break after the cycle-th work phase
+// (cycle 0 is the break before the first work phase).
+func (gc GuildConfig) breakMinutes(cycle int) int {
+	if gc.isLongBreak(cycle) && gc.LongBreakMinutes > 0 {
+		return gc.LongBreakMinutes
+	}
+	return gc.BreakMinutes
+}
+
+func (gc GuildConfig) breakTime(cycle int) time.Duration {
+	return time.Duration(gc.breakMinutes(cycle)) * timeStep
+}
+
+// isLastCycle reports whether the session ends after the cycle-th work phase.
+func (gc GuildConfig) isLastCycle(cycle int) bool {
+	return gc.CyclesPerSession > 0 && cycle >= gc.CyclesPerSession
+}
@@ -0,0 +1,159 @@
+package chatspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// configOption is an option of /config set and the field of GuildConfig it changes.
+type configOption struct {
+	name       string
+	key        string
+	label      string
+	optionType discordgo.ApplicationCommandOptionType
+	min        float64
+	choices    []string
+}
+
+// /config で変更できる設定
+var configOptions = []configOption{
+	{name: "work", key: "workMinutes", label: "作業時間 (分)", optionType: discordgo.ApplicationCommandOptionInteger, min: 1},
+	{name: "break", key: "breakMinutes", label: "休憩時間 (分)", optionType: discordgo.ApplicationCommandOptionInteger, min: 1},
+	{name: "long-break", key: "longBreakMinutes", label: "長めの休憩 (分)", optionType: discordgo.ApplicationCommandOptionInteger, min: 1},
+	{name: "long-break-every", key: "longBreakEvery", label: "長めの休憩までの作業回数 (0 でなし)", optionType: discordgo.ApplicationCommandOptionInteger, min: 0},
+	{name: "cycles", key: "cyclesPerSession", label: "セッションの作業回数 (0 で無制限)", optionType: discordgo.ApplicationCommandOptionInteger, min: 0},
+	{name: "mute-mode", key: "muteMode", label: "作業中の知らせ方 (server: サーバーミュート, soft: ロールだけ)", optionType: discordgo.ApplicationCommandOptionString, choices: []string{muteModeServer, muteModeSoft}},
+	{name: "locale", key: "locale", label: "時刻と日付の書式 (ja: 15時4分, en: 3:04 PM)", optionType: discordgo.ApplicationCommandOptionString, choices: []string{"ja", "en"}},
+	{name: "work-role", key: "workRoleID", label: "作業中に付けるロール", optionType: discordgo.ApplicationCommandOptionRole},
+}
+
+func configCommandOptions() []*discordgo.ApplicationCommandOption {
+	options := []*discordgo.ApplicationCommandOption{}
+	for _, option := range configOptions {
+		commandOption := &discordgo.ApplicationCommandOption{
+			Type:        option.optionType,
+			Name:        option.name,
+			Description: option.label,
+		}
+		if option.optionType == discordgo.ApplicationCommandOptionInteger {
+			min := option.min
+			commandOption.MinValue = &min
+		}
+		for _, choice := range option.choices {
+			commandOption.Choices = append(commandOption.Choices, &discordgo.ApplicationCommandOptionChoice{Name: choice, Value: choice})
+		}
+		options = append(options, commandOption)
+	}
+	return options
+}
+
+// settingValue encodes the value of the option as it is in the configuration file.
+func (st *serviceState) settingValue(guildID string, option configOption, value *discordgo.ApplicationCommandInteractionDataOption) (json.RawMessage, error) {
+	switch option.optionType {
+	case discordgo.ApplicationCommandOptionInteger:
+		return json.Marshal(value.IntValue())
+	case discordgo.ApplicationCommandOptionRole:
+		roleID := value.Value.(string)
+		// @everyone や連携アプリのロールは付け外しできない
+		role, err := st.sess.State.Role(guildID, roleID)
+		if err != nil || role.ID == guildID || role.Managed {
+			return nil, fmt.Errorf("<@&%s> はメンバーに付けられるロールではありません", roleID)
+		}
+		return json.Marshal(roleID)
+	}
+	return json.Marshal(value.StringValue())
+}
+
+func (st *serviceState) configure(event *discordgo.InteractionCreate, subCommand string, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	authorID := event.Member.User.ID
+	if event.Member.Permissions&(discordgo.PermissionManageServer|discordgo.PermissionAdministrator) == 0 {
+		st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{
+			Title: "🙅設定を変更する権限がありません",
+		})
+		return
+	}
+
+	switch subCommand {
+	case "show":
+		st.respondEphemeral(event.Interaction, configEmbed("⚙️このサーバーの設定", st.config.Guild(event.GuildID)))
+
+	case "set":
+		if len(options) == 0 {
+			st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{Title: "🤔変更する設定を指定してください"})
+			return
+		}
+		settings := st.config.guildSettings(event.GuildID)
+		for _, option := range options {
+			for _, configOption := range configOptions {
+				if configOption.name != option.Name {
+					continue
+				}
+				value, err := st.settingValue(event.GuildID, configOption, option)
+				if err != nil {
+					st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{
+						Title:       "🤔この設定にはできません",
+						Description: err.Error(),
+					})
+					return
+				}
+				settings[configOption.key] = value
+			}
+		}
+		st.saveSettings(event, authorID, settings)
+
+	case "reset":
+		st.saveSettings(event, authorID, guildSettings{})
+	}
+}
+
+func (st *serviceState) saveSettings(event *discordgo.InteractionCreate, authorID string, settings guildSettings) {
+	previous := st.config.guildSettings(event.GuildID)
+	if err := st.config.setSettings(event.GuildID, settings); err != nil {
+		st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{
+			Title:       "🤔この設定にはできません",
+			Description: err.Error(),
+		})
+		return
+	}
+	if err := st.stores.settings.save(event.GuildID, settings); err != nil {
+		st.logger.Error("cannot save guild settings", zap.Error(err))
+		st.config.setSettings(event.GuildID, previous)
+		st.respondEphemeral(event.Interaction, &discordgo.MessageEmbed{Title: "🤯設定を保存できませんでした"})
+		return
+	}
+
+	st.logger.Info("changed guild settings", zap.String("guildID", event.GuildID), zap.String("userID", authorID), zap.Int("settings", len(settings)))
+	embed := configEmbed("⚙️設定を変更しました", st.config.Guild(event.GuildID))
+	embed.Description = "次のセッションから反映されます。"
+	st.respond(event.Interaction, embed)
+}
+
+func configEmbed(title string, config GuildConfig) *discordgo.MessageEmbed {
+	values := map[string]json.RawMessage{}
+	if b, err := json.Marshal(config); err == nil {
+		json.Unmarshal(b, &values)
+	}
+
+	lines := []string{}
+	for _, option := range configOptions {
+		var value interface{}
+		json.Unmarshal(values[option.key], &value)
+		switch {
+		case value == nil || value == "":
+			value = "-"
+		case option.optionType == discordgo.ApplicationCommandOptionRole:
+			value = fmt.Sprintf("<@&%s>", value)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %v", option.label, value))
+	}
+	return &discordgo.MessageEmbed{
+		Title: title,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "ポモドーロとミュート", Value: strings.Join(lines, "\n")},
+		},
+	}
+}
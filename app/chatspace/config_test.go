@@ -0,0 +1,211 @@
+package chatspace
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestLoadConfig(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{
+		"default": {"workMinutes": 50},
+		"guilds": {"guild": {"workMinutes": 25, "breakMinutes": 5, "longBreakEvery": 4, "workWarnings": [3]}}
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := config.Guild("unknown"); d.WorkMinutes != 50 || d.BreakMinutes != 15 {
+		t.Errorf("unexpected default config: %+v", d)
+	}
+
+	g := config.Guild("guild")
+	if g.WorkMinutes != 25 || g.BreakMinutes != 5 || g.breakMinutes(4) != 5 {
+		t.Errorf("unexpected guild config: %+v", g)
+	}
+	if g.isLongBreak(3) || !g.isLongBreak(4) || g.isLongBreak(0) {
+		t.Errorf("unexpected long break cycles: %+v", g)
+	}
+	if len(g.WorkWarnings) != 1 || g.WorkWarnings[0] != 3 || len(g.BreakWarnings) != 1 || g.BreakWarnings[0] != 1 {
+		t.Errorf("unexpected guild warnings: %+v", g)
+	}
+	if d := config.Guild("unknown"); len(d.WorkWarnings) != 1 || d.WorkWarnings[0] != 5 || DefaultGuildConfig.WorkWarnings[0] != 5 {
+		t.Errorf("default warnings are overwritten: %+v", d)
+	}
+}
+
+func TestManagedChannels(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{
+		"guilds": {"guild": {"managedChannels": [
+			{"channelID": "room"},
+			{"namePattern": "^作業", "categoryID": "study"}
+		]}}
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		guildID  string
+		channel  discordgo.Channel
+		expected bool
+	}{
+		{"guild", discordgo.Channel{ID: "room", Name: "雑談"}, true},
+		{"guild", discordgo.Channel{ID: "a", Name: "作業部屋", ParentID: "study"}, true},
+		{"guild", discordgo.Channel{ID: "b", Name: "作業部屋", ParentID: "other"}, false},
+		{"guild", discordgo.Channel{ID: "c", Name: ManagedChannelName}, false},
+		{"unknown", discordgo.Channel{ID: "c", Name: ManagedChannelName}, true},
+		{"unknown", discordgo.Channel{ID: "room", Name: "雑談"}, false},
+	}
+
+	for _, tc := range testcases {
+		if actual := config.Guild(tc.guildID).IsManagedChannel(&tc.channel); actual != tc.expected {
+			t.Errorf("%s/%s: expected %v, got %v", tc.guildID, tc.channel.ID, tc.expected, actual)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"default": {"managedChannels": [{"namePattern": "("}]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected error for invalid namePattern")
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{
+		"guilds": {"guild": {"timeZone": "America/New_York"}, "en": {"timeZone": "America/New_York", "locale": "en"}}
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2022, 10, 1, 6, 5, 0, 0, time.UTC)
+	if actual := config.Guild("unknown").formatClock(at); actual != "15時5分" {
+		t.Errorf("unexpected default clock: %s", actual)
+	}
+	if actual := config.Guild("guild").formatClock(at); actual != "2時5分" {
+		t.Errorf("unexpected guild clock: %s", actual)
+	}
+	if actual := config.Guild("en").formatClock(at); actual != "2:05 AM" {
+		t.Errorf("unexpected english clock: %s", actual)
+	}
+	if actual := config.Guild("en").formatDate(at); actual != "Oct 1" {
+		t.Errorf("unexpected english date: %s", actual)
+	}
+	if actual := config.Guild("unknown").formatDate(at); actual != "10/1" {
+		t.Errorf("unexpected default date: %s", actual)
+	}
+
+	for _, invalid := range []string{
+		`{"default": {"timeZone": "Mars/Olympus_Mons"}}`,
+		`{"default": {"locale": "fr"}}`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestIsExempt(t *testing.T) {
+
+	gc := DefaultGuildConfig.clone()
+	gc.ExemptRoleIDs = []string{"moderator"}
+	gc.ExemptUserIDs = []string{"owner"}
+
+	testcases := []struct {
+		member   discordgo.Member
+		expected bool
+	}{
+		{member: discordgo.Member{User: &discordgo.User{ID: "user"}, Roles: []string{"member"}}, expected: false},
+		{member: discordgo.Member{User: &discordgo.User{ID: "user"}, Roles: []string{"member", "moderator"}}, expected: true},
+		{member: discordgo.Member{User: &discordgo.User{ID: "owner"}}, expected: true},
+		{member: discordgo.Member{User: &discordgo.User{ID: "bot", Bot: true}}, expected: true},
+	}
+
+	for _, tc := range testcases {
+		if actual := gc.isExempt(&tc.member); actual != tc.expected {
+			t.Errorf("isExempt(%s) = %v, expected %v", tc.member.User.ID, actual, tc.expected)
+		}
+	}
+
+	gc.ExemptBots = false
+	if gc.isExempt(&discordgo.Member{User: &discordgo.User{ID: "bot", Bot: true}}) {
+		t.Errorf("bot is exempted without exemptBots")
+	}
+}
+
+func TestGuildSettings(t *testing.T) {
+
+	config := &Config{Default: DefaultGuildConfig}
+	if err := config.setSettings("guild", guildSettings{"workMinutes": json.RawMessage("25")}); err != nil {
+		t.Fatal(err)
+	}
+	if gc := config.Guild("guild"); gc.WorkMinutes != 25 || gc.BreakMinutes != DefaultGuildConfig.BreakMinutes {
+		t.Errorf("unexpected guild config: %+v", gc)
+	}
+
+	// ロールのないソフトモードにはできない
+	if err := config.setSettings("guild", guildSettings{"muteMode": json.RawMessage(`"soft"`)}); err == nil {
+		t.Error("expected error for soft muteMode without workRoleID")
+	}
+	if gc := config.Guild("guild"); gc.WorkMinutes != 25 || gc.MuteMode != muteModeServer {
+		t.Errorf("rejected settings are applied: %+v", gc)
+	}
+	if err := config.setSettings("guild", guildSettings{"muteMode": json.RawMessage(`"soft"`), "workRoleID": json.RawMessage(`"role"`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.setSettings("guild", guildSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if gc := config.Guild("guild"); gc.WorkMinutes != DefaultGuildConfig.WorkMinutes || gc.MuteMode != muteModeServer {
+		t.Errorf("settings are not reset: %+v", gc)
+	}
+}
+
+func TestCanControl(t *testing.T) {
+
+	owner := &discordgo.Member{User: &discordgo.User{ID: "owner"}}
+	member := &discordgo.Member{User: &discordgo.User{ID: "member"}, Roles: []string{"control"}}
+	manager := &discordgo.Member{User: &discordgo.User{ID: "manager"}, Permissions: discordgo.PermissionManageChannels}
+
+	// ロールの指定がなければセッションを始めた人と管理者だけ
+	gc := GuildConfig{}
+	if !gc.canControl(owner, "owner") || gc.canControl(member, "owner") || !gc.canControl(manager, "owner") {
+		t.Error("unexpected control without controlRoleIDs")
+	}
+	if gc.canControl(owner, "") {
+		t.Error("scheduled session is controlled by a member")
+	}
+
+	gc.ControlRoleIDs = []string{"control"}
+	if gc.canControl(owner, "owner") || !gc.canControl(member, "owner") || !gc.canControl(manager, "") {
+		t.Error("unexpected control with controlRoleIDs")
+	}
+}
@@ -0,0 +1,247 @@
+package chatspace
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var (
+	errSessionFinished = errors.New("session is already finished")
+	errAlreadyPaused   = errors.New("phase is already paused")
+	errNotPaused       = errors.New("phase is not paused")
+)
+
+// workClock returns the time the work is measured until, which stops while paused.
+func (ss *ServerStatus) workClock(now time.Time) time.Time {
+	if ss.isPaused() && !ss.pausedAt.IsZero() {
+		return ss.pausedAt
+	}
+	return now
+}
+
+// isPaused reports whether the current phase is paused
+func (ss *ServerStatus) isPaused() bool {
+	return ss.pausedRemaining > 0
+}
+
+// schedulePhaseEnd schedules the switch at the end of the current phase
+func (ss *ServerStatus) schedulePhaseEnd() {
+	if ss.mode == serverStatusModeWork {
+		ss.phaseEvent = ss.schedules.Schedule(ss.phaseEndAt, ss.Switch2Chat)
+	} else {
+		ss.phaseEvent = ss.schedules.Schedule(ss.phaseEndAt, ss.Switch2Work)
+	}
+	ss.scheduleWarnings()
+}
+
+// cancelPhaseEnd cancels the switch and the warnings of the current phase
+func (ss *ServerStatus) cancelPhaseEnd() {
+	if ss.phaseEvent != nil {
+		ss.phaseEvent.Cancel()
+		ss.phaseEvent = nil
+	}
+	ss.cancelWarnings()
+}
+
+// Pause stops the timer of the current phase until ResumePhase.
+func (ss *ServerStatus) Pause() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	switch {
+	case ss.isClosed || ss.finished:
+		return errSessionFinished
+	case ss.isPaused():
+		return errAlreadyPaused
+	}
+
+	now := time.Now()
+	ss.cancelPhaseEnd()
+	ss.pausedRemaining = ss.phaseEndAt.Sub(now)
+	if ss.pausedRemaining <= 0 {
+		// 切り替えの直前に止めた場合も一時停止として扱う
+		ss.pausedRemaining = time.Second
+	}
+	// 一時停止中は作業時間に数えず、再開したら止めた分だけ開始時刻をずらす
+	ss.pausedAt = now
+	ss.logger.Info("paused the phase", zap.Duration("remaining", ss.pausedRemaining))
+
+	ss.updateChannelStatus()
+	ss.announce(false, "タイマーを一時停止しました。")
+	ss.touchStatus()
+	ss.saveSession()
+	return nil
+}
+
+// ResumePhase restarts the timer of the paused phase.
+func (ss *ServerStatus) ResumePhase() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	switch {
+	case ss.isClosed || ss.finished:
+		return errSessionFinished
+	case !ss.isPaused():
+		return errNotPaused
+	}
+
+	now := time.Now()
+	var paused time.Duration
+	if !ss.pausedAt.IsZero() {
+		paused = now.Sub(ss.pausedAt)
+	}
+	ss.phaseEndAt = now.Add(ss.pausedRemaining)
+	ss.pausedRemaining = 0
+	ss.pausedAt = time.Time{}
+	for memberId := range ss.memberIDs {
+		if startedAt, exist := ss.workStartedAt[memberId]; exist {
+			ss.workStartedAt[memberId] = startedAt.Add(paused)
+		} else {
+			// 一時停止中に来た人や再起動後の人はここから数える
+			ss.startWork(memberId, now)
+		}
+	}
+	ss.schedulePhaseEnd()
+	ss.logger.Info("resumed the phase", zap.Time("phaseEndAt", ss.phaseEndAt))
+
+	ss.updateChannelStatus()
+	ss.announce(false, "タイマーを再開しました。")
+	ss.announce(false, fmt.Sprintf("%sは%sです。", ss.nextPhaseName(), ss.config.formatClock(ss.phaseEndAt)))
+	ss.touchStatus()
+	ss.saveSession()
+	return nil
+}
+
+// Extend makes the current phase longer by the minutes.
+func (ss *ServerStatus) Extend(minutes int) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	switch {
+	case ss.isClosed || ss.finished:
+		return errSessionFinished
+	case minutes <= 0:
+		return fmt.Errorf("minutes must be positive: %d", minutes)
+	}
+
+	extension := time.Duration(minutes) * timeStep
+	if ss.isPaused() {
+		ss.pausedRemaining += extension
+	} else {
+		ss.phaseEndAt = ss.phaseEndAt.Add(extension)
+		if ss.phaseEvent == nil || !ss.phaseEvent.Reschedule(ss.phaseEndAt) {
+			ss.schedulePhaseEnd()
+		} else {
+			ss.scheduleWarnings()
+		}
+	}
+	ss.logger.Info("extended the phase", zap.Int("minutes", minutes))
+	ss.updateChannelStatus()
+
+	ss.announce(false, fmt.Sprintf("%sを%d分延長しました。", ss.phaseName(), minutes))
+	if !ss.isPaused() {
+		ss.announce(false, fmt.Sprintf("%sは%sです。", ss.nextPhaseName(), ss.config.formatClock(ss.phaseEndAt)))
+	}
+	ss.touchStatus()
+	ss.saveSession()
+	return nil
+}
+
+// Skip ends the current phase now and starts the next one.
+func (ss *ServerStatus) Skip() error {
+	next, err := func() (func(), error) {
+		ss.lock.Lock()
+		defer ss.lock.Unlock()
+
+		if ss.isClosed || ss.finished {
+			return nil, errSessionFinished
+		}
+
+		ss.cancelPhaseEnd()
+		now := ss.workClock(time.Now())
+		ss.pausedRemaining = 0
+		ss.pausedAt = time.Time{}
+		ss.logger.Info("skipped the phase", zap.Stringer("mode", ss.mode))
+		ss.announce(false, fmt.Sprintf("%sをスキップします。", ss.phaseName()))
+		if ss.mode == serverStatusModeWork {
+			// 途中で切り上げた作業はポモドーロに数えない
+			for memberId := range ss.memberIDs {
+				ss.recordWork(memberId, now, false)
+			}
+			return ss.Switch2Chat, nil
+		}
+		return ss.Switch2Work, nil
+	}()
+	if err != nil {
+		return err
+	}
+	next()
+	return nil
+}
+
+// EndSession finishes the session before its cycles are done and closes the room.
+func (ss *ServerStatus) EndSession() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.isClosed || ss.finished {
+		return errSessionFinished
+	}
+
+	ss.logger.Info("ended the session by command", zap.Int("cycle", ss.cycle))
+	ss.finishSession(false)
+
+	ss.announce(false, "セッションを終了しました。お疲れ様でした。")
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "🏁セッションを終了しました",
+		Description: fmt.Sprintf("%d回の作業お疲れ様でした。自由に話してください。", ss.cycle),
+	})
+	ss.touchStatus()
+	ss.saveSession()
+	ss.scheduleClose()
+	return nil
+}
+
+// finishSession ends the phases and unmutes the members. completed credits the work in progress as a pomodoro.
+func (ss *ServerStatus) finishSession(completed bool) {
+	now := time.Now()
+	for memberId := range ss.memberIDs {
+		ss.recordWork(memberId, now, completed)
+	}
+	ss.cancelPhaseEnd()
+	ss.mode = serverStatusModeChat
+	ss.finished = true
+	ss.pausedRemaining = 0
+	ss.pausedAt = time.Time{}
+	ss.phaseEndAt = time.Time{}
+	for memberId := range ss.memberIDs {
+		ss.setMute(memberId, false)
+	}
+	ss.updateChannelStatus()
+}
+
+// phaseName returns the name of the current phase
+func (ss *ServerStatus) phaseName() string {
+	if ss.mode == serverStatusModeWork {
+		return "作業時間"
+	}
+	return "休憩時間"
+}
+
+// nextPhaseName returns the name of the phase after the current one
+func (ss *ServerStatus) nextPhaseName() string {
+	if ss.mode == serverStatusModeWork {
+		return "次の休憩時間"
+	}
+	return "次の作業時間"
+}
+
+// pausedMinutes returns the remaining minutes of the paused phase
+func (ss *ServerStatus) pausedMinutes() int {
+	return int(math.Ceil(float64(ss.pausedRemaining) / float64(timeStep)))
+}
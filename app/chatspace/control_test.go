@@ -0,0 +1,190 @@
+package chatspace
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// fakeDiscord answers every request of the Discord API with success and records them.
+type fakeDiscord struct {
+	lock     sync.Mutex
+	requests []string
+}
+
+func (f *fakeDiscord) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.lock.Lock()
+	f.requests = append(f.requests, req.Method+" "+req.URL.Path)
+	f.lock.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id": "message"}`)),
+		Request:    req,
+	}, nil
+}
+
+// requested reports whether a request with the method and the path ending with suffix was sent.
+func (f *fakeDiscord) requested(method, suffix string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, method+" ") && strings.HasSuffix(request, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestSession(t *testing.T) (*discordgo.Session, *fakeDiscord) {
+	t.Helper()
+
+	sess, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDiscord{}
+	sess.Client = &http.Client{Transport: fake}
+	return sess, fake
+}
+
+func newTestRoom(t *testing.T, scheduler *Scheduler, stores *localStores) *ServerStatus {
+	t.Helper()
+
+	sess, _ := newTestSession(t)
+	config := DefaultGuildConfig.clone()
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	ss, err := newServerStatus(zap.NewNop(), sess, nil, scheduler, stores, config, "guild", "room", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.memberIDs["a"] = struct{}{}
+	return ss
+}
+
+func TestPhaseControl(t *testing.T) {
+
+	scheduler := NewScheduler()
+	defer scheduler.Stop()
+	stores := newLocalStores(t.TempDir())
+	ss := newTestRoom(t, scheduler, stores)
+
+	ss.Switch2Work()
+	if ss.mode != serverStatusModeWork || ss.cycle != 1 || ss.phaseEvent == nil || !ss.phaseEvent.At().Equal(ss.phaseEndAt) {
+		t.Fatalf("work phase is not scheduled: %+v", ss.phaseEvent)
+	}
+
+	// 一時停止中は切り替えが予定されず、残り時間が保存される
+	if err := ss.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Pause(); err != errAlreadyPaused {
+		t.Errorf("expected already paused error, got %v", err)
+	}
+	if ss.phaseEvent != nil || ss.pausedRemaining <= 44*timeStep {
+		t.Errorf("unexpected paused phase: %v remaining", ss.pausedRemaining)
+	}
+	if err := ss.Extend(5); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := stores.sessions.loadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := saved["room"]; record.PausedRemaining != ss.pausedRemaining || record.PausedRemaining <= 49*timeStep {
+		t.Errorf("unexpected saved paused phase: %+v", record)
+	}
+
+	// 再起動しても一時停止したまま復元される
+	ss.stop()
+	restartedScheduler := NewScheduler()
+	defer restartedScheduler.Stop()
+	restarted := newTestRoom(t, restartedScheduler, stores)
+	restarted.resume(advancePhase(restarted.config, saved["room"], time.Now().Add(2*time.Hour)))
+	if !restarted.isPaused() || restarted.mode != serverStatusModeWork || restarted.phaseEvent != nil {
+		t.Fatalf("paused session is not restored: %v remaining", restarted.pausedRemaining)
+	}
+
+	if err := restarted.ResumePhase(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.isPaused() || restarted.phaseEvent == nil || !restarted.phaseEvent.At().Equal(restarted.phaseEndAt) || time.Until(restarted.phaseEndAt) <= 49*timeStep {
+		t.Fatalf("resumed phase is not scheduled: %v", restarted.phaseEndAt)
+	}
+
+	// 延長すると切り替えが予定し直される
+	phaseEndAt := restarted.phaseEndAt
+	if err := restarted.Extend(10); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.phaseEvent.At().Equal(phaseEndAt.Add(10 * timeStep)) {
+		t.Errorf("extended phase is not rescheduled: %v", restarted.phaseEvent.At())
+	}
+
+	// 予定の時刻になれば休憩に切り替わる (ステータスの更新は1分ごとに予定し直されるので先の時刻は渡さない)
+	now := time.Now()
+	restarted.phaseEvent.Reschedule(now)
+	restartedScheduler.RunDue(now)
+	if restarted.mode != serverStatusModeChat || restarted.cycle != 1 || !restarted.phaseEvent.At().Equal(restarted.phaseEndAt) {
+		t.Fatalf("phase is not switched on schedule: %v", restarted.mode)
+	}
+
+	if err := restarted.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.mode != serverStatusModeWork || restarted.cycle != 2 {
+		t.Errorf("break is not skipped: %v %d", restarted.mode, restarted.cycle)
+	}
+
+	if err := restarted.EndSession(); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.finished || restarted.phaseEvent != nil {
+		t.Errorf("session is not ended: %+v", restarted.phaseEvent)
+	}
+	if err := restarted.Skip(); err != errSessionFinished {
+		t.Errorf("expected finished error, got %v", err)
+	}
+	if saved, err := stores.sessions.loadAll(); err != nil || !saved["room"].Finished {
+		t.Errorf("finished session is not saved: %+v %v", saved["room"], err)
+	}
+}
+
+func TestPauseKeepsWork(t *testing.T) {
+
+	scheduler := NewScheduler()
+	defer scheduler.Stop()
+	stores := newLocalStores(t.TempDir())
+	ss := newTestRoom(t, scheduler, stores)
+	ss.config.WorkMinutes = 25
+	ss.Switch2Work()
+
+	// 20分作業してから10分止め、再開して5分で作業時間を終える
+	if err := ss.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	ss.pausedAt = time.Now().Add(-10 * timeStep)
+	ss.workStartedAt["a"] = ss.pausedAt.Add(-20 * timeStep)
+	if err := ss.ResumePhase(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ss.recordWork("a", now.Add(5*timeStep), true)
+
+	gs, err := stores.stats.guild("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work := gs.Members["a"][ss.config.dateKey(now)]; work.WorkMinutes != 25 || work.Pomodoros != 1 {
+		t.Errorf("work before the pause is not credited: %+v", work)
+	}
+}
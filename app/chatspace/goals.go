@@ -0,0 +1,216 @@
+package chatspace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streamwest-1629/chatspace/util"
+	"go.uber.org/zap"
+)
+
+// スレッドは1日で自動アーカイブする
+const goalThreadArchiveMinutes = 60 * 24
+
+// goalKey returns the work phase a goal declared now is for (休憩中なら次の作業時間)
+func (ss *ServerStatus) goalKey() goalKey {
+	cycle := ss.cycle
+	if ss.mode == serverStatusModeChat {
+		cycle++
+	}
+	return goalKey{SessionStartedAt: ss.startedAt, Cycle: cycle}
+}
+
+// outcomeKey returns the work phase an outcome reported now is for
+func (ss *ServerStatus) outcomeKey() goalKey {
+	return goalKey{SessionStartedAt: ss.startedAt, Cycle: ss.cycle}
+}
+
+// SetGoal declares the goal of the member.
+func (ss *ServerStatus) SetGoal(userID, goal string) (cycle int, err error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.setGoal(userID, goal)
+}
+
+// SetOutcome reports how the last work phase went for the member.
+func (ss *ServerStatus) SetOutcome(userID, outcome string) (cycle int, err error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.setOutcome(userID, outcome)
+}
+
+func (ss *ServerStatus) setGoal(userID, goal string) (int, error) {
+	key := ss.goalKey()
+	return key.Cycle, ss.stores.stats.updateGoal(ss.guildID, userID, key, ss.config.dateKey(time.Now()), func(record *goalRecord) {
+		record.Goal = goal
+	})
+}
+
+func (ss *ServerStatus) setOutcome(userID, outcome string) (int, error) {
+	key := ss.outcomeKey()
+	if key.Cycle == 0 {
+		return 0, fmt.Errorf("no work phase has finished yet")
+	}
+	return key.Cycle, ss.stores.stats.updateGoal(ss.guildID, userID, key, ss.config.dateKey(time.Now()), func(record *goalRecord) {
+		record.Outcome = outcome
+	})
+}
+
+// onGoalThreadMessage records a message in the goal thread as the goal while working, or as the outcome during a break.
+func (ss *ServerStatus) onGoalThreadMessage(event *discordgo.MessageCreate) {
+	content := strings.TrimSpace(event.ContentWithMentionsReplaced())
+	if content == "" {
+		return
+	}
+
+	var err error
+	if ss.mode == serverStatusModeWork {
+		_, err = ss.setGoal(event.Author.ID, content)
+	} else {
+		_, err = ss.setOutcome(event.Author.ID, content)
+	}
+	if err != nil {
+		ss.logger.Error("cannot save goal", zap.String("userID", event.Author.ID), zap.Error(err))
+		return
+	}
+
+	if err := ss.sess.MessageReactionAdd(event.ChannelID, event.ID, "✅"); err != nil {
+		ss.logger.Warn("cannot add reaction", zap.Error(err))
+	}
+}
+
+// openGoalThread invites the members to declare their goals of the work phase
+func (ss *ServerStatus) openGoalThread() {
+	ss.goalThreadID = ""
+
+	mentions := []string{}
+	for _, memberID := range sortedKeys(ss.memberIDs) {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", memberID))
+	}
+
+	if ss.config.GoalChannelID == "" {
+		ss.sendEmbed(&discordgo.MessageEmbed{
+			Title:       "🎯今回の目標を宣言しましょう",
+			Description: "`/goal set` で今回の作業で取り組むことを宣言してください。休憩時間に読み上げます。",
+		})
+		return
+	}
+
+	msg, err := ss.sess.ChannelMessageSendEmbed(ss.config.GoalChannelID, &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("🎯%d回目の作業の目標", ss.cycle),
+		Description: strings.Join(mentions, " ") + "\nこのスレッドに今回の作業で取り組むことを書き込んでください。休憩時間に読み上げます。",
+	})
+	if err != nil {
+		ss.logger.Error("failed send message", zap.String("channelID", ss.config.GoalChannelID), zap.Error(err))
+		return
+	}
+
+	name := fmt.Sprintf("%s %d回目の目標", ss.config.formatDate(time.Now()), ss.cycle)
+	thread, err := ss.sess.MessageThreadStart(ss.config.GoalChannelID, msg.ID, name, goalThreadArchiveMinutes)
+	if err != nil {
+		ss.logger.Error("cannot start goal thread", zap.Error(err))
+		return
+	}
+	ss.goalThreadID = thread.ID
+}
+
+// reviewGoals reads aloud the goals of the finished work phase and asks how it went
+func (ss *ServerStatus) reviewGoals() {
+	if ss.cycle == 0 {
+		return
+	}
+
+	goals, err := ss.stores.stats.goalsOf(ss.guildID, ss.outcomeKey())
+	if err != nil {
+		ss.logger.Error("cannot load goals", zap.Error(err))
+		return
+	}
+	if len(goals) == 0 {
+		return
+	}
+
+	userIDs := make([]string, 0, len(goals))
+	for userID := range goals {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	lines := []string{}
+	ss.announce(false, "今回の目標を振り返りましょう。")
+	for _, userID := range userIDs {
+		goal := goals[userID].Goal
+		if goal == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("<@%s>: %s", userID, goal))
+
+		if name, err := ss.memberName(userID); err == nil {
+			ss.announce(false, name+"さんの目標")
+		}
+		if speakerID, err := ss.memberVoiceID(userID); err == nil && ss.voiceConn != nil {
+			for _, content := range util.WordSpliter(goal) {
+				ss.voiceConn.Speak(speakerID, false, content)
+			}
+		}
+	}
+	ss.announce(false, "目標は達成できましたか。結果を書き込んでください。")
+
+	how := "`/goal result` で結果を書き込んでください。"
+	channelID := ss.channelID
+	if ss.goalThreadID != "" {
+		how = "このスレッドか `/goal result` で結果を書き込んでください。"
+		channelID = ss.goalThreadID
+	}
+	if _, err := ss.sess.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+		Title:       "📝どうでしたか？",
+		Description: strings.Join(lines, "\n"),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: how,
+		},
+	}); err != nil {
+		ss.logger.Error("failed send message", zap.String("channelID", channelID), zap.Error(err))
+	}
+}
+
+// goalHistoryEmbed shows the latest goals of the member.
+func (st *serviceState) goalHistoryEmbed(guildID, memberID string) *discordgo.MessageEmbed {
+	stats, err := st.stores.stats.guild(guildID)
+	if err != nil {
+		st.logger.Error("cannot load stats", zap.Error(err))
+		return &discordgo.MessageEmbed{
+			Title: "🤯記録を読み込めませんでした",
+		}
+	}
+
+	records := stats.Goals[memberID]
+	lines := []string{}
+	// 新しいものから10件
+	for i := len(records) - 1; i >= 0 && len(lines) < 10; i-- {
+		record := records[i]
+		line := fmt.Sprintf("**%s %d回目** %s", record.Date, record.Cycle, record.Goal)
+		if record.Outcome != "" {
+			line += "\n→ " + record.Outcome
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "まだ目標が宣言されていません。")
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "🎯目標の記録",
+		Description: fmt.Sprintf("<@%s> さんの目標です。\n\n%s", memberID, strings.Join(lines, "\n")),
+	}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package chatspace
+
+import (
+	"encoding/json"
+
+	"github.com/streamwest-1629/chatspace/lib/store"
+)
+
+// guildSettings are the settings changed by /config, keyed by the JSON names of GuildConfig.
+type guildSettings map[string]json.RawMessage
+
+// guildID -> settings
+type guildSettingsData map[string]guildSettings
+
+type settingsStore struct {
+	file *store.File[guildSettingsData]
+}
+
+func newSettingsStore(path string) *settingsStore {
+	return &settingsStore{
+		file: store.NewFile[guildSettingsData](path),
+	}
+}
+
+func (s *settingsStore) loadAll() (guildSettingsData, error) {
+	return s.file.Load()
+}
+
+// save replaces the settings of the guild. Empty settings are removed.
+func (s *settingsStore) save(guildID string, settings guildSettings) error {
+	return s.file.Update(func(data *guildSettingsData) error {
+		if *data == nil {
+			*data = guildSettingsData{}
+		}
+		if len(settings) == 0 {
+			delete(*data, guildID)
+		} else {
+			(*data)[guildID] = settings
+		}
+		return nil
+	})
+}
+
+// withSettings returns the configuration of the guild in the file with the settings applied.
+func (c *Config) withSettings(guildID string, settings guildSettings) (GuildConfig, error) {
+	guildConfig := c.Default
+	if fileConfig, exist := c.Guilds[guildID]; exist {
+		guildConfig = fileConfig
+	}
+	guildConfig = guildConfig.clone()
+
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return GuildConfig{}, err
+	}
+	if err := json.Unmarshal(b, &guildConfig); err != nil {
+		return GuildConfig{}, err
+	}
+	if err := guildConfig.compile(); err != nil {
+		return GuildConfig{}, err
+	}
+	if err := guildConfig.validate(); err != nil {
+		return GuildConfig{}, err
+	}
+	return guildConfig, nil
+}
+
+// setSettings validates the settings of the guild and uses them in place of the configuration file.
+func (c *Config) setSettings(guildID string, settings guildSettings) error {
+	if len(settings) == 0 {
+		delete(c.settings, guildID)
+		delete(c.overrides, guildID)
+		return nil
+	}
+
+	guildConfig, err := c.withSettings(guildID, settings)
+	if err != nil {
+		return err
+	}
+	if c.settings == nil {
+		c.settings = map[string]guildSettings{}
+		c.overrides = map[string]GuildConfig{}
+	}
+	c.settings[guildID] = settings
+	c.overrides[guildID] = guildConfig
+	return nil
+}
+
+// guildSettings returns a copy of the settings of the guild changed by /config.
+func (c *Config) guildSettings(guildID string) guildSettings {
+	settings := guildSettings{}
+	for key, value := range c.settings[guildID] {
+		settings[key] = value
+	}
+	return settings
+}
@@ -0,0 +1,99 @@
+package chatspace
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streamwest-1629/chatspace/lib/store"
+	"go.uber.org/zap"
+)
+
+// muteRecord is a server mute or a work role given by this bot.
+type muteRecord struct {
+	ChannelID string    `json:"channelID"`
+	MutedAt   time.Time `json:"mutedAt"`
+	// RoleID is the work role given to the member.
+	RoleID string `json:"roleID,omitempty"`
+	// SoftMute is true if the member is not server-muted (the soft mode).
+	SoftMute bool `json:"softMute,omitempty"`
+}
+
+// guildID -> userID -> mute
+type muteLedgerData map[string]map[string]muteRecord
+
+// muteLedger keeps track of the members muted by this bot to undo them even after a crash.
+type muteLedger struct {
+	file *store.File[muteLedgerData]
+}
+
+func newMuteLedger(path string) *muteLedger {
+	return &muteLedger{
+		file: store.NewFile[muteLedgerData](path),
+	}
+}
+
+func (l *muteLedger) loadAll() (muteLedgerData, error) {
+	return l.file.Load()
+}
+
+func (l *muteLedger) add(guildID, userID string, record muteRecord) error {
+	if record.MutedAt.IsZero() {
+		record.MutedAt = time.Now().UTC()
+	}
+	return l.file.Update(func(data *muteLedgerData) error {
+		if *data == nil {
+			*data = muteLedgerData{}
+		}
+		if (*data)[guildID] == nil {
+			(*data)[guildID] = map[string]muteRecord{}
+		}
+		(*data)[guildID][userID] = record
+		return nil
+	})
+}
+
+func (l *muteLedger) remove(guildID, userID string) error {
+	return l.file.Update(func(data *muteLedgerData) error {
+		delete((*data)[guildID], userID)
+		if len((*data)[guildID]) == 0 {
+			delete(*data, guildID)
+		}
+		return nil
+	})
+}
+
+// setMemberMute mutes or unmutes the member and records it in the ledger.
+// (ミュートは記録してから行い、解除は行ってから記録を消す)
+func setMemberMute(logger *zap.Logger, sess *discordgo.Session, ledger *muteLedger, guildID, userID string, record muteRecord, mute bool) error {
+	if record.SoftMute && record.RoleID == "" {
+		return nil
+	}
+
+	if mute {
+		if err := ledger.add(guildID, userID, record); err != nil {
+			return err
+		}
+		if !record.SoftMute {
+			if err := sess.GuildMemberMute(guildID, userID, true); err != nil {
+				return err
+			}
+		}
+		if record.RoleID != "" {
+			return sess.GuildMemberRoleAdd(guildID, userID, record.RoleID)
+		}
+		return nil
+	}
+
+	// 話せないままにならないようミュートを先に解除し、ロールは外せなくても記録から消す
+	if !record.SoftMute {
+		if err := sess.GuildMemberMute(guildID, userID, false); err != nil {
+			return err
+		}
+	}
+	if record.RoleID != "" {
+		if err := sess.GuildMemberRoleRemove(guildID, userID, record.RoleID); err != nil {
+			logger.Warn("cannot remove work role", zap.String("userID", userID), zap.String("roleID", record.RoleID), zap.Error(err))
+		}
+	}
+	return ledger.remove(guildID, userID)
+}
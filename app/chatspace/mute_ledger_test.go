@@ -0,0 +1,93 @@
+package chatspace
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+func TestMuteLedger(t *testing.T) {
+
+	ledger := newMuteLedger(filepath.Join(t.TempDir(), "mutes.json"))
+	if err := ledger.add("guild", "a", muteRecord{ChannelID: "channel"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ledger.loadAll(); err != nil {
+		t.Fatal(err)
+	} else if record := data["guild"]["a"]; record.MutedAt.IsZero() {
+		t.Errorf("mute time is not recorded: %+v", record)
+	}
+
+	// 最後のミュートを外したギルドは残さない
+	if err := ledger.remove("guild", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ledger.loadAll(); len(data) != 0 {
+		t.Errorf("empty guild is left in the ledger: %+v", data)
+	}
+}
+
+func TestDecideMuteAction(t *testing.T) {
+
+	inVoice := &discordgo.VoiceState{ChannelID: "other", Mute: true}
+	muted := muteRecord{ChannelID: "channel", RoleID: "working"}
+	soft := muteRecord{ChannelID: "channel", RoleID: "working", SoftMute: true}
+	testcases := []struct {
+		name           string
+		record         muteRecord
+		voiceState     *discordgo.VoiceState
+		mutedBySession bool
+		expected       muteAction
+	}{
+		{"working", muted, inVoice, true, muteActionKeep},
+		{"left voice", muted, nil, false, muteActionWait},
+		{"unmuted by someone", muted, &discordgo.VoiceState{ChannelID: "other"}, false, muteActionForget},
+		{"left muted", muted, inVoice, false, muteActionUnmute},
+		{"working softly", soft, nil, true, muteActionKeep},
+		{"left with role", soft, nil, false, muteActionUnmute},
+	}
+
+	for _, tc := range testcases {
+		if actual := decideMuteAction(tc.record, tc.voiceState, tc.mutedBySession); actual != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestReconcileDisconnected(t *testing.T) {
+
+	sess, fake := newTestSession(t)
+	ledger := newMuteLedger(filepath.Join(t.TempDir(), "mutes.json"))
+	reconciler := &muteReconciler{logger: zap.NewNop(), sess: sess, ledger: ledger}
+	if err := ledger.add("guild", "muted", muteRecord{ChannelID: "room", RoleID: "working"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.add("guild", "soft", muteRecord{ChannelID: "room", RoleID: "working", SoftMute: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 切断したメンバーはボイスチャンネルにいない
+	reconciler.reconcileUser(map[string]*ServerStatus{}, "guild", "muted")
+	reconciler.reconcileUser(map[string]*ServerStatus{}, "guild", "soft")
+
+	if !fake.requested("DELETE", "/members/muted/roles/working") || !fake.requested("DELETE", "/members/soft/roles/working") {
+		t.Errorf("work roles are not removed: %v", fake.requests)
+	}
+	if fake.requested("PATCH", "/members/muted") {
+		t.Errorf("disconnected member is unmuted: %v", fake.requests)
+	}
+
+	data, err := ledger.loadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// サーバーミュートは次に来たときに解除するので記録を残す
+	if record, exist := data["guild"]["muted"]; !exist || record.RoleID != "" {
+		t.Errorf("unexpected record of disconnected member: %+v", data)
+	}
+	if _, exist := data["guild"]["soft"]; exist {
+		t.Errorf("record of soft mute is not removed: %+v", data)
+	}
+}
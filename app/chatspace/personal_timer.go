@@ -0,0 +1,194 @@
+package chatspace
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// personalTimer runs the pomodoro of a member outside the managed rooms. (イベントループからだけ触る)
+type personalTimer struct {
+	logger   *zap.Logger
+	sess     *discordgo.Session
+	stats    *statsStore
+	sessions *sessionStore
+	config   GuildConfig
+	// 部屋のセッションと同じ形で保存し、同じ規則でフェーズを進める
+	record sessionRecord
+	// 作業時間の開始時刻 (休憩中はゼロ)
+	workStartedAt time.Time
+	schedules     *ScheduleGroup
+	onFinish      func()
+}
+
+func newPersonalTimer(logger *zap.Logger, sess *discordgo.Session, scheduler *Scheduler, stores *localStores, config GuildConfig, record sessionRecord, onFinish func()) *personalTimer {
+	return &personalTimer{
+		logger:    logger.With(zap.String("feature", "personalTimer"), zap.String("guildID", record.GuildID), zap.String("userID", record.Timer.UserID)),
+		sess:      sess,
+		stats:     stores.stats,
+		sessions:  stores.sessions,
+		config:    config.withTimer(record.Timer),
+		record:    record,
+		schedules: scheduler.NewGroup(),
+		onFinish:  onFinish,
+	}
+}
+
+// withTimer returns the configuration with the lengths of the personal timer.
+func (gc GuildConfig) withTimer(timer *timerRecord) GuildConfig {
+	gc.WorkMinutes = timer.WorkMinutes
+	gc.BreakMinutes = timer.BreakMinutes
+	gc.LongBreakMinutes = timer.LongBreakMinutes
+	gc.CyclesPerSession = timer.CyclesPerSession
+	return gc
+}
+
+// start begins the first work phase.
+func (pt *personalTimer) start() {
+	pt.record.Mode = serverStatusModeChat
+	pt.record.PhaseEndAt = time.Now()
+	pt.record.StartedAt = pt.record.PhaseEndAt.UTC()
+	pt.switchPhase()
+}
+
+// resume continues the timer restored after a restart. The phases passed while stopped are skipped.
+func (pt *personalTimer) resume() {
+	now := time.Now()
+	pt.record = advancePhase(pt.config, pt.record, now)
+	if pt.record.Mode == serverStatusModeWork {
+		pt.workStartedAt = now
+	}
+	pt.logger.Info("resumed personal timer", zap.Stringer("mode", pt.record.Mode), zap.Int("cycle", pt.record.Cycle))
+	pt.save()
+	pt.schedules.Schedule(pt.record.PhaseEndAt, pt.switchPhase)
+}
+
+// switchPhase ends the current phase and starts the next one, or finishes the timer after the last cycle.
+func (pt *personalTimer) switchPhase() {
+	now := time.Now()
+	if pt.record.Mode == serverStatusModeWork {
+		pt.recordWork(now, true)
+	}
+	pt.record = nextPhase(pt.config, pt.record)
+
+	switch {
+	case pt.record.Finished:
+		pt.logger.Info("personal timer finished all cycles", zap.Int("cycle", pt.record.Cycle))
+		pt.notify(&discordgo.MessageEmbed{
+			Title:       "🎉タイマー終了です！",
+			Description: fmt.Sprintf("%d回の作業お疲れ様でした。", pt.record.Cycle),
+		})
+		pt.forget()
+		pt.onFinish()
+		return
+
+	case pt.record.Mode == serverStatusModeWork:
+		pt.workStartedAt = now
+		pt.logger.Info("personal timer started work", zap.Int("cycle", pt.record.Cycle))
+		pt.notify(&discordgo.MessageEmbed{
+			Title:       "🚀作業時間です！" + pt.config.cycleLabel(pt.record.Cycle),
+			Description: fmt.Sprintf("作業は%d分間です。", pt.config.WorkMinutes),
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("休憩時間は%sごろからです", pt.config.formatClock(pt.record.PhaseEndAt)),
+			},
+		})
+
+	default:
+		title := "🌿休憩時間です！"
+		if pt.config.isLongBreak(pt.record.Cycle) {
+			title = "☕長めの休憩時間です！"
+		}
+		pt.notify(&discordgo.MessageEmbed{
+			Title:       title,
+			Description: fmt.Sprintf("休憩は%d分間です。しっかり休みましょう。", pt.config.breakMinutes(pt.record.Cycle)),
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("作業時間は%sごろからです", pt.config.formatClock(pt.record.PhaseEndAt)),
+			},
+		})
+	}
+
+	pt.save()
+	pt.schedules.Schedule(pt.record.PhaseEndAt, pt.switchPhase)
+}
+
+// stop ends the timer, saving the work measured so far.
+func (pt *personalTimer) stop() {
+	pt.suspend()
+	pt.forget()
+}
+
+// suspend stops the timer on shutdown and keeps it saved to be resumed after the restart.
+func (pt *personalTimer) suspend() {
+	pt.recordWork(time.Now(), false)
+	if canceled := pt.schedules.CancelAll(); canceled > 0 {
+		pt.logger.Debug("canceled pending schedules", zap.Int("count", canceled))
+	}
+}
+
+func (pt *personalTimer) save() {
+	if err := pt.sessions.save(pt.record); err != nil {
+		pt.logger.Error("cannot save personal timer", zap.Error(err))
+	}
+}
+
+func (pt *personalTimer) forget() {
+	if err := pt.sessions.remove(pt.record.key()); err != nil {
+		pt.logger.Error("cannot remove saved personal timer", zap.Error(err))
+	}
+}
+
+// recordWork saves the work phase in progress to the same stats as the rooms.
+func (pt *personalTimer) recordWork(now time.Time, completed bool) {
+	if pt.workStartedAt.IsZero() {
+		return
+	}
+	work := dayStats{
+		WorkMinutes: int(math.Round(float64(now.Sub(pt.workStartedAt)) / float64(timeStep))),
+	}
+	pt.workStartedAt = time.Time{}
+	if completed {
+		work.Pomodoros = 1
+	}
+	if work.isZero() {
+		return
+	}
+	if err := pt.stats.addWork(pt.record.GuildID, "", pt.record.Timer.UserID, pt.config.dateKey(now), work); err != nil {
+		pt.logger.Error("cannot save stats", zap.Error(err))
+	}
+}
+
+// statusEmbed shows the current phase of the timer.
+func (pt *personalTimer) statusEmbed(now time.Time) *discordgo.MessageEmbed {
+	phase := "🌿休憩時間"
+	if pt.record.Mode == serverStatusModeWork {
+		phase = "🚀作業時間"
+	}
+	minutes := int(math.Ceil(float64(pt.record.PhaseEndAt.Sub(now)) / float64(timeStep)))
+	if minutes < 0 {
+		minutes = 0
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "⏱️個人タイマー" + pt.config.cycleLabel(pt.record.Cycle),
+		Description: fmt.Sprintf("次の切り替えは <t:%d:R>", pt.record.PhaseEndAt.Unix()),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "現在", Value: phase, Inline: true},
+			{Name: "残り", Value: fmt.Sprintf("約%d分（%sまで）", minutes, pt.config.formatClock(pt.record.PhaseEndAt)), Inline: true},
+			{Name: "サイクル", Value: fmt.Sprintf("%d回目", pt.record.Cycle), Inline: true},
+		},
+	}
+}
+
+// notify sends the embed to the member by DM.
+func (pt *personalTimer) notify(embed *discordgo.MessageEmbed) {
+	ch, err := pt.sess.UserChannelCreate(pt.record.Timer.UserID)
+	if err != nil {
+		pt.logger.Error("cannot create DM channel", zap.Error(err))
+		return
+	}
+	if _, err := pt.sess.ChannelMessageSendEmbed(ch.ID, embed); err != nil {
+		pt.logger.Error("failed send DM", zap.Error(err))
+	}
+}
@@ -0,0 +1,84 @@
+package chatspace
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPersonalTimerRecordWork(t *testing.T) {
+
+	stores := newLocalStores(t.TempDir())
+	stats := stores.stats
+	if err := stats.addWork("guild", "room", "other", "2023-01-02", dayStats{WorkMinutes: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := NewScheduler()
+	defer scheduler.Stop()
+	config := DefaultGuildConfig.clone()
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	record := sessionRecord{GuildID: "guild", Timer: &timerRecord{UserID: "user", WorkMinutes: 25, BreakMinutes: 5, CyclesPerSession: 4}}
+	pt := newPersonalTimer(zap.NewNop(), nil, scheduler, stores, config, record, func() {})
+
+	now := time.Now()
+	pt.workStartedAt = now.Add(-25 * timeStep)
+	pt.recordWork(now, true)
+
+	gs, err := stats.guild("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work := gs.Members["user"][config.dateKey(now)]; work.WorkMinutes != 25 || work.Pomodoros != 1 {
+		t.Errorf("unexpected personal work: %+v", work)
+	}
+	// 個人タイマーはランキングの投稿先を変えない
+	if gs.ChannelID != "room" {
+		t.Errorf("leaderboard channel is changed: %s", gs.ChannelID)
+	}
+
+	// 計測中でなければ記録しない
+	pt.recordWork(now, true)
+	if gs, _ := stats.guild("guild"); gs.Members["user"][config.dateKey(now)].Pomodoros != 1 {
+		t.Errorf("work is recorded twice: %+v", gs.Members["user"])
+	}
+}
+
+func TestPersonalTimerResume(t *testing.T) {
+
+	stores := newLocalStores(t.TempDir())
+	scheduler := NewScheduler()
+	defer scheduler.Stop()
+	config := DefaultGuildConfig.clone()
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 作業1回目の途中で止まったタイマーは、過ぎたフェーズを進めて再開する
+	now := time.Now()
+	record := sessionRecord{
+		GuildID:    "guild",
+		Mode:       serverStatusModeWork,
+		Cycle:      1,
+		PhaseEndAt: now.Add(-time.Minute),
+		Timer:      &timerRecord{UserID: "user", WorkMinutes: 25, BreakMinutes: 5, CyclesPerSession: 4},
+	}
+	pt := newPersonalTimer(zap.NewNop(), nil, scheduler, stores, config, record, func() {})
+	pt.resume()
+	pt.suspend()
+
+	saved, err := stores.sessions.loadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, exist := saved[timerKey("guild", "user")]
+	if !exist {
+		t.Fatalf("personal timer is not saved: %+v", saved)
+	}
+	if resumed.Mode != serverStatusModeChat || resumed.Cycle != 1 || !resumed.PhaseEndAt.Equal(record.PhaseEndAt.Add(5*timeStep)) {
+		t.Errorf("unexpected resumed timer: %+v", resumed)
+	}
+}
@@ -0,0 +1,147 @@
+package chatspace
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// muteReconcileInterval is how often the ledger is checked for mutes left behind.
+var muteReconcileInterval = 5 * time.Minute
+
+type muteAction int
+
+const (
+	// keep the mute
+	muteActionKeep muteAction = iota
+	// the member cannot be unmuted until joining a voice channel
+	muteActionWait
+	// the member has already been unmuted by someone else
+	muteActionForget
+	muteActionUnmute
+)
+
+// decideMuteAction decides what to do with a member in the ledger. (voiceState はボイスチャンネル外なら nil)
+func decideMuteAction(record muteRecord, voiceState *discordgo.VoiceState, mutedBySession bool) muteAction {
+	switch {
+	case mutedBySession:
+		return muteActionKeep
+	case record.SoftMute:
+		// ロールは外すのにボイスチャンネルにいる必要がない
+		return muteActionUnmute
+	case voiceState == nil || voiceState.ChannelID == "":
+		return muteActionWait
+	case !voiceState.Mute:
+		return muteActionForget
+	}
+	return muteActionUnmute
+}
+
+// muteReconciler unmutes the members left muted by this bot.
+type muteReconciler struct {
+	logger *zap.Logger
+	sess   *discordgo.Session
+	ledger *muteLedger
+}
+
+// reconcile checks every mute in the ledger against the running rooms.
+func (r *muteReconciler) reconcile(serverStatuses map[string]*ServerStatus) {
+	data, err := r.ledger.loadAll()
+	if err != nil {
+		r.logger.Error("cannot load mute ledger", zap.Error(err))
+		return
+	}
+
+	for guildID, mutes := range data {
+		for userID, record := range mutes {
+			r.reconcileMember(serverStatuses, guildID, userID, record)
+		}
+	}
+}
+
+// reconcileGuild checks the mutes in the ledger of the guild.
+func (r *muteReconciler) reconcileGuild(serverStatuses map[string]*ServerStatus, guildID string) {
+	data, err := r.ledger.loadAll()
+	if err != nil {
+		r.logger.Error("cannot load mute ledger", zap.Error(err))
+		return
+	}
+
+	for userID, record := range data[guildID] {
+		r.reconcileMember(serverStatuses, guildID, userID, record)
+	}
+}
+
+// reconcileUser checks the mute of the member if it is in the ledger.
+func (r *muteReconciler) reconcileUser(serverStatuses map[string]*ServerStatus, guildID, userID string) {
+	data, err := r.ledger.loadAll()
+	if err != nil {
+		r.logger.Error("cannot load mute ledger", zap.Error(err))
+		return
+	}
+
+	if record, exist := data[guildID][userID]; exist {
+		r.reconcileMember(serverStatuses, guildID, userID, record)
+	}
+}
+
+func (r *muteReconciler) reconcileMember(serverStatuses map[string]*ServerStatus, guildID, userID string, record muteRecord) {
+	voiceState, err := r.sess.State.VoiceState(guildID, userID)
+	if err != nil {
+		voiceState = nil
+	}
+
+	logger := r.logger.With(
+		zap.String("guildID", guildID),
+		zap.String("userID", userID),
+		zap.String("mutedChannelID", record.ChannelID),
+		zap.Time("mutedAt", record.MutedAt),
+	)
+
+	mutedBySession := false
+	for _, ss := range serverStatuses {
+		if ss.guildID == guildID && ss.mutesMember(userID) {
+			mutedBySession = true
+			break
+		}
+	}
+
+	switch decideMuteAction(record, voiceState, mutedBySession) {
+	case muteActionKeep:
+
+	case muteActionWait:
+		logger.Debug("stale mute is waiting for the member to join voice")
+		if record.RoleID != "" {
+			// ロールは先に外しておく
+			if err := r.sess.GuildMemberRoleRemove(guildID, userID, record.RoleID); err != nil {
+				logger.Error("cannot remove work role", zap.Error(err))
+				return
+			}
+			record.RoleID = ""
+			if err := r.ledger.add(guildID, userID, record); err != nil {
+				logger.Error("cannot update mute ledger", zap.Error(err))
+			}
+			logger.Warn("removed work role left behind")
+		}
+
+	case muteActionForget:
+		logger.Info("forget stale mute already undone")
+		if record.RoleID != "" {
+			if err := r.sess.GuildMemberRoleRemove(guildID, userID, record.RoleID); err != nil {
+				logger.Error("cannot remove work role", zap.Error(err))
+				return
+			}
+		}
+		if err := r.ledger.remove(guildID, userID); err != nil {
+			logger.Error("cannot update mute ledger", zap.Error(err))
+		}
+
+	case muteActionUnmute:
+		if err := setMemberMute(r.logger, r.sess, r.ledger, guildID, userID, record, false); err != nil {
+			logger.Error("cannot change mute", zap.String("changeTo", "unmute"), zap.Error(err))
+			return
+		}
+		logger.Warn("unmuted member left muted", zap.Bool("softMute", record.SoftMute))
+	}
+}
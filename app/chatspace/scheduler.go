@@ -0,0 +1,247 @@
+package chatspace
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Scheduler runs functions at the given times on the goroutine calling RunDue.
+type Scheduler struct {
+	lock   sync.Mutex
+	events scheduleHeap
+	seq    uint64
+	timer  *time.Timer
+	wake   chan struct{}
+}
+
+// ScheduledEvent is a handle to cancel or reschedule a scheduled function.
+type ScheduledEvent struct {
+	scheduler *Scheduler
+	group     *ScheduleGroup
+	at        time.Time
+	seq       uint64
+	index     int
+	fn        func()
+}
+
+func NewScheduler() *Scheduler {
+	s := &Scheduler{
+		wake: make(chan struct{}, 1),
+	}
+	s.timer = time.AfterFunc(time.Hour, s.notify)
+	s.timer.Stop()
+	return s
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wake returns the channel notified when the earliest event becomes due.
+func (s *Scheduler) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// Schedule registers fn to be run at the time.
+func (s *Scheduler) Schedule(at time.Time, fn func()) *ScheduledEvent {
+	return s.schedule(at, fn, nil)
+}
+
+func (s *Scheduler) schedule(at time.Time, fn func(), group *ScheduleGroup) *ScheduledEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	event := &ScheduledEvent{
+		scheduler: s,
+		group:     group,
+		at:        at,
+		seq:       s.seq,
+		fn:        fn,
+	}
+	heap.Push(&s.events, event)
+	s.resetTimer()
+	return event
+}
+
+// RunDue runs every event scheduled at or before now in the order of time.
+func (s *Scheduler) RunDue(now time.Time) {
+	for {
+		s.lock.Lock()
+		if len(s.events) == 0 || s.events[0].at.After(now) {
+			s.resetTimer()
+			s.lock.Unlock()
+			return
+		}
+		event := heap.Pop(&s.events).(*ScheduledEvent)
+		s.lock.Unlock()
+
+		if event.group != nil {
+			event.group.remove(event)
+		}
+		event.fn()
+	}
+}
+
+// Next returns the time of the earliest event.
+func (s *Scheduler) Next() (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.events) == 0 {
+		return time.Time{}, false
+	}
+	return s.events[0].at, true
+}
+
+// Stop drops every event and stops notifying.
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, event := range s.events {
+		event.index = -1
+	}
+	s.events = nil
+	s.timer.Stop()
+}
+
+// s.lock を取得した状態で呼ぶ
+func (s *Scheduler) resetTimer() {
+	if len(s.events) == 0 {
+		s.timer.Stop()
+		return
+	}
+	s.timer.Reset(time.Until(s.events[0].at))
+}
+
+// At returns the time the event is scheduled at.
+func (e *ScheduledEvent) At() time.Time {
+	e.scheduler.lock.Lock()
+	defer e.scheduler.lock.Unlock()
+	return e.at
+}
+
+// Cancel removes the event. It returns false if the event has already been run or canceled.
+func (e *ScheduledEvent) Cancel() bool {
+	s := e.scheduler
+	s.lock.Lock()
+	if e.index < 0 {
+		s.lock.Unlock()
+		return false
+	}
+	heap.Remove(&s.events, e.index)
+	s.resetTimer()
+	s.lock.Unlock()
+
+	if e.group != nil {
+		e.group.remove(e)
+	}
+	return true
+}
+
+// Reschedule moves the event to the time. It returns false if the event has already been run or canceled.
+func (e *ScheduledEvent) Reschedule(at time.Time) bool {
+	s := e.scheduler
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e.index < 0 {
+		return false
+	}
+	e.at = at
+	heap.Fix(&s.events, e.index)
+	s.resetTimer()
+	return true
+}
+
+// ScheduleGroup tracks the pending events of one owner to cancel them together.
+type ScheduleGroup struct {
+	scheduler *Scheduler
+	lock      sync.Mutex
+	events    map[*ScheduledEvent]struct{}
+}
+
+func (s *Scheduler) NewGroup() *ScheduleGroup {
+	return &ScheduleGroup{
+		scheduler: s,
+		events:    map[*ScheduledEvent]struct{}{},
+	}
+}
+
+func (g *ScheduleGroup) Schedule(at time.Time, fn func()) *ScheduledEvent {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	event := g.scheduler.schedule(at, fn, g)
+	g.events[event] = struct{}{}
+	return event
+}
+
+// CancelAll cancels every pending event of the group and returns how many were canceled.
+func (g *ScheduleGroup) CancelAll() int {
+	g.lock.Lock()
+	events := g.events
+	g.events = map[*ScheduledEvent]struct{}{}
+	g.lock.Unlock()
+
+	canceled := 0
+	for event := range events {
+		if event.Cancel() {
+			canceled++
+		}
+	}
+	return canceled
+}
+
+// Len returns the number of pending events of the group.
+func (g *ScheduleGroup) Len() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return len(g.events)
+}
+
+func (g *ScheduleGroup) remove(event *ScheduledEvent) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.events, event)
+}
+
+// 時刻順 (同時刻は登録順) の最小ヒープ
+type scheduleHeap []*ScheduledEvent
+
+func (h scheduleHeap) Len() int {
+	return len(h)
+}
+
+func (h scheduleHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduleHeap) Push(x any) {
+	event := x.(*ScheduledEvent)
+	event.index = len(*h)
+	*h = append(*h, event)
+}
+
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	event := old[len(old)-1]
+	old[len(old)-1] = nil
+	event.index = -1
+	*h = old[:len(old)-1]
+	return event
+}
@@ -0,0 +1,86 @@
+package chatspace
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+
+	s := NewScheduler()
+	defer s.Stop()
+
+	base := time.Now()
+	result := []string{}
+	record := func(name string) func() {
+		return func() { result = append(result, name) }
+	}
+
+	s.Schedule(base.Add(3*time.Second), record("c"))
+	s.Schedule(base.Add(1*time.Second), record("a"))
+	s.Schedule(base.Add(1*time.Second), record("b"))
+	canceled := s.Schedule(base.Add(2*time.Second), record("canceled"))
+	moved := s.Schedule(base.Add(4*time.Second), record("moved"))
+
+	if !canceled.Cancel() {
+		t.Fatal("expected to cancel pending event")
+	}
+	if canceled.Cancel() {
+		t.Fatal("expected not to cancel twice")
+	}
+	if !moved.Reschedule(base.Add(2 * time.Second)) {
+		t.Fatal("expected to reschedule pending event")
+	}
+
+	s.RunDue(base.Add(2 * time.Second))
+	if expected := []string{"a", "b", "moved"}; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+
+	if next, ok := s.Next(); !ok || !next.Equal(base.Add(3*time.Second)) {
+		t.Fatalf("unexpected next event: %v, %v", next, ok)
+	}
+}
+
+func TestScheduleGroup(t *testing.T) {
+
+	s := NewScheduler()
+	defer s.Stop()
+
+	group := s.NewGroup()
+	run := false
+	group.Schedule(time.Now(), func() { run = true })
+	group.Schedule(time.Now().Add(time.Hour), func() { run = true })
+	other := s.Schedule(time.Now().Add(time.Hour), func() {})
+
+	if n := group.CancelAll(); n != 2 {
+		t.Fatalf("expected 2 canceled events, got %d", n)
+	}
+	s.RunDue(time.Now())
+	if run {
+		t.Fatal("canceled event was run")
+	}
+	if !other.Cancel() {
+		t.Fatal("event out of the group was canceled")
+	}
+}
+
+func TestSchedulerWake(t *testing.T) {
+
+	s := NewScheduler()
+	defer s.Stop()
+
+	run := false
+	s.Schedule(time.Now().Add(10*time.Millisecond), func() { run = true })
+
+	select {
+	case <-s.Wake():
+		s.RunDue(time.Now())
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not wake up")
+	}
+	if !run {
+		t.Fatal("due event was not run")
+	}
+}
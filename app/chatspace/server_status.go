@@ -2,7 +2,9 @@ package chatspace
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,122 +21,529 @@ const (
 	serverStatusModeWork
 )
 
-var (
-	timeStep  = time.Minute
-	chatTimes = 15 * timeStep
-	workTimes = 45 * timeStep
-)
+// timeStep is the length of a "minute" in GuildConfig (shortened in debug mode).
+var timeStep = time.Minute
+
+// 終わったセッションは最後のお知らせを読み上げてから閉じる
+var finishedRoomLinger = 30 * time.Second
+
+// アナウンスは聞き取りやすいように少しゆっくり話す
+var announceProsody = voicevox.Prosody{
+	SpeedScale:        voicevox.ProsodyValue(0.9),
+	PostPhonemeLength: voicevox.ProsodyValue(0.3),
+}
 
 func SetTimeStep(ts time.Duration) {
 	timeStep = ts
-	chatTimes = 15 * timeStep
-	workTimes = 45 * timeStep
 }
 
 type ServerStatus struct {
-	lock              sync.Mutex
-	logger            *zap.Logger
-	isClosed          bool
-	sess              *discordgo.Session
-	voiceConn         *voicevox.ManagedDiscordVoiceConnection
-	guildID           string
-	channelID         string
-	announceSpeaker   voicevox.VoiceSpeaker
-	mode              serverStatusMode
-	scheduler         *ScheduleQueue
+	// 自分で取得するものを除き、非公開メソッドは取得済みの状態で呼ぶ
+	lock            sync.Mutex
+	logger          *zap.Logger
+	isClosed        bool
+	sess            *discordgo.Session
+	voicevoxApp     *voicevox.VoiceVox
+	voiceLogger     *zap.Logger
+	voiceConn       *voicevox.ManagedDiscordVoiceConnection
+	stopSpeaking    func()
+	guildID         string
+	channelID       string
+	announceSpeaker voicevox.VoiceSpeaker
+	// 読み上げ役がまだ決まっていない (announceSpeaker は保存された名前だけ)
+	speakerPending bool
+	config         GuildConfig
+	mode           serverStatusMode
+	cycle          int
+	finished       bool
+	phaseEndAt     time.Time
+	stores         *localStores
+	schedules      *ScheduleGroup
+	phaseEvent     *ScheduledEvent
+	warningEvents  []*ScheduledEvent
+	// 一時停止中のフェーズの残り時間 (0 なら動いている)
+	pausedRemaining time.Duration
+	// 一時停止した時刻 (再起動で復元した一時停止ではゼロ)
+	pausedAt time.Time
+	// 予定された作業会の終了時刻 (入室で始まったセッションではゼロ)
+	scheduledEndAt time.Time
+	// 入室してセッションを始めたメンバー (予定された作業会では空)
+	ownerID string
+	// 離席の検出に使う最後の発言・メッセージの時刻など
+	lastActiveAt      map[string]time.Time
+	idleBreaks        map[string]int
+	afkWatches        map[string]*afkWatch
+	breakStartedAt    time.Time
 	memberIDs         map[string]struct{}
 	memberVoiceIDs    map[string]int
 	managedChannelIDs map[string]struct{}
+	// 作業時間中にいるメンバーの計測開始時刻
+	workStartedAt map[string]time.Time
+	startedAt     time.Time
+	goalThreadID  string
+	// 参加したことのあるメンバー (まとめに表示する)
+	participants    map[string]struct{}
+	statusMessageID string
+	statusEditedAt  time.Time
+	statusEvent     *ScheduledEvent
+	// セッションが終わった部屋を閉じる (イベントループで呼ばれる)
+	onFinished func(*ServerStatus)
 }
 
-func NewServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, scheduler *ScheduleQueue, guildID, channelID string) (*ServerStatus, error) {
+// NewServerStatus starts a session of the room. (音声接続はギルドに1つなので withVoice でなければ文字だけ)
+func NewServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, scheduler *Scheduler, stores *localStores, config GuildConfig, guildID, channelID, ownerID string, memberIDs []string, withVoice bool) (*ServerStatus, error) {
+
+	ss, err := newServerStatus(baseLogger, sess, voicevoxApp, scheduler, stores, config, guildID, channelID, withVoice)
+	if err != nil {
+		return nil, err
+	}
+	ss.ownerID = ownerID
+	for _, memberID := range memberIDs {
+		ss.memberIDs[memberID] = struct{}{}
+		ss.participants[memberID] = struct{}{}
+	}
+	ss.watchDeafMembers(time.Now())
+
+	ss.greet()
+	ss.Switch2Chat()
+
+	ss.lock.Lock()
+	ss.postStatus()
+	ss.saveSession()
+	ss.lock.Unlock()
+
+	return ss, nil
+}
+
+// NewScheduledServerStatus opens the scheduled session of the room, whose cycles follow the wall clock from start.
+func NewScheduledServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, scheduler *Scheduler, stores *localStores, config GuildConfig, guildID, channelID string, memberIDs []string, start, end time.Time, withVoice bool) (*ServerStatus, error) {
+
+	ss, err := newServerStatus(baseLogger, sess, voicevoxApp, scheduler, stores, config, guildID, channelID, withVoice)
+	if err != nil {
+		return nil, err
+	}
+	for _, memberID := range memberIDs {
+		ss.memberIDs[memberID] = struct{}{}
+		ss.participants[memberID] = struct{}{}
+	}
+	ss.watchDeafMembers(time.Now())
+
+	ss.greet()
+	ss.StartScheduled(start, end)
+
+	ss.lock.Lock()
+	ss.postStatus()
+	ss.saveSession()
+	ss.lock.Unlock()
+
+	return ss, nil
+}
+
+// RestoreServerStatus resumes the session saved before the restart with the members in the channel now.
+func RestoreServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, scheduler *Scheduler, stores *localStores, config GuildConfig, record sessionRecord, memberIDs []string, withVoice bool) (*ServerStatus, error) {
+
+	ss, err := newServerStatus(baseLogger, sess, voicevoxApp, scheduler, stores, config, record.GuildID, record.ChannelID, withVoice)
+	if err != nil {
+		return nil, err
+	}
+
+	ss.pickAnnounceSpeaker(record.AnnounceSpeaker, true)
+
+	for _, memberID := range memberIDs {
+		ss.memberIDs[memberID] = struct{}{}
+		ss.participants[memberID] = struct{}{}
+	}
+	ss.watchDeafMembers(time.Now())
+
+	ss.resume(advancePhase(config, record, time.Now()))
+
+	return ss, nil
+}
+
+func newServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, scheduler *Scheduler, stores *localStores, config GuildConfig, guildID, channelID string, withVoice bool) (*ServerStatus, error) {
 
 	// vc, err := sess.ChannelVoiceJoin(guildID, channelID, false, true)
 	baseLogger = baseLogger.With(
 		zap.Time("launchAt", time.Now().UTC()),
 		zap.String("guildID", guildID),
+		zap.String("channelID", channelID),
 	)
+	voiceLogger := baseLogger.With(zap.String("feature", "voicevoxRequest"))
 
-	vc, err := voicevox.StartManagedDiscordVoiceConnection(
-		baseLogger.With(zap.String("feature", "voicevoxRequest")),
-		sess, guildID, channelID, voicevoxApp,
-		util.ReplaceMsgFunc(sess),
-	)
+	var vc *voicevox.ManagedDiscordVoiceConnection
+	if withVoice {
+		var err error
+		vc, err = voicevox.StartManagedDiscordVoiceConnection(
+			voiceLogger,
+			sess, guildID, channelID, voicevoxApp,
+			util.ReplaceMsgFunc(sess),
+		)
 
-	if err != nil {
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	ss := &ServerStatus{
 		logger:            baseLogger.With(zap.String("feature", "serverStatus")),
 		sess:              sess,
+		voicevoxApp:       voicevoxApp,
+		voiceLogger:       voiceLogger,
 		voiceConn:         vc,
 		guildID:           guildID,
 		channelID:         channelID,
-		scheduler:         scheduler,
+		stores:            stores,
+		schedules:         scheduler.NewGroup(),
+		config:            config,
 		memberIDs:         make(map[string]struct{}),
 		memberVoiceIDs:    make(map[string]int),
 		managedChannelIDs: make(map[string]struct{}),
+		workStartedAt:     make(map[string]time.Time),
+		startedAt:         time.Now().UTC(),
+		participants:      make(map[string]struct{}),
+		lastActiveAt:      make(map[string]time.Time),
+		idleBreaks:        make(map[string]int),
+		afkWatches:        make(map[string]*afkWatch),
+	}
+	if vc != nil {
+		ss.listenSpeaking(vc)
+	}
+	return ss, nil
+}
+
+func (ss *ServerStatus) greet() {
+	ss.pickAnnounceSpeaker("", true)
+	ss.announce(true, voicevox.CharacterExpression(ss.announceSpeaker.Character).Hello())
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "💕よろしくおねがいします！",
+		Description: "この度は来てくださりありがとうございます。しっかり作業部屋を運営してまいりますのでよろしくお願いします。",
+	})
+}
+
+// resume continues the phase restored from the saved session.
+func (ss *ServerStatus) resume(record sessionRecord) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	ss.mode = record.Mode
+	ss.cycle = record.Cycle
+	ss.finished = record.Finished
+	ss.phaseEndAt = record.PhaseEndAt
+	if !record.StartedAt.IsZero() {
+		ss.startedAt = record.StartedAt
+	}
+	ss.goalThreadID = record.GoalThreadID
+	ss.statusMessageID = record.StatusMessageID
+	ss.pausedRemaining = record.PausedRemaining
+	ss.scheduledEndAt = record.ScheduledEndAt
+	ss.ownerID = record.OwnerID
+	now := time.Now()
+	for memberId := range ss.memberIDs {
+		ss.startWork(memberId, now)
+	}
+	ss.logger.Info("resumed saved session",
+		zap.Stringer("mode", record.Mode),
+		zap.Int("cycle", record.Cycle),
+		zap.Bool("finished", record.Finished),
+		zap.Time("phaseEndAt", record.PhaseEndAt),
+		zap.Int("members", len(ss.memberIDs)),
+	)
+
+	for memberId := range ss.memberIDs {
+		ss.setMute(memberId, ss.mode == serverStatusModeWork && !ss.finished)
+	}
+
+	nextTime := ss.config.formatClock(ss.phaseEndAt)
+	switch {
+	case ss.finished:
+		ss.announce(false, "再起動から復帰しました。今回のセッションはすでに終了しています。")
+		ss.sendEmbed(&discordgo.MessageEmbed{
+			Title:       "🔁再起動から復帰しました",
+			Description: fmt.Sprintf("%d回の作業でセッションは終了しています。ミュートは解除したので自由に話してください。", ss.cycle),
+		})
+		ss.scheduleClose()
+
+	case ss.isPaused():
+		// 一時停止中のままにして /pomodoro resume を待つ
+		ss.announce(false, fmt.Sprintf("再起動から復帰しました。%sは一時停止中です。", ss.phaseName()))
+		ss.sendEmbed(&discordgo.MessageEmbed{
+			Title:       "🔁再起動から復帰しました" + ss.cycleLabel(),
+			Description: fmt.Sprintf("%sは残り約%d分で一時停止中です。再開するときは /pomodoro resume を使ってください。", ss.phaseName(), ss.pausedMinutes()),
+		})
+
+	case ss.mode == serverStatusModeWork:
+		ss.announce(false, "再起動から復帰しました。作業時間を再開します。")
+		ss.announce(false, fmt.Sprintf("次の休憩時間は%sです。", nextTime))
+		ss.sendEmbed(&discordgo.MessageEmbed{
+			Title:       "🔁再起動から復帰しました" + ss.cycleLabel(),
+			Description: "作業時間の途中から再開します。" + ss.workMuteNotice(),
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("休憩時間は%sごろからです", nextTime),
+			},
+		})
+		ss.schedulePhaseEnd()
+
+	default:
+		ss.announce(false, "再起動から復帰しました。休憩時間を再開します。")
+		ss.announce(false, fmt.Sprintf("次の作業時間は%sです。", nextTime))
+		ss.sendEmbed(&discordgo.MessageEmbed{
+			Title:       "🔁再起動から復帰しました",
+			Description: "休憩時間の途中から再開します。休憩中はミュートを外すので好きに話してください。",
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("作業時間は%sごろからです", nextTime),
+			},
+		})
+		ss.schedulePhaseEnd()
 	}
 
-	speakers, err := ss.voiceConn.GetSpeakers("", true)
+	ss.updateChannelStatus()
+
+	// 再起動前のステータスメッセージがあれば引き続き更新する
+	if ss.statusMessageID == "" {
+		ss.postStatus()
+	} else {
+		ss.touchStatus()
+	}
+	ss.saveSession()
+}
+
+// startWork starts measuring the work of the member if it is in a work phase
+func (ss *ServerStatus) startWork(userID string, now time.Time) {
+	if ss.mode == serverStatusModeWork && !ss.finished && !ss.isPaused() {
+		ss.workStartedAt[userID] = now
+	}
+}
+
+// recordWork saves the work of the member measured since startWork
+func (ss *ServerStatus) recordWork(userID string, now time.Time, completed bool) {
+	startedAt, exist := ss.workStartedAt[userID]
+	if !exist {
+		return
+	}
+	delete(ss.workStartedAt, userID)
+	now = ss.workClock(now)
+
+	work := dayStats{
+		WorkMinutes: int(math.Round(float64(now.Sub(startedAt)) / float64(timeStep))),
+	}
+	// 途中から参加した人は作業時間の半分以上いたときだけ1ポモドーロと数える
+	if completed && 2*work.WorkMinutes >= ss.config.WorkMinutes {
+		work.Pomodoros = 1
+	}
+	if work.isZero() {
+		return
+	}
+
+	if err := ss.stores.stats.addWork(ss.guildID, ss.channelID, userID, ss.config.dateKey(now), work); err != nil {
+		ss.logger.Error("cannot save stats", zap.String("userID", userID), zap.Error(err))
+	}
+}
+
+// setMute changes the server mute and the work role of the member (ソフトモードはロールだけ)
+func (ss *ServerStatus) setMute(userID string, mute bool) {
+	if mute && ss.isExempt(userID) {
+		return
+	}
+	record := muteRecord{
+		ChannelID: ss.channelID,
+		RoleID:    ss.config.WorkRoleID,
+		SoftMute:  ss.config.softMute(),
+	}
+	if err := setMemberMute(ss.logger, ss.sess, ss.stores.mutes, ss.guildID, userID, record, mute); err != nil {
+		changeTo := "unmute"
+		if mute {
+			changeTo = "mute"
+		}
+		ss.logger.Error("cannot change mute", zap.String("userID", userID), zap.String("changeTo", changeTo), zap.Error(err))
+	}
+}
+
+// isExempt reports whether the member is never muted by the exemptions of the guild.
+// ロックを持ったまま API を呼ばないよう、ボイスチャンネルの更新で State に入れたメンバー情報だけを見る
+func (ss *ServerStatus) isExempt(userID string) bool {
+	member, err := ss.sess.State.Member(ss.guildID, userID)
 	if err != nil {
-		ss.logger.Error("cannot get speaker status", zap.Error(err))
+		ss.logger.Warn("cannot get member status", zap.String("userID", userID), zap.Error(err))
+		return false
 	}
+	return ss.config.isExempt(member)
+}
 
-	ss.announceSpeaker = speakers[rand.Intn(len(speakers))]
+func (ss *ServerStatus) hasMember(userID string) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
 
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, true, voicevox.CharacterExpression(ss.announceSpeaker.Character).Hello())
-	if msg, err := ss.sess.ChannelMessageSendEmbed(ss.channelID, &discordgo.MessageEmbed{
-		Title:       "💕よろしくおねがいします！",
-		Description: "この度は来てくださりありがとうございます。しっかり作業部屋を運営してまいりますのでよろしくお願いします。",
+	_, exist := ss.memberIDs[userID]
+	return exist
+}
+
+// Owner returns the member who started the session, or empty for scheduled sessions.
+func (ss *ServerStatus) Owner() string {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.ownerID
+}
+
+func (ss *ServerStatus) ownsThread(channelID string) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	return ss.goalThreadID != "" && ss.goalThreadID == channelID
+}
+
+// mutesMember reports whether the session keeps the member muted (or given the work role) now.
+func (ss *ServerStatus) mutesMember(userID string) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	_, exist := ss.memberIDs[userID]
+	if !exist || ss.isClosed || ss.finished || ss.mode != serverStatusModeWork {
+		return false
+	}
+	return !ss.isExempt(userID)
+}
+
+// saveSession records the current state to resume it after a restart
+func (ss *ServerStatus) saveSession() {
+	memberIDs := make([]string, 0, len(ss.memberIDs))
+	for memberID := range ss.memberIDs {
+		memberIDs = append(memberIDs, memberID)
+	}
+	sort.Strings(memberIDs)
+
+	if err := ss.stores.sessions.save(sessionRecord{
+		GuildID:         ss.guildID,
+		ChannelID:       ss.channelID,
+		Mode:            ss.mode,
+		Cycle:           ss.cycle,
+		Finished:        ss.finished,
+		PhaseEndAt:      ss.phaseEndAt,
+		MemberIDs:       memberIDs,
+		AnnounceSpeaker: ss.announceSpeaker.Name,
+		StartedAt:       ss.startedAt,
+		GoalThreadID:    ss.goalThreadID,
+		StatusMessageID: ss.statusMessageID,
+		PausedRemaining: ss.pausedRemaining,
+		ScheduledEndAt:  ss.scheduledEndAt,
+		OwnerID:         ss.ownerID,
 	}); err != nil {
-		ss.logger.Error("failed send message", zap.String("channelID", msg.ChannelID), zap.Error(err))
+		ss.logger.Error("cannot save session", zap.Error(err))
 	}
+}
 
-	ss.Switch2Chat()
+// HasVoice reports whether the room is connected to the voice channel.
+func (ss *ServerStatus) HasVoice() bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.voiceConn != nil
+}
 
-	return ss, err
+// AttachVoice connects to the voice channel, taking over from the closed room in the same guild.
+func (ss *ServerStatus) AttachVoice() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.voiceConn != nil || ss.isClosed {
+		return nil
+	}
+
+	vc, err := voicevox.StartManagedDiscordVoiceConnection(
+		ss.voiceLogger,
+		ss.sess, ss.guildID, ss.channelID, ss.voicevoxApp,
+		util.ReplaceMsgFunc(ss.sess),
+	)
+	if err != nil {
+		return err
+	}
+
+	ss.logger.Info("attached voice connection")
+	ss.voiceConn = vc
+	ss.listenSpeaking(vc)
+	ss.announce(false, voicevox.CharacterExpression(ss.announceSpeaker.Character).Hello())
+	return nil
+}
+
+// pickAnnounceSpeaker chooses the speaker named name, or a random one if it is not found.
+// 話者一覧を取れなければ名前だけ残し、次の読み上げの前に選び直す
+func (ss *ServerStatus) pickAnnounceSpeaker(name string, waitResume bool) {
+	speakers, err := ss.voicevoxApp.GetSpeakers("", waitResume)
+	if err != nil || len(speakers) == 0 {
+		ss.logger.Warn("cannot get speaker status", zap.Error(err))
+		ss.announceSpeaker = voicevox.VoiceSpeaker{Name: name}
+		ss.speakerPending = true
+		return
+	}
+
+	ss.speakerPending = false
+	ss.announceSpeaker = speakers[rand.Intn(len(speakers))]
+	for _, speaker := range speakers {
+		if name != "" && speaker.Name == name {
+			ss.announceSpeaker = speaker
+			break
+		}
+	}
+}
+
+// 音声接続がない部屋では文字のお知らせだけを行う
+func (ss *ServerStatus) announce(waitSpeaked bool, content string) {
+	if ss.voiceConn == nil {
+		return
+	}
+	if ss.speakerPending {
+		if ss.pickAnnounceSpeaker(ss.announceSpeaker.Name, false); ss.speakerPending {
+			return
+		}
+	}
+	ss.voiceConn.SpeakUtterance(voicevox.Utterance{
+		SpeakerID: ss.announceSpeaker.Id,
+		Prosody:   announceProsody,
+		Content:   content,
+	}, waitSpeaked)
 }
 
 func (ss *ServerStatus) onMessageCreate(sess *discordgo.Session, event *discordgo.MessageCreate) {
 	ss.lock.Lock()
 	defer ss.lock.Unlock()
 
+	ss.markActive(event.Author.ID, time.Now())
 	userId := event.Author.ID
 	if _, exist := ss.memberIDs[userId]; !exist {
 		return
 	}
 
+	if ss.goalThreadID != "" && event.ChannelID == ss.goalThreadID {
+		ss.onGoalThreadMessage(event)
+		return
+	}
+
 	switch ss.mode {
 	case serverStatusModeChat:
-		id, exist := ss.memberVoiceIDs[userId]
-		if !exist {
-			speakers, err := ss.voiceConn.GetSpeakers("", true)
-			if err != nil {
-				ss.logger.Error("cannot get speaker status", zap.Error(err))
-			}
-			id = speakers[rand.Intn(len(speakers))].Id
+		if ss.voiceConn == nil {
+			return
+		}
 
-			ss.memberVoiceIDs[userId] = id
+		id, err := ss.memberVoiceID(userId)
+		if err != nil {
+			ss.logger.Error("cannot get speaker status", zap.Error(err))
+			return
 		}
 
 		splited := util.WordSpliter(event.ContentWithMentionsReplaced())
 
 		for _, split := range splited {
-			ss.voiceConn.Speak(id, false, split)
+			ss.voiceConn.SpeakUtterance(voicevox.Utterance{
+				SpeakerID: id,
+				Content:   split,
+				Owner:     userId,
+			}, false)
 		}
 
 	case serverStatusModeWork:
-		user, err := sess.GuildMember(ss.guildID, event.Author.ID)
+		nick, err := ss.memberName(event.Author.ID)
 		if err != nil {
 			ss.logger.Error("cannot get user status", zap.Error(err))
 			break
 		}
-		nick := user.Nick
-		if nick == "" {
-			nick = user.User.Username
-		}
 
 		comments := []string{
 			"応援しています",
@@ -142,9 +551,36 @@ func (ss *ServerStatus) onMessageCreate(sess *discordgo.Session, event *discordg
 			"手を動かすんです",
 		}
 
-		ss.voiceConn.Speak(ss.announceSpeaker.Id, false, nick)
-		ss.voiceConn.Speak(ss.announceSpeaker.Id, false, comments[rand.Intn(len(comments))])
+		ss.announce(false, nick)
+		ss.announce(false, comments[rand.Intn(len(comments))])
+	}
+}
+
+func (ss *ServerStatus) memberName(userID string) (string, error) {
+	member, err := ss.sess.GuildMember(ss.guildID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if member.Nick != "" {
+		return member.Nick, nil
+	}
+	return member.User.Username, nil
+}
+
+// 声が未設定のメンバーにはランダムに割り当てる
+func (ss *ServerStatus) memberVoiceID(userID string) (int, error) {
+	if id, exist := ss.memberVoiceIDs[userID]; exist {
+		return id, nil
+	}
+
+	speakers, err := ss.voicevoxApp.GetSpeakers("", true)
+	if err != nil {
+		return 0, err
 	}
+	id := speakers[rand.Intn(len(speakers))].Id
+	ss.memberVoiceIDs[userID] = id
+	return id, nil
 }
 
 func (ss *ServerStatus) onVoiceChangeUpdate(sess *discordgo.Session, event *discordgo.VoiceStateUpdate) (isClose bool) {
@@ -157,6 +593,10 @@ func (ss *ServerStatus) onVoiceChangeUpdate(sess *discordgo.Session, event *disc
 		event.BeforeUpdate = &discordgo.VoiceState{}
 	}
 	if event.ChannelID == event.BeforeUpdate.ChannelID {
+		// 同じチャンネルのままのミュートなどの変化は離席の検出に使う
+		if event.ChannelID == ss.channelID {
+			ss.onSelfDeafChange(userId, event.SelfDeaf, time.Now())
+		}
 		return false
 	}
 
@@ -165,16 +605,17 @@ func (ss *ServerStatus) onVoiceChangeUpdate(sess *discordgo.Session, event *disc
 
 			ss.logger.Debug("joined into chatspace", zap.String("userID", userId))
 			ss.memberIDs[userId] = struct{}{}
+			ss.participants[userId] = struct{}{}
+			ss.startWork(userId, time.Now())
+			ss.onSelfDeafChange(userId, event.SelfDeaf, time.Now())
+			ss.touchStatus()
+			ss.saveSession()
 
 			switch ss.mode {
 			case serverStatusModeWork:
-				if err := sess.GuildMemberMute(ss.guildID, userId, true); err != nil {
-					ss.logger.Error("cannot change mute", zap.String("userID", userId), zap.String("changeTo", "mute"), zap.Error(err))
-				}
+				ss.setMute(userId, true)
 			case serverStatusModeChat:
-				if err := sess.GuildMemberMute(ss.guildID, userId, false); err != nil {
-					ss.logger.Error("cannot change mute", zap.String("userID", userId), zap.String("changeTo", "unmute"), zap.Error(err))
-				}
+				ss.setMute(userId, false)
 			}
 		}
 
@@ -183,17 +624,23 @@ func (ss *ServerStatus) onVoiceChangeUpdate(sess *discordgo.Session, event *disc
 		if _, exist := ss.memberIDs[userId]; exist {
 			ss.logger.Debug("left from chatspace", zap.String("userID", userId))
 			delete(ss.memberIDs, userId)
-
-			if len(ss.memberIDs) == 0 {
+			ss.recordWork(userId, time.Now(), false)
+			ss.cancelAFK(userId)
+			delete(ss.lastActiveAt, userId)
+			delete(ss.idleBreaks, userId)
+			ss.touchStatus()
+			ss.saveSession()
+
+			// 予定された作業会は終了時刻まで誰もいなくても開いておく
+			if len(ss.memberIDs) == 0 && !ss.isScheduled() {
 				isClose = true
 			}
 		}
 	}
 
+	// 切断したメンバーはサーバーミュートを解除できないので、ロールと記録の後始末は reconcileUser で行う
 	if event.ChannelID != ss.channelID && event.ChannelID != "" {
-		if err := sess.GuildMemberMute(ss.guildID, userId, false); err != nil {
-			ss.logger.Error("cannot change mute", zap.String("userID", userId), zap.String("changeTo", "unmute"), zap.Error(err))
-		}
+		ss.setMute(userId, false)
 	}
 	return isClose
 }
@@ -207,32 +654,48 @@ func (ss *ServerStatus) Switch2Work() {
 	}
 
 	ss.logger.Info("switch mode chat to work")
+	// 休憩中の会話の読み上げが作業時間に食い込まないようにする
+	if ss.voiceConn != nil {
+		if count := ss.voiceConn.ClearMessages(); count > 0 {
+			ss.logger.Debug("cleared chat reading", zap.Int("count", count))
+		}
+	}
 	ss.mode = serverStatusModeWork
+	ss.cycle++
+	now := time.Now()
 	for memberId := range ss.memberIDs {
-		if err := ss.sess.GuildMemberMute(ss.guildID, memberId, true); err != nil {
-			ss.logger.Error("cannot change mute", zap.String("userID", memberId), zap.String("changeTo", "mute"), zap.Error(err))
-		}
+		ss.setMute(memberId, true)
+		ss.startWork(memberId, now)
 	}
+	ss.checkIdleBreaks(now)
+
+	workTime := ss.config.workTime()
+	ss.phaseEndAt = time.Now().Add(workTime)
+	ss.clipPhaseEnd()
+	nextTime := ss.config.formatClock(ss.phaseEndAt)
+	ss.updateChannelStatus()
+	ss.playChime(ss.config.WorkChime)
+	if ss.config.softMute() {
+		ss.announce(false, "作業時間です。マイクをミュートしてください。")
+	} else {
+		ss.announce(false, "作業時間となるのでミュートを行いました。")
+	}
+	ss.announce(false, fmt.Sprintf("作業は%d分間です。", ss.config.WorkMinutes))
+	ss.announce(false, fmt.Sprintf("次の休憩時間は%sです。", nextTime))
+	ss.announce(false, "しっかり作業を進めてください。")
 
-	nextTime := time.Now().Add(9*time.Hour + workTimes).Format("3時4分")
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, false, "作業時間となるのでミュートを行いました。")
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, false, fmt.Sprintf("次の作業時間は%sです。", nextTime))
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, false, "しっかり作業を進めてください。")
-
-	if msg, err := ss.sess.ChannelMessageSendEmbed(ss.channelID, &discordgo.MessageEmbed{
-		Title:       "🚀作業時間です！",
-		Description: "作業は45分間です。作業中はミュートを行うので次の休憩までに作業を進めましょう。",
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "🚀作業時間です！" + ss.cycleLabel(),
+		Description: fmt.Sprintf("作業は%d分間です。%s次の休憩までに作業を進めましょう。", ss.config.WorkMinutes, ss.workMuteNotice()),
 		Footer: &discordgo.MessageEmbedFooter{
-			Text: "休憩時間は" + time.Now().Add(9*time.Hour).Format(time.Kitchen) + "ごろからです",
+			Text: fmt.Sprintf("休憩時間は%sごろからです", nextTime),
 		},
-	}); err != nil {
-		ss.logger.Error("failed send message", zap.String("channelID", msg.ChannelID), zap.Error(err))
-	}
-
-	ss.scheduler.PushBack(ScheduleEvent{
-		Unix: time.Now().Add(workTimes).Unix(),
-		Func: ss.Switch2Chat,
 	})
+	ss.openGoalThread()
+	ss.touchStatus()
+
+	ss.schedulePhaseEnd()
+	ss.saveSession()
 }
 
 func (ss *ServerStatus) Switch2Chat() {
@@ -244,48 +707,189 @@ func (ss *ServerStatus) Switch2Chat() {
 	}
 
 	ss.logger.Info("switch mode work to chat")
+	// 作業時間の終わりまでいたメンバーはポモドーロを1つ達成
+	now := time.Now()
+	for memberId := range ss.memberIDs {
+		ss.recordWork(memberId, now, true)
+	}
+
 	ss.mode = serverStatusModeChat
+	ss.breakStartedAt = now
+	ss.cancelWarnings()
+	ss.updateChannelStatus()
 	for memberId := range ss.memberIDs {
-		if err := ss.sess.GuildMemberMute(ss.guildID, memberId, false); err != nil {
-			ss.logger.Error("cannot change mute", zap.String("userID", memberId), zap.String("changeTo", "unmute"), zap.Error(err))
+		ss.setMute(memberId, false)
+	}
+	ss.playChime(ss.config.BreakChime)
+
+	// 読み上げ役は休憩ごとに替える (音声接続のない部屋では読み上げないので替えない)
+	if ss.voiceConn != nil {
+		speakers, err := ss.voicevoxApp.GetSpeakers("", true)
+		if err != nil || len(speakers) == 0 {
+			ss.logger.Error("cannot get speaker status", zap.Error(err))
+		} else {
+			ss.announceSpeaker = speakers[rand.Intn(len(speakers))]
+			ss.speakerPending = false
 		}
 	}
 
-	speakers, err := ss.voiceConn.GetSpeakers("", true)
-	if err != nil {
-		ss.logger.Error("cannot get speaker status", zap.Error(err))
+	// 決められたサイクル数を終えたらセッションを終える
+	if ss.config.isLastCycle(ss.cycle) {
+		ss.logger.Info("finished all cycles of the session", zap.Int("cycle", ss.cycle))
+		ss.finished = true
+		ss.phaseEvent = nil
+		ss.phaseEndAt = time.Time{}
+		ss.saveSession()
+
+		if !ss.config.softMute() {
+			ss.announce(false, "ミュートを解除しました。")
+		}
+		ss.announce(false, fmt.Sprintf("%d回の作業、お疲れ様でした。今回のセッションはこれで終了です。", ss.cycle))
+
+		ss.sendEmbed(&discordgo.MessageEmbed{
+			Title:       "🎉セッション終了です！",
+			Description: fmt.Sprintf("%d回の作業お疲れ様でした。ミュートは解除したので自由に話してください。", ss.cycle),
+		})
+		ss.reviewGoals()
+		ss.touchStatus()
+		ss.scheduleClose()
+		return
 	}
-	ss.announceSpeaker = speakers[rand.Intn(len(speakers))]
 
-	nextTime := time.Now().Add(9*time.Hour + chatTimes).Format("3時4分")
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, false, "休憩時間となるのでミュートを解除しました。")
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, false, fmt.Sprintf("次の作業時間は%sです。", nextTime))
-	ss.voiceConn.Speak(ss.announceSpeaker.Id, false, "それまでしっかり休みましょう。")
+	breakTime := ss.config.breakTime(ss.cycle)
+	breakMinutes := ss.config.breakMinutes(ss.cycle)
+	ss.phaseEndAt = time.Now().Add(breakTime)
+	ss.clipPhaseEnd()
+	nextTime := ss.config.formatClock(ss.phaseEndAt)
+
+	title := "🌿休憩時間です！"
+	breakName := "休憩時間"
+	if ss.config.isLongBreak(ss.cycle) {
+		title = "☕長めの休憩時間です！"
+		breakName = "長めの休憩時間"
+	}
+	if ss.config.softMute() {
+		ss.announce(false, breakName+"です。")
+	} else {
+		ss.announce(false, breakName+"となるのでミュートを解除しました。")
+	}
+	ss.announce(false, fmt.Sprintf("休憩は%d分間です。", breakMinutes))
+	ss.announce(false, fmt.Sprintf("次の作業時間は%sです。", nextTime))
+	ss.announce(false, "それまでしっかり休みましょう。")
 
-	if msg, err := ss.sess.ChannelMessageSendEmbed(ss.channelID, &discordgo.MessageEmbed{
-		Title:       "🌿休憩時間です！",
-		Description: "休憩は15分間です。休憩中はミュートを外すので好きに話してください。",
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: fmt.Sprintf("休憩は%d分間です。休憩中はミュートを外すので好きに話してください。", breakMinutes),
 		Footer: &discordgo.MessageEmbedFooter{
 			Text: fmt.Sprintf("作業時間は%sごろからです", nextTime),
 		},
-	}); err != nil {
-		ss.logger.Error("failed send message", zap.String("channelID", msg.ChannelID), zap.Error(err))
 	}
+	if topic, exist := ss.pickBreakTopic(); exist {
+		ss.announce(false, topic.spoken())
+		embed.Fields = append(embed.Fields, topic.embedField())
+	}
+	ss.sendEmbed(embed)
+	ss.reviewGoals()
+	ss.touchStatus()
+
+	ss.schedulePhaseEnd()
+	ss.saveSession()
+}
+
+// workMuteNotice explains how the members are muted during work phases.
+func (ss *ServerStatus) workMuteNotice() string {
+	if ss.config.softMute() {
+		return "作業中は各自でマイクをミュートしてください。"
+	}
+	return "作業中はミュートを行います。"
+}
+
+// cycleLabel returns such as "（2/4）" when the session has a fixed number of cycles.
+func (ss *ServerStatus) cycleLabel() string {
+	return ss.config.cycleLabel(ss.cycle)
+}
+
+func (ss *ServerStatus) sendEmbed(embed *discordgo.MessageEmbed) {
+	if _, err := ss.sess.ChannelMessageSendEmbed(ss.channelID, embed); err != nil {
+		ss.logger.Error("failed send message", zap.String("channelID", ss.channelID), zap.Error(err))
+	}
+}
 
-	ss.scheduler.PushBack(ScheduleEvent{
-		Unix: time.Now().Add(chatTimes).Unix(),
-		Func: ss.Switch2Work,
+// OnFinished sets fn to close the room after its session finishes.
+func (ss *ServerStatus) OnFinished(fn func(*ServerStatus)) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.onFinished = fn
+}
+
+// scheduleClose closes the room after the last announcements
+func (ss *ServerStatus) scheduleClose() {
+	ss.schedules.Schedule(time.Now().Add(finishedRoomLinger), func() {
+		ss.lock.Lock()
+		onFinished := ss.onFinished
+		ss.lock.Unlock()
+		if onFinished != nil {
+			onFinished(ss)
+		}
 	})
 }
 
+// Close ends the session and forgets it.
 func (ss *ServerStatus) Close() error {
-	ss.isClosed = true
+	ss.stop()
+	ss.finalizeStatus()
+	if err := ss.stores.sessions.remove(ss.channelID); err != nil {
+		ss.logger.Error("cannot remove saved session", zap.Error(err))
+	}
 
-	if msg, err := ss.sess.ChannelMessageSendEmbed(ss.channelID, &discordgo.MessageEmbed{
+	ss.sendEmbed(&discordgo.MessageEmbed{
 		Title:       "🤗またお越しください！",
 		Description: "私はすぐに駆け付けます。ボイスチャンネルにまた来てください。",
-	}); err != nil {
-		ss.logger.Error("failed send message", zap.String("channelID", msg.ChannelID), zap.Error(err))
+	})
+	return ss.closeVoice()
+}
+
+// Suspend stops the session on shutdown and keeps it saved to be resumed after the restart.
+func (ss *ServerStatus) Suspend() error {
+	ss.stop()
+
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "🔧一時停止します",
+		Description: "メンテナンスのため一度退出します。再起動後に続きから再開します。",
+	})
+	return ss.closeVoice()
+}
+
+func (ss *ServerStatus) closeVoice() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.voiceConn == nil {
+		return nil
+	}
+	vc := ss.voiceConn
+	ss.voiceConn = nil
+	ss.stopSpeaking()
+	ss.stopSpeaking = nil
+	return vc.Close()
+}
+
+func (ss *ServerStatus) stop() {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	now := time.Now()
+	for memberId := range ss.memberIDs {
+		ss.recordWork(memberId, now, false)
+	}
+
+	ss.isClosed = true
+	ss.updateChannelStatus()
+	if canceled := ss.schedules.CancelAll(); canceled > 0 {
+		ss.logger.Debug("canceled pending schedules", zap.Int("count", canceled))
 	}
-	return ss.voiceConn.Close()
+	ss.phaseEvent = nil
+	ss.warningEvents = nil
+	ss.afkWatches = make(map[string]*afkWatch)
+	ss.statusEvent = nil
 }
@@ -2,7 +2,7 @@ package chatspace
 
 import (
 	"fmt"
-	"sort"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -19,10 +19,52 @@ type ServiceController struct {
 	discord  *discordgo.Session
 }
 
+// ManagedChannelName is the name of the managed voice channel in guilds without managedChannels config.
 var ManagedChannelName = "もくもく"
 
+// leaderboardCheckInterval is how often the weekly leaderboard is checked to be posted.
+var leaderboardCheckInterval = time.Hour
+
+// localStores are the files kept in the data directory.
+type localStores struct {
+	sessions *sessionStore
+	mutes    *muteLedger
+	stats    *statsStore
+	topics   *topicStore
+	settings *settingsStore
+}
+
+func newLocalStores(dataDir string) *localStores {
+	return &localStores{
+		sessions: newSessionStore(filepath.Join(dataDir, "chatspace_sessions.json")),
+		mutes:    newMuteLedger(filepath.Join(dataDir, "chatspace_mutes.json")),
+		stats:    newStatsStore(filepath.Join(dataDir, "chatspace_stats.json")),
+		topics:   newTopicStore(filepath.Join(dataDir, "chatspace_topics.json")),
+		settings: newSettingsStore(filepath.Join(dataDir, "chatspace_settings.json")),
+	}
+}
+
+// イベントループが保持する状態 (イベントループのゴルーチンからのみ触る)
+type serviceState struct {
+	logger      *zap.Logger
+	baseLogger  *zap.Logger
+	sess        *discordgo.Session
+	appID       string
+	voicevoxApp *voicevox.VoiceVox
+	config      *Config
+	stores      *localStores
+	schedules   *Scheduler
+	reconciler  *muteReconciler
+	// channelID -> room
+	serverStatuses map[string]*ServerStatus
+	// 予定の作業会を計画済みのギルド
+	plannedGuilds map[string]struct{}
+	// timerKey -> 個人タイマー
+	timers map[string]*personalTimer
+}
+
 // Make a new ServiceController instance.
-func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicevox.VoiceVox) (*ServiceController, error) {
+func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicevox.VoiceVox, config *Config, dataDir string) (*ServiceController, error) {
 
 	// Initialize discord service
 	baseLogger = baseLogger.With(zap.String("package", "chatspace"))
@@ -36,7 +78,22 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 		return nil, fmt.Errorf("failed get application status: %w", err)
 	}
 
+	stores := newLocalStores(dataDir)
+
+	// /config で変更された設定を読み込む
+	if saved, err := stores.settings.loadAll(); err != nil {
+		baseLogger.Error("cannot load guild settings", zap.Error(err))
+	} else {
+		for guildID, settings := range saved {
+			if err := config.setSettings(guildID, settings); err != nil {
+				baseLogger.Warn("ignore invalid guild settings", zap.String("guildID", guildID), zap.Error(err))
+			}
+		}
+	}
+
 	messageCreateListener := make(chan discordgo.MessageCreate)
+	guildCreateListener := make(chan discordgo.GuildCreate)
+	interactionCreateListener := make(chan discordgo.InteractionCreate)
 	voiceStateUpdateListener := make(chan discordgo.VoiceStateUpdate)
 	chCloser := make(chan *sync.WaitGroup)
 
@@ -46,6 +103,14 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 			messageCreateListener <- *arg
 		}
 	})
+	sess.AddHandler(func(_ *discordgo.Session, arg *discordgo.GuildCreate) {
+		guildCreateListener <- *arg
+	})
+	sess.AddHandler(func(_ *discordgo.Session, arg *discordgo.InteractionCreate) {
+		if arg.GuildID != "" && arg.Member != nil {
+			interactionCreateListener <- *arg
+		}
+	})
 	sess.AddHandler(func(_ *discordgo.Session, arg *discordgo.VoiceStateUpdate) {
 		if arg.Member.User.ID != app.ID {
 			voiceStateUpdateListener <- *arg
@@ -56,106 +121,100 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 	go func() {
 
 		logger := baseLogger.With(zap.String("feature", "eventListener"))
-		schedules := NewScheduleQueue()
-		ticker := time.NewTicker(timeStep / 4)
-		serverStatuses := map[string]*ServerStatus{}
+		schedules := NewScheduler()
+		st := &serviceState{
+			logger:      logger,
+			baseLogger:  baseLogger,
+			sess:        sess,
+			appID:       app.ID,
+			voicevoxApp: voicevoxApp,
+			config:      config,
+			stores:      stores,
+			schedules:   schedules,
+			reconciler: &muteReconciler{
+				logger: baseLogger.With(zap.String("feature", "muteReconciler")),
+				sess:   sess,
+				ledger: stores.mutes,
+			},
+			serverStatuses: map[string]*ServerStatus{},
+			plannedGuilds:  map[string]struct{}{},
+			timers:         map[string]*personalTimer{},
+		}
+
+		// ミュートの確認は guildCreate ごとに行い、以降は定期的に確認する
+		st.every(muteReconcileInterval, func() {
+			st.reconciler.reconcile(st.serverStatuses)
+		})
+		st.every(leaderboardCheckInterval, st.postLeaderboards)
 
 		for {
 
-		Select:
 			select {
 			case wg := <-chCloser:
 				defer wg.Done()
 
 				// finalize eventListener
-				for _, ss := range serverStatuses {
+				for _, ss := range st.serverStatuses {
 					for memberId := range ss.memberIDs {
-						if err := ss.sess.GuildMemberMute(ss.guildID, memberId, false); err != nil {
-							logger.Error("cannot unmute")
-						}
+						ss.setMute(memberId, false)
 					}
-					if err := ss.Close(); err != nil {
+					if err := ss.Suspend(); err != nil {
 						logger.Error("cannot close chatspace instance", zap.Error(err))
 					}
 				}
 
+				// 個人タイマーは保存したまま止め、再起動後に再開する
+				for _, pt := range st.timers {
+					pt.suspend()
+				}
+
 				logger.Info("started finalize application event listener")
 				if err := sess.Close(); err != nil {
 					logger.Error("failed discord session's closing", zap.Error(err))
 				}
 				close(messageCreateListener)
+				close(guildCreateListener)
+				close(interactionCreateListener)
 				close(voiceStateUpdateListener)
-				ticker.Stop()
+				schedules.Stop()
 				return
 
 			case event := <-messageCreateListener:
 				logger.Debug("triggered messageCreate event")
-				serverStatus, exist := serverStatuses[event.GuildID]
-				if exist {
+				if serverStatus := st.roomOfMessage(&event); serverStatus != nil {
 					serverStatus.onMessageCreate(sess, &event)
 				}
 
-			case event := <-voiceStateUpdateListener:
-
-				logger.Debug("triggered voiceStateUpdate event")
+			case event := <-guildCreateListener:
+				logger.Debug("triggered guildCreate event")
+				st.restoreSessions(event)
+				st.restoreTimers(event)
+				st.reconciler.reconcileGuild(st.serverStatuses, event.ID)
+				st.planSchedules(event.ID)
 
-				_, exist := serverStatuses[event.GuildID]
-				if !exist {
-					logger.Debug("check join and start chatspace server")
-					if event.ChannelID != "" {
-						ch, err := sess.Channel(event.ChannelID)
-						if err != nil {
-							logger.Error("failed get discord channel status", zap.Error(err))
-							break Select
-						}
-
-						if ch.Name == ManagedChannelName {
-							logger.Debug("request new chatspace server instance")
-							serverStatus, err := NewServerStatus(baseLogger, sess, voicevoxApp, schedules, event.GuildID, event.ChannelID)
-							if err != nil {
-								logger.Error("failed new chatspace server instance", zap.Error(err))
-							} else {
-								serverStatuses[event.GuildID] = serverStatus
-							}
-						}
-					}
-				}
+			case event := <-interactionCreateListener:
+				st.onInteraction(&event)
 
-				serverStatus, exist := serverStatuses[event.GuildID]
-				if exist {
-					if isClose := serverStatus.onVoiceChangeUpdate(sess, &event); isClose {
-						logger.Info("close the idled chatspace server", zap.String("guildID", event.GuildID))
-						if err := serverStatus.Close(); err != nil {
-							logger.Error("cannot close chatspace instance", zap.Error(err))
-						}
-						delete(serverStatuses, event.GuildID)
-					}
-				}
-
-			case <-ticker.C:
+			case event := <-voiceStateUpdateListener:
+				logger.Debug("triggered voiceStateUpdate event")
+				st.onVoiceStateUpdate(event)
 
-				// Update schedule
-				if schedules.Len() > 2 {
-					if !sort.IsSorted(schedules) {
-						sort.Sort(schedules)
-					}
-				}
+			case <-schedules.Wake():
 
-				currentUnix := time.Now().Unix()
-				for schedules.Len() > 0 {
-					if schedule := schedules.Front(); schedule.Unix < currentUnix {
-						schedule.Func()
-						schedules.PopFront()
-					} else {
-						logger.Debug("checked event schedules", zap.Time("nextEvent", time.Unix(schedule.Unix, 0)))
-						break
-					}
+				// Run due schedules
+				schedules.RunDue(time.Now())
+				if next, exist := schedules.Next(); exist {
+					logger.Debug("checked event schedules", zap.Time("nextEvent", next))
 				}
 			}
 		}
 	}()
 
 	sess.Open()
+	if _, err := sess.ApplicationCommandBulkOverwrite(app.ID, "", applicationCommands); err != nil {
+		baseLogger.Error("failed register application commands", zap.Error(err))
+	}
+
 	sc := &ServiceController{
 		logger:   baseLogger.With(zap.String("feature", "controller")),
 		chCloser: chCloser,
@@ -165,6 +224,235 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 	return sc, nil
 }
 
+// every runs fn repeatedly at the interval.
+func (st *serviceState) every(interval time.Duration, fn func()) {
+	var run func()
+	run = func() {
+		fn()
+		st.schedules.Schedule(time.Now().Add(interval), run)
+	}
+	st.schedules.Schedule(time.Now().Add(interval), run)
+}
+
+// Discord ではギルドごとに1つのボイスチャンネルにしか接続できない
+func (st *serviceState) voiceRoomOf(guildID string) *ServerStatus {
+	for _, ss := range st.serverStatuses {
+		if ss.guildID == guildID && ss.HasVoice() {
+			return ss
+		}
+	}
+	return nil
+}
+
+// roomOfMessage returns the room of the channel or the goal thread, or else of the author.
+func (st *serviceState) roomOfMessage(event *discordgo.MessageCreate) *ServerStatus {
+	if ss, exist := st.serverStatuses[event.ChannelID]; exist {
+		return ss
+	}
+	var authorRoom *ServerStatus
+	for _, ss := range st.serverStatuses {
+		if ss.guildID != event.GuildID {
+			continue
+		}
+		if ss.ownsThread(event.ChannelID) {
+			return ss
+		}
+		if ss.hasMember(event.Author.ID) {
+			authorRoom = ss
+		}
+	}
+	return authorRoom
+}
+
+// voiceMembersOf returns the members in the voice channel other than this bot.
+func (st *serviceState) voiceMembersOf(guildID, channelID string) []string {
+	memberIDs := []string{}
+	guild, err := st.sess.State.Guild(guildID)
+	if err != nil {
+		return memberIDs
+	}
+	for _, vs := range guild.VoiceStates {
+		if vs.UserID != st.appID && vs.ChannelID == channelID {
+			memberIDs = append(memberIDs, vs.UserID)
+		}
+	}
+	return memberIDs
+}
+
+// 音声接続を持っていた部屋が閉じたら他の部屋に引き継ぐ
+func (st *serviceState) handOverVoice(guildID string) {
+	for _, ss := range st.serverStatuses {
+		if ss.guildID != guildID {
+			continue
+		}
+		if err := ss.AttachVoice(); err != nil {
+			st.logger.Error("cannot hand over voice connection", zap.String("channelID", ss.channelID), zap.Error(err))
+			continue
+		}
+		st.logger.Info("handed over voice connection", zap.String("guildID", guildID), zap.String("channelID", ss.channelID))
+		return
+	}
+}
+
+func (st *serviceState) closeRoom(ss *ServerStatus) {
+	st.logger.Info("close the idled chatspace server", zap.String("guildID", ss.guildID), zap.String("channelID", ss.channelID))
+	hadVoice := ss.HasVoice()
+	if err := ss.Close(); err != nil {
+		st.logger.Error("cannot close chatspace instance", zap.Error(err))
+	}
+	delete(st.serverStatuses, ss.channelID)
+	if hadVoice {
+		st.handOverVoice(ss.guildID)
+	}
+}
+
+// 再起動前に動いていたセッションを復元する
+func (st *serviceState) restoreSessions(event discordgo.GuildCreate) {
+	saved, err := st.stores.sessions.loadAll()
+	if err != nil {
+		st.logger.Error("cannot load saved sessions", zap.Error(err))
+		return
+	}
+
+	for channelID, record := range saved {
+		if record.GuildID != event.ID || record.Timer != nil {
+			continue
+		}
+		if _, exist := st.serverStatuses[channelID]; exist {
+			continue
+		}
+
+		memberIDs := []string{}
+		for _, vs := range event.VoiceStates {
+			if vs.UserID != st.appID && vs.ChannelID == channelID {
+				memberIDs = append(memberIDs, vs.UserID)
+			}
+		}
+
+		stale := isStale(st.config.Guild(event.ID), record, time.Now())
+		if len(memberIDs) == 0 || stale {
+			st.logger.Info("discard the saved session", zap.String("guildID", event.ID), zap.String("channelID", channelID), zap.Int("members", len(memberIDs)), zap.Bool("stale", stale))
+			if err := st.stores.sessions.remove(channelID); err != nil {
+				st.logger.Error("cannot remove saved session", zap.Error(err))
+			}
+			// 異常終了で残ったボイスチャンネルのステータスを消す
+			if st.config.Guild(event.ID).ChannelStatus {
+				if err := setVoiceChannelStatus(st.sess, channelID, ""); err != nil {
+					st.logger.Error("cannot clear voice channel status", zap.String("channelID", channelID), zap.Error(err))
+				}
+			}
+			continue
+		}
+
+		st.logger.Info("restore the saved chatspace server", zap.String("guildID", event.ID), zap.String("channelID", channelID), zap.Int("members", len(memberIDs)))
+		serverStatus, err := RestoreServerStatus(st.baseLogger, st.sess, st.voicevoxApp, st.schedules, st.stores, st.config.Guild(event.ID), record, memberIDs, st.voiceRoomOf(event.ID) == nil)
+		if err != nil {
+			st.logger.Error("failed restore chatspace server instance", zap.Error(err))
+		} else {
+			serverStatus.OnFinished(st.closeRoom)
+			st.serverStatuses[channelID] = serverStatus
+		}
+	}
+}
+
+// 再起動前に動いていた個人タイマーを再開する
+func (st *serviceState) restoreTimers(event discordgo.GuildCreate) {
+	saved, err := st.stores.sessions.loadAll()
+	if err != nil {
+		st.logger.Error("cannot load saved sessions", zap.Error(err))
+		return
+	}
+
+	for key, record := range saved {
+		if record.GuildID != event.ID || record.Timer == nil {
+			continue
+		}
+		if _, exist := st.timers[key]; exist {
+			continue
+		}
+
+		config := st.config.Guild(event.ID).withTimer(record.Timer)
+		if record.Finished || isStale(config, record, time.Now()) {
+			st.logger.Info("discard the saved personal timer", zap.String("guildID", event.ID), zap.String("userID", record.Timer.UserID))
+			if err := st.stores.sessions.remove(key); err != nil {
+				st.logger.Error("cannot remove saved personal timer", zap.Error(err))
+			}
+			continue
+		}
+
+		st.startTimer(record, (*personalTimer).resume)
+	}
+}
+
+// startTimer registers the personal timer of the record and runs it with begin.
+func (st *serviceState) startTimer(record sessionRecord, begin func(*personalTimer)) {
+	key := record.key()
+	pt := newPersonalTimer(st.baseLogger, st.sess, st.schedules, st.stores, st.config.Guild(record.GuildID), record, func() {
+		delete(st.timers, key)
+	})
+	st.timers[key] = pt
+	begin(pt)
+}
+
+func (st *serviceState) onVoiceStateUpdate(event discordgo.VoiceStateUpdate) {
+	if event.BeforeUpdate == nil {
+		event.BeforeUpdate = &discordgo.VoiceState{}
+	}
+	// ミュートの除外判定に使うロールを State に入れておく
+	if event.Member != nil {
+		member := *event.Member
+		member.GuildID = event.GuildID
+		if err := st.sess.State.MemberAdd(&member); err != nil {
+			st.logger.Warn("cannot cache member status", zap.Error(err))
+		}
+	}
+
+	// 終わったセッションの部屋に残ったメンバーのミュートの切り替えなどでは始めない
+	joined := event.ChannelID != "" && event.ChannelID != event.BeforeUpdate.ChannelID
+	if _, exist := st.serverStatuses[event.ChannelID]; !exist && joined {
+		st.logger.Debug("check join and start chatspace server")
+		ch, err := st.sess.Channel(event.ChannelID)
+		if err != nil {
+			st.logger.Error("failed get discord channel status", zap.Error(err))
+			return
+		}
+
+		if st.config.Guild(event.GuildID).IsManagedChannel(ch) {
+			withVoice := st.voiceRoomOf(event.GuildID) == nil
+			st.logger.Debug("request new chatspace server instance", zap.Bool("withVoice", withVoice))
+			// 入室したメンバーは onVoiceChangeUpdate で加える
+			memberIDs := []string{}
+			for _, memberID := range st.voiceMembersOf(event.GuildID, event.ChannelID) {
+				if memberID != event.UserID {
+					memberIDs = append(memberIDs, memberID)
+				}
+			}
+			serverStatus, err := NewServerStatus(st.baseLogger, st.sess, st.voicevoxApp, st.schedules, st.stores, st.config.Guild(event.GuildID), event.GuildID, event.ChannelID, event.UserID, memberIDs, withVoice)
+			if err != nil {
+				st.logger.Error("failed new chatspace server instance", zap.Error(err))
+			} else {
+				serverStatus.OnFinished(st.closeRoom)
+				st.serverStatuses[event.ChannelID] = serverStatus
+			}
+		}
+	}
+
+	// 作業中の部屋どうしを移動したときに最後にミュートされるよう、移動元から処理する
+	for _, channelID := range []string{event.BeforeUpdate.ChannelID, event.ChannelID} {
+		serverStatus, exist := st.serverStatuses[channelID]
+		if !exist {
+			continue
+		}
+		if isClose := serverStatus.onVoiceChangeUpdate(st.sess, &event); isClose {
+			st.closeRoom(serverStatus)
+		}
+	}
+
+	// 以前にミュートしたまま残っているメンバーがボイスチャンネルに来たら解除する
+	// 切断したメンバーからはロールを外し、サーバーミュートは次に来たときに解除する
+	st.reconciler.reconcileUser(st.serverStatuses, event.GuildID, event.UserID)
+}
+
 // Close the chatspace aplication service.
 func (sc *ServiceController) Close() error {
 	wg := sync.WaitGroup{}
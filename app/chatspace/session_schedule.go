@@ -0,0 +1,264 @@
+package chatspace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// plannedSession is an occurrence of SessionSchedule.
+type plannedSession struct {
+	guildID    string
+	schedule   SessionSchedule
+	start, end time.Time
+	// Discord のイベント (作れなかった場合は空)
+	eventID string
+}
+
+// alignedPhase returns the phase at now of the session whose first work phase starts at start.
+func alignedPhase(config GuildConfig, start, now time.Time) sessionRecord {
+	return advancePhase(config, sessionRecord{
+		Mode:       serverStatusModeChat,
+		PhaseEndAt: start,
+	}, now)
+}
+
+// planSchedules plans the scheduled sessions of the guild once after the bot joins it.
+func (st *serviceState) planSchedules(guildID string) {
+	if _, exist := st.plannedGuilds[guildID]; exist {
+		return
+	}
+	st.plannedGuilds[guildID] = struct{}{}
+
+	for _, schedule := range st.config.Guild(guildID).Schedules {
+		st.planNext(guildID, schedule, time.Now())
+	}
+}
+
+// planNext schedules the first session of the schedule which has not ended at now.
+func (st *serviceState) planNext(guildID string, schedule SessionSchedule, now time.Time) {
+	start, end, exist := st.config.Guild(guildID).nextScheduledSession(schedule, now)
+	if !exist {
+		return
+	}
+
+	planned := &plannedSession{
+		guildID:  guildID,
+		schedule: schedule,
+		start:    start,
+		end:      end,
+	}
+	planned.eventID = st.scheduledEventOf(planned)
+	st.logger.Info("planned scheduled session",
+		zap.String("guildID", guildID),
+		zap.String("channelID", schedule.ChannelID),
+		zap.Time("start", start),
+		zap.Time("end", end),
+	)
+
+	// 開始時刻を過ぎていればすぐに開く
+	st.schedules.Schedule(start, func() {
+		st.openScheduledSession(planned)
+	})
+}
+
+// scheduledEventOf returns the Discord scheduled event of the session, creating it if it is not made yet.
+func (st *serviceState) scheduledEventOf(planned *plannedSession) string {
+	events, err := st.sess.GuildScheduledEvents(planned.guildID, false)
+	if err != nil {
+		st.logger.Error("cannot get scheduled events", zap.String("guildID", planned.guildID), zap.Error(err))
+		return ""
+	}
+	for _, event := range events {
+		if event.ChannelID == planned.schedule.ChannelID && event.ScheduledStartTime.Equal(planned.start) {
+			return event.ID
+		}
+	}
+
+	// 過去の開始時刻ではイベントを作れない
+	if !planned.start.After(time.Now()) {
+		return ""
+	}
+
+	config := st.config.Guild(planned.guildID)
+	name := planned.schedule.Name
+	if name == "" {
+		name = "もくもく作業会"
+	}
+	event, err := st.sess.GuildScheduledEventCreate(planned.guildID, &discordgo.GuildScheduledEventParams{
+		ChannelID:          planned.schedule.ChannelID,
+		Name:               name,
+		Description:        fmt.Sprintf("作業%d分・休憩%d分のサイクルで%sまで作業します。途中からの参加も歓迎です。", config.WorkMinutes, config.BreakMinutes, config.formatClock(planned.end)),
+		ScheduledStartTime: &planned.start,
+		ScheduledEndTime:   &planned.end,
+		PrivacyLevel:       discordgo.GuildScheduledEventPrivacyLevelGuildOnly,
+		EntityType:         discordgo.GuildScheduledEventEntityTypeVoice,
+	})
+	if err != nil {
+		st.logger.Error("cannot create scheduled event", zap.String("guildID", planned.guildID), zap.Error(err))
+		return ""
+	}
+	return event.ID
+}
+
+func (st *serviceState) setScheduledEventStatus(planned *plannedSession, status discordgo.GuildScheduledEventStatus) {
+	if planned.eventID == "" {
+		return
+	}
+	if _, err := st.sess.GuildScheduledEventEdit(planned.guildID, planned.eventID, &discordgo.GuildScheduledEventParams{
+		Status: status,
+	}); err != nil {
+		st.logger.Error("cannot change scheduled event status", zap.String("eventID", planned.eventID), zap.Error(err))
+	}
+}
+
+func (st *serviceState) openScheduledSession(planned *plannedSession) {
+	channelID := planned.schedule.ChannelID
+	st.planNext(planned.guildID, planned.schedule, planned.end)
+	if !time.Now().Before(planned.end) {
+		return
+	}
+	st.setScheduledEventStatus(planned, discordgo.GuildScheduledEventStatusActive)
+	st.schedules.Schedule(planned.end, func() {
+		st.closeScheduledSession(planned)
+	})
+
+	if ss, exist := st.serverStatuses[channelID]; exist {
+		// 再起動から復帰した作業会はそのまま続ける
+		if !ss.ScheduledUntil(planned.end) {
+			ss.StartScheduled(planned.start, planned.end)
+		}
+		return
+	}
+
+	memberIDs := st.voiceMembersOf(planned.guildID, channelID)
+	st.logger.Info("open the scheduled chatspace server", zap.String("guildID", planned.guildID), zap.String("channelID", channelID), zap.Int("members", len(memberIDs)))
+	serverStatus, err := NewScheduledServerStatus(st.baseLogger, st.sess, st.voicevoxApp, st.schedules, st.stores, st.config.Guild(planned.guildID), planned.guildID, channelID, memberIDs, planned.start, planned.end, st.voiceRoomOf(planned.guildID) == nil)
+	if err != nil {
+		st.logger.Error("failed new scheduled chatspace server instance", zap.Error(err))
+		return
+	}
+	serverStatus.OnFinished(st.closeRoom)
+	st.serverStatuses[channelID] = serverStatus
+}
+
+func (st *serviceState) closeScheduledSession(planned *plannedSession) {
+	st.setScheduledEventStatus(planned, discordgo.GuildScheduledEventStatusCompleted)
+
+	ss, exist := st.serverStatuses[planned.schedule.ChannelID]
+	if !exist || !ss.ScheduledUntil(planned.end) {
+		return
+	}
+	ss.FinishScheduled()
+}
+
+// isScheduled reports whether the session is a scheduled one before its end
+func (ss *ServerStatus) isScheduled() bool {
+	return !ss.scheduledEndAt.IsZero() && !ss.finished && time.Now().Before(ss.scheduledEndAt)
+}
+
+// clipPhaseEnd keeps the phase from running past the end of the scheduled session
+func (ss *ServerStatus) clipPhaseEnd() {
+	if !ss.scheduledEndAt.IsZero() && ss.phaseEndAt.After(ss.scheduledEndAt) {
+		ss.phaseEndAt = ss.scheduledEndAt
+	}
+}
+
+// ScheduledUntil reports whether the room runs the scheduled session ending at end.
+func (ss *ServerStatus) ScheduledUntil(end time.Time) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	return ss.scheduledEndAt.Equal(end)
+}
+
+func (ss *ServerStatus) memberCount() int {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	return len(ss.memberIDs)
+}
+
+// StartScheduled aligns the phases of the room to the scheduled session from start to end.
+func (ss *ServerStatus) StartScheduled(start, end time.Time) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.isClosed {
+		return
+	}
+
+	now := time.Now()
+	for memberId := range ss.memberIDs {
+		ss.recordWork(memberId, now, false)
+	}
+	ss.cancelPhaseEnd()
+
+	record := alignedPhase(ss.config, start, now)
+	ss.scheduledEndAt = end
+	ss.mode = record.Mode
+	ss.cycle = record.Cycle
+	ss.finished = record.Finished
+	ss.phaseEndAt = record.PhaseEndAt
+	ss.pausedRemaining = 0
+	ss.pausedAt = time.Time{}
+	ss.clipPhaseEnd()
+	ss.logger.Info("started scheduled session",
+		zap.Time("start", start),
+		zap.Time("end", end),
+		zap.Stringer("mode", ss.mode),
+		zap.Int("cycle", ss.cycle),
+	)
+
+	for memberId := range ss.memberIDs {
+		ss.startWork(memberId, now)
+		ss.setMute(memberId, ss.mode == serverStatusModeWork && !ss.finished)
+	}
+	ss.updateChannelStatus()
+
+	nextTime := ss.config.formatClock(ss.phaseEndAt)
+	ss.announce(false, fmt.Sprintf("予定の作業会を%sまで行います。", ss.config.formatClock(end)))
+	ss.announce(false, fmt.Sprintf("今は%sで、%sは%sです。", ss.phaseName(), ss.nextPhaseName(), nextTime))
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "📅予定の作業会です" + ss.cycleLabel(),
+		Description: fmt.Sprintf("%sまで作業%d分・休憩%d分のサイクルで進めます。途中から来た人も今の%sに合流してください。", ss.config.formatClock(end), ss.config.WorkMinutes, ss.config.BreakMinutes, ss.phaseName()),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%sは%sごろからです", ss.nextPhaseName(), nextTime),
+		},
+	})
+
+	if !ss.finished {
+		if ss.mode == serverStatusModeWork {
+			ss.openGoalThread()
+		}
+		ss.schedulePhaseEnd()
+	}
+	ss.touchStatus()
+	ss.saveSession()
+}
+
+// FinishScheduled ends the scheduled session at its end time.
+func (ss *ServerStatus) FinishScheduled() {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.isClosed || ss.finished {
+		return
+	}
+
+	ss.logger.Info("finished scheduled session", zap.Int("cycle", ss.cycle))
+	// 終了時刻まで続いた作業時間はポモドーロに数える
+	ss.finishSession(ss.mode == serverStatusModeWork && !ss.isPaused() && !time.Now().Before(ss.phaseEndAt))
+
+	ss.announce(false, "予定の時間になったので作業会を終了します。お疲れ様でした。")
+	ss.sendEmbed(&discordgo.MessageEmbed{
+		Title:       "🎉予定の作業会が終了しました！",
+		Description: fmt.Sprintf("%d回の作業お疲れ様でした。ミュートは解除したので自由に話してください。", ss.cycle),
+	})
+	ss.reviewGoals()
+	ss.touchStatus()
+	ss.saveSession()
+	ss.scheduleClose()
+}
@@ -0,0 +1,59 @@
+package chatspace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+
+	location, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := SessionSchedule{ChannelID: "room", Weekdays: []string{"mon", "Fri"}, Start: "09:00", End: "12:00"}
+	if err := schedule.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2023-01-02 は月曜日
+	testcases := []struct {
+		name          string
+		now           time.Time
+		expectedStart time.Time
+	}{
+		{"before the start", time.Date(2023, 1, 2, 8, 0, 0, 0, location), time.Date(2023, 1, 2, 9, 0, 0, 0, location)},
+		{"during the session", time.Date(2023, 1, 2, 10, 30, 0, 0, location), time.Date(2023, 1, 2, 9, 0, 0, 0, location)},
+		{"after the end", time.Date(2023, 1, 2, 12, 0, 0, 0, location), time.Date(2023, 1, 6, 9, 0, 0, 0, location)},
+		{"over the weekend", time.Date(2023, 1, 7, 10, 0, 0, 0, location), time.Date(2023, 1, 9, 9, 0, 0, 0, location)},
+	}
+
+	for _, tc := range testcases {
+		start, end, exist := schedule.next(location, tc.now)
+		if !exist || !start.Equal(tc.expectedStart) || !end.Equal(tc.expectedStart.Add(3*time.Hour)) {
+			t.Errorf("%s: unexpected session %v - %v", tc.name, start, end)
+		}
+	}
+
+	invalid := SessionSchedule{ChannelID: "room", Start: "12:00", End: "09:00"}
+	if err := invalid.compile(); err == nil {
+		t.Error("schedule ending before the start is accepted")
+	}
+}
+
+func TestAlignedPhase(t *testing.T) {
+
+	config := GuildConfig{WorkMinutes: 45, BreakMinutes: 15}
+	start := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	// 途中から来ても壁時計に合わせた今のフェーズに入る
+	if r := alignedPhase(config, start, start.Add(50*timeStep)); r.Mode != serverStatusModeChat || r.Cycle != 1 || !r.PhaseEndAt.Equal(start.Add(60*timeStep)) {
+		t.Errorf("unexpected phase in the first break: %+v", r)
+	}
+	if r := alignedPhase(config, start, start.Add(70*timeStep)); r.Mode != serverStatusModeWork || r.Cycle != 2 || !r.PhaseEndAt.Equal(start.Add(105*timeStep)) {
+		t.Errorf("unexpected phase in the second work: %+v", r)
+	}
+	if r := alignedPhase(config, start, start); r.Mode != serverStatusModeWork || r.Cycle != 1 {
+		t.Errorf("unexpected phase at the start: %+v", r)
+	}
+}
@@ -0,0 +1,177 @@
+package chatspace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streamwest-1629/chatspace/lib/store"
+)
+
+// sessionRecord is the state of a running session saved to resume it after a restart.
+type sessionRecord struct {
+	GuildID         string           `json:"guildID"`
+	ChannelID       string           `json:"channelID"`
+	Mode            serverStatusMode `json:"mode"`
+	Cycle           int              `json:"cycle"`
+	Finished        bool             `json:"finished"`
+	PhaseEndAt      time.Time        `json:"phaseEndAt"`
+	MemberIDs       []string         `json:"memberIDs"`
+	AnnounceSpeaker string           `json:"announceSpeaker"`
+	StartedAt       time.Time        `json:"startedAt"`
+	GoalThreadID    string           `json:"goalThreadID,omitempty"`
+	StatusMessageID string           `json:"statusMessageID,omitempty"`
+	// PausedRemaining is the remaining time of the paused phase.
+	PausedRemaining time.Duration `json:"pausedRemaining,omitempty"`
+	// ScheduledEndAt is the end of the scheduled session.
+	ScheduledEndAt time.Time `json:"scheduledEndAt,omitempty"`
+	OwnerID        string    `json:"ownerID,omitempty"`
+	// Timer is set for the personal timer of a member instead of a room.
+	Timer *timerRecord `json:"timer,omitempty"`
+}
+
+// timerRecord is the owner and the lengths of a personal timer.
+type timerRecord struct {
+	UserID           string `json:"userID"`
+	WorkMinutes      int    `json:"workMinutes"`
+	BreakMinutes     int    `json:"breakMinutes"`
+	LongBreakMinutes int    `json:"longBreakMinutes"`
+	CyclesPerSession int    `json:"cyclesPerSession"`
+}
+
+// 個人タイマーはギルドとメンバーごとに1つ
+func timerKey(guildID, userID string) string {
+	return guildID + "/" + userID
+}
+
+func (r sessionRecord) key() string {
+	if r.Timer != nil {
+		return timerKey(r.GuildID, r.Timer.UserID)
+	}
+	return r.ChannelID
+}
+
+// channelID -> session, timerKey -> personal timer
+type sessionStoreData map[string]sessionRecord
+
+type sessionStore struct {
+	file *store.File[sessionStoreData]
+}
+
+func newSessionStore(path string) *sessionStore {
+	return &sessionStore{
+		file: store.NewFile[sessionStoreData](path),
+	}
+}
+
+func (s *sessionStore) loadAll() (data sessionStoreData, err error) {
+	err = s.file.Update(func(saved *sessionStoreData) error {
+		migrateSessions(*saved)
+		data = *saved
+		return nil
+	})
+	return data, err
+}
+
+// 1部屋だった頃はギルドIDをキーに guildID なしで保存していた
+func migrateSessions(data sessionStoreData) {
+	for key, record := range data {
+		if record.GuildID != "" {
+			continue
+		}
+		delete(data, key)
+		record.GuildID = key
+		data[record.ChannelID] = record
+	}
+}
+
+func (s *sessionStore) save(record sessionRecord) error {
+	return s.file.Update(func(data *sessionStoreData) error {
+		if *data == nil {
+			*data = sessionStoreData{}
+		}
+		(*data)[record.key()] = record
+		return nil
+	})
+}
+
+// remove forgets the session of the channel, or the personal timer of timerKey.
+func (s *sessionStore) remove(key string) error {
+	return s.file.Update(func(data *sessionStoreData) error {
+		delete(*data, key)
+		return nil
+	})
+}
+
+// advancePhase follows the phases which would have passed by now since the record was saved.
+func advancePhase(config GuildConfig, record sessionRecord, now time.Time) sessionRecord {
+	if record.PausedRemaining > 0 {
+		// 一時停止中のフェーズは停止中に時間が進まない
+		return record
+	}
+	for !record.Finished && !now.Before(record.PhaseEndAt) {
+		record = nextPhase(config, record)
+	}
+	return record
+}
+
+// nextPhase returns the phase following the one of the record, finishing the session after the last cycle.
+func nextPhase(config GuildConfig, record sessionRecord) sessionRecord {
+	switch record.Mode {
+	case serverStatusModeChat:
+		record.Mode = serverStatusModeWork
+		record.Cycle++
+		record.PhaseEndAt = record.PhaseEndAt.Add(config.workTime())
+
+	case serverStatusModeWork:
+		record.Mode = serverStatusModeChat
+		if config.isLastCycle(record.Cycle) {
+			record.Finished = true
+			record.PhaseEndAt = time.Time{}
+		} else {
+			record.PhaseEndAt = record.PhaseEndAt.Add(config.breakTime(record.Cycle))
+		}
+	}
+	return record
+}
+
+// 再起動までにこの回数以上のフェーズが過ぎたセッションは復元しない
+const staleSessionPhases = 3
+
+// isStale reports whether the saved phase ended too long ago to resume the session.
+func isStale(config GuildConfig, record sessionRecord, now time.Time) bool {
+	if record.Finished || record.PausedRemaining > 0 || record.PhaseEndAt.IsZero() {
+		return false
+	}
+	phases := config.workTime() + config.breakTime(record.Cycle)
+	return now.Sub(record.PhaseEndAt) > staleSessionPhases*phases/2
+}
+
+func (m serverStatusMode) String() string {
+	switch m {
+	case serverStatusModeChat:
+		return "chat"
+	case serverStatusModeWork:
+		return "work"
+	}
+	return fmt.Sprintf("serverStatusMode(%d)", int(m))
+}
+
+func (m serverStatusMode) MarshalText() ([]byte, error) {
+	switch m {
+	case serverStatusModeChat, serverStatusModeWork:
+		return []byte(m.String()), nil
+	}
+	return nil, fmt.Errorf("unknown server status mode: %d", m)
+}
+
+func (m *serverStatusMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "chat":
+		*m = serverStatusModeChat
+	case "work":
+		*m = serverStatusModeWork
+	default:
+		return fmt.Errorf("unknown server status mode: %s", text)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package chatspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdvancePhase(t *testing.T) {
+
+	config := GuildConfig{WorkMinutes: 25, BreakMinutes: 5, CyclesPerSession: 2}
+	base := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	record := sessionRecord{
+		Mode:       serverStatusModeWork,
+		Cycle:      1,
+		PhaseEndAt: base,
+	}
+
+	// 保存された作業時間の途中
+	if r := advancePhase(config, record, base.Add(-time.Minute)); r.Mode != serverStatusModeWork || r.Cycle != 1 || !r.PhaseEndAt.Equal(base) {
+		t.Errorf("unexpected phase before the end: %+v", r)
+	}
+
+	// 休憩時間を越えて2回目の作業時間
+	if r := advancePhase(config, record, base.Add(10*time.Minute)); r.Mode != serverStatusModeWork || r.Cycle != 2 || !r.PhaseEndAt.Equal(base.Add(30*time.Minute)) {
+		t.Errorf("unexpected phase after the break: %+v", r)
+	}
+
+	// 全サイクルを終えている
+	if r := advancePhase(config, record, base.Add(time.Hour)); !r.Finished || r.Cycle != 2 {
+		t.Errorf("unexpected phase after the session: %+v", r)
+	}
+
+	// 一時停止中は進まない
+	paused := record
+	paused.PausedRemaining = 5 * time.Minute
+	if r := advancePhase(config, paused, base.Add(time.Hour)); r.Mode != serverStatusModeWork || r.Cycle != 1 || r.Finished {
+		t.Errorf("unexpected phase of the paused session: %+v", r)
+	}
+}
+
+func TestIsStale(t *testing.T) {
+
+	config := GuildConfig{WorkMinutes: 25, BreakMinutes: 5}
+	base := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	record := sessionRecord{
+		Mode:       serverStatusModeWork,
+		Cycle:      1,
+		PhaseEndAt: base,
+	}
+
+	if isStale(config, record, base.Add(30*time.Minute)) {
+		t.Error("session a phase behind is stale")
+	}
+	if !isStale(config, record, base.Add(2*time.Hour)) {
+		t.Error("session hours behind is not stale")
+	}
+	paused := record
+	paused.PausedRemaining = 5 * time.Minute
+	if isStale(config, paused, base.Add(2*time.Hour)) {
+		t.Error("paused session is stale")
+	}
+}
+
+func TestSessionStoreMigration(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	if err := os.WriteFile(path, []byte(`{"guild": {"channelID": "channel", "mode": "work", "cycle": 2}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := newSessionStore(path).loadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, exist := data["channel"]; !exist || loaded.GuildID != "guild" || loaded.Cycle != 2 || len(data) != 1 {
+		t.Errorf("unexpected migrated records: %+v", data)
+	}
+}
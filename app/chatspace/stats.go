@@ -0,0 +1,93 @@
+package chatspace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var leaderboardMedals = []string{"🥇", "🥈", "🥉"}
+
+func formatDayStats(stats dayStats) string {
+	return fmt.Sprintf("%d分（%dポモドーロ）", stats.WorkMinutes, stats.Pomodoros)
+}
+
+func (st *serviceState) statsEmbed(guildID, memberID string) *discordgo.MessageEmbed {
+	stats, err := st.stores.stats.guild(guildID)
+	if err != nil {
+		st.logger.Error("cannot load stats", zap.Error(err))
+		return &discordgo.MessageEmbed{
+			Title: "🤯記録を読み込めませんでした",
+		}
+	}
+
+	summary := summarizeStats(stats.Members[memberID], st.config.Guild(guildID).localTime(time.Now()))
+	return &discordgo.MessageEmbed{
+		Title:       "📊作業の記録",
+		Description: fmt.Sprintf("<@%s> さんの記録です。", memberID),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "今日", Value: formatDayStats(summary.Today), Inline: true},
+			{Name: "今週", Value: formatDayStats(summary.Week), Inline: true},
+			{Name: "今月", Value: formatDayStats(summary.Month), Inline: true},
+			{Name: "連続記録", Value: fmt.Sprintf("%d日（最長%d日）", summary.Streak, summary.BestStreak)},
+		},
+	}
+}
+
+// postLeaderboards posts the leaderboard of the last week to each guild once.
+func (st *serviceState) postLeaderboards() {
+	data, err := st.stores.stats.loadAll()
+	if err != nil {
+		st.logger.Error("cannot load stats", zap.Error(err))
+		return
+	}
+
+	for guildID, stats := range data {
+		lastWeek := weekStart(st.config.Guild(guildID).localTime(time.Now())).AddDate(0, 0, -7)
+		lastWeekKey := lastWeek.Format(dateLayout)
+		if stats.ChannelID == "" || lastWeekKey <= stats.LastLeaderboard {
+			continue
+		}
+
+		if entries := leaderboard(stats, lastWeek); len(entries) > 0 {
+			if _, err := st.sess.ChannelMessageSendEmbed(stats.ChannelID, leaderboardEmbed(st.config.Guild(guildID), entries, lastWeek)); err != nil {
+				st.logger.Error("failed send message", zap.String("channelID", stats.ChannelID), zap.Error(err))
+				continue
+			}
+			st.logger.Info("posted weekly leaderboard", zap.String("guildID", guildID), zap.String("week", lastWeekKey))
+		}
+
+		if err := st.stores.stats.update(guildID, func(stats *guildStats) error {
+			stats.LastLeaderboard = lastWeekKey
+			return nil
+		}); err != nil {
+			st.logger.Error("cannot save stats", zap.Error(err))
+		}
+	}
+}
+
+func leaderboardEmbed(config GuildConfig, entries []leaderboardEntry, weekFirstDay time.Time) *discordgo.MessageEmbed {
+	lines := []string{}
+	for i, entry := range entries {
+		// 上位10人まで
+		if i >= 10 {
+			break
+		}
+		rank := fmt.Sprintf("%d.", i+1)
+		if i < len(leaderboardMedals) {
+			rank = leaderboardMedals[i]
+		}
+		lines = append(lines, fmt.Sprintf("%s <@%s> %s", rank, entry.UserID, formatDayStats(entry.Stats)))
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "🏆先週のランキング",
+		Description: strings.Join(lines, "\n"),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%s〜%s の作業時間", config.formatDate(weekFirstDay), config.formatDate(weekFirstDay.AddDate(0, 0, 6))),
+		},
+	}
+}
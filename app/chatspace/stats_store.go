@@ -0,0 +1,280 @@
+package chatspace
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamwest-1629/chatspace/lib/store"
+)
+
+const dateLayout = "2006-01-02"
+
+// dayStats is the work of a member in a day.
+type dayStats struct {
+	WorkMinutes int `json:"workMinutes"`
+	Pomodoros   int `json:"pomodoros"`
+}
+
+func (d dayStats) add(other dayStats) dayStats {
+	return dayStats{
+		WorkMinutes: d.WorkMinutes + other.WorkMinutes,
+		Pomodoros:   d.Pomodoros + other.Pomodoros,
+	}
+}
+
+func (d dayStats) isZero() bool {
+	return d.WorkMinutes == 0 && d.Pomodoros == 0
+}
+
+type guildStats struct {
+	// ChannelID is the managed channel used last, where the weekly leaderboard is posted.
+	ChannelID string `json:"channelID"`
+	// LastLeaderboard is the first day of the week covered by the last leaderboard.
+	LastLeaderboard string `json:"lastLeaderboard"`
+	// userID -> date (in the time zone of the guild) -> stats
+	Members map[string]map[string]dayStats `json:"members"`
+	// userID -> goals in the declared order
+	Goals map[string][]goalRecord `json:"goals"`
+}
+
+// メンバーごとに残す目標の数 (古いものから消す)
+var goalHistoryLength = 100
+
+// goalRecord is a goal declared for a work phase and how it went.
+type goalRecord struct {
+	SessionStartedAt time.Time `json:"sessionStartedAt"`
+	Cycle            int       `json:"cycle"`
+	Date             string    `json:"date"`
+	Goal             string    `json:"goal"`
+	Outcome          string    `json:"outcome,omitempty"`
+}
+
+// goalKey identifies a work phase.
+type goalKey struct {
+	SessionStartedAt time.Time
+	Cycle            int
+}
+
+func (g goalRecord) key() goalKey {
+	return goalKey{SessionStartedAt: g.SessionStartedAt, Cycle: g.Cycle}
+}
+
+func (k goalKey) equal(other goalKey) bool {
+	return k.SessionStartedAt.Equal(other.SessionStartedAt) && k.Cycle == other.Cycle
+}
+
+// guildID -> stats
+type statsData map[string]*guildStats
+
+type statsStore struct {
+	file *store.File[statsData]
+}
+
+func newStatsStore(path string) *statsStore {
+	return &statsStore{
+		file: store.NewFile[statsData](path),
+	}
+}
+
+func (s *statsStore) loadAll() (statsData, error) {
+	return s.file.Load()
+}
+
+func (s *statsStore) guild(guildID string) (*guildStats, error) {
+	data, err := s.file.Load()
+	if err != nil {
+		return nil, err
+	}
+	if stats, exist := data[guildID]; exist {
+		return stats, nil
+	}
+	return &guildStats{}, nil
+}
+
+func (s *statsStore) update(guildID string, fn func(stats *guildStats) error) error {
+	return s.file.Update(func(data *statsData) error {
+		if *data == nil {
+			*data = statsData{}
+		}
+		stats, exist := (*data)[guildID]
+		if !exist {
+			stats = &guildStats{}
+			(*data)[guildID] = stats
+		}
+		if stats.Members == nil {
+			stats.Members = map[string]map[string]dayStats{}
+		}
+		if stats.Goals == nil {
+			stats.Goals = map[string][]goalRecord{}
+		}
+		return fn(stats)
+	})
+}
+
+// addWork adds the work of the member to the day. The leaderboard is posted to channelID unless it is empty.
+func (s *statsStore) addWork(guildID, channelID, userID, date string, work dayStats) error {
+	return s.update(guildID, func(stats *guildStats) error {
+		// 個人タイマーの記録では投稿先を変えない
+		if channelID != "" {
+			stats.ChannelID = channelID
+		}
+		if stats.Members[userID] == nil {
+			stats.Members[userID] = map[string]dayStats{}
+		}
+		stats.Members[userID][date] = stats.Members[userID][date].add(work)
+		return nil
+	})
+}
+
+// updateGoal applies fn to the goal of the member for the work phase, adding it if not declared yet.
+func (s *statsStore) updateGoal(guildID, userID string, key goalKey, date string, fn func(goal *goalRecord)) error {
+	return s.update(guildID, func(stats *guildStats) error {
+		goals := stats.Goals[userID]
+		for i := range goals {
+			if goals[i].key().equal(key) {
+				fn(&goals[i])
+				return nil
+			}
+		}
+
+		goal := goalRecord{
+			SessionStartedAt: key.SessionStartedAt,
+			Cycle:            key.Cycle,
+			Date:             date,
+		}
+		fn(&goal)
+		goals = append(goals, goal)
+		if over := len(goals) - goalHistoryLength; over > 0 {
+			goals = goals[over:]
+		}
+		stats.Goals[userID] = goals
+		return nil
+	})
+}
+
+// goalsOf returns the goals of every member declared for the work phase.
+func (s *statsStore) goalsOf(guildID string, key goalKey) (map[string]goalRecord, error) {
+	stats, err := s.guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	goals := map[string]goalRecord{}
+	for userID, records := range stats.Goals {
+		for _, goal := range records {
+			if goal.key().equal(key) {
+				goals[userID] = goal
+			}
+		}
+	}
+	return goals, nil
+}
+
+// statsSummary is the totals of a member shown by /stats.
+type statsSummary struct {
+	Today      dayStats
+	Week       dayStats
+	Month      dayStats
+	Streak     int
+	BestStreak int
+}
+
+// weekStart returns the Monday of the week of the day.
+func weekStart(day time.Time) time.Time {
+	y, m, d := day.Date()
+	offset := (int(day.Weekday()) + 6) % 7
+	return time.Date(y, m, d-offset, 0, 0, 0, 0, day.Location())
+}
+
+// summarizeStats totals the days of a member. now must be in the time zone of the guild.
+func summarizeStats(days map[string]dayStats, now time.Time) statsSummary {
+	summary := statsSummary{}
+
+	today := now.Format(dateLayout)
+	week := weekStart(now).Format(dateLayout)
+	month := now.Format("2006-01")
+
+	for date, stats := range days {
+		if date == today {
+			summary.Today = summary.Today.add(stats)
+		}
+		if week <= date && date <= today {
+			summary.Week = summary.Week.add(stats)
+		}
+		if strings.HasPrefix(date, month) && date <= today {
+			summary.Month = summary.Month.add(stats)
+		}
+	}
+
+	// 今日まだ作業していなくても昨日まで続いていれば連続記録は途切れていない
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	if days[day.Format(dateLayout)].isZero() {
+		day = day.AddDate(0, 0, -1)
+	}
+	for !days[day.Format(dateLayout)].isZero() {
+		summary.Streak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	dates := make([]string, 0, len(days))
+	for date, stats := range days {
+		if !stats.isZero() {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	streak := 0
+	for i, date := range dates {
+		if i > 0 && !isNextDay(dates[i-1], date) {
+			streak = 0
+		}
+		streak++
+		if streak > summary.BestStreak {
+			summary.BestStreak = streak
+		}
+	}
+
+	return summary
+}
+
+func isNextDay(prev, next string) bool {
+	prevDay, err := time.Parse(dateLayout, prev)
+	if err != nil {
+		return false
+	}
+	return prevDay.AddDate(0, 0, 1).Format(dateLayout) == next
+}
+
+type leaderboardEntry struct {
+	UserID string
+	Stats  dayStats
+}
+
+// leaderboard ranks the members by the work minutes in the week from weekFirstDay.
+func leaderboard(stats *guildStats, weekFirstDay time.Time) []leaderboardEntry {
+	from := weekFirstDay.Format(dateLayout)
+	to := weekFirstDay.AddDate(0, 0, 6).Format(dateLayout)
+
+	entries := []leaderboardEntry{}
+	for userID, days := range stats.Members {
+		total := dayStats{}
+		for date, day := range days {
+			if from <= date && date <= to {
+				total = total.add(day)
+			}
+		}
+		if !total.isZero() {
+			entries = append(entries, leaderboardEntry{UserID: userID, Stats: total})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Stats.WorkMinutes != entries[j].Stats.WorkMinutes {
+			return entries[i].Stats.WorkMinutes > entries[j].Stats.WorkMinutes
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	return entries
+}
@@ -0,0 +1,131 @@
+package chatspace
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSummarizeStats(t *testing.T) {
+
+	// 2022-10-05 は水曜日
+	now := time.Date(2022, 10, 5, 20, 0, 0, 0, time.UTC)
+	days := map[string]dayStats{
+		"2022-09-20": {WorkMinutes: 45, Pomodoros: 1},
+		"2022-09-21": {WorkMinutes: 45, Pomodoros: 1},
+		"2022-09-22": {WorkMinutes: 45, Pomodoros: 1},
+		"2022-09-30": {WorkMinutes: 10},
+		"2022-10-02": {WorkMinutes: 90, Pomodoros: 2},
+		"2022-10-03": {WorkMinutes: 45, Pomodoros: 1},
+		"2022-10-04": {WorkMinutes: 30},
+		// 壊れた日付は数えない
+		"10": {WorkMinutes: 1},
+	}
+
+	summary := summarizeStats(days, now)
+	if !summary.Today.isZero() {
+		t.Errorf("unexpected today: %+v", summary.Today)
+	}
+	if summary.Week != (dayStats{WorkMinutes: 75, Pomodoros: 1}) {
+		t.Errorf("unexpected week: %+v", summary.Week)
+	}
+	if summary.Month != (dayStats{WorkMinutes: 165, Pomodoros: 3}) {
+		t.Errorf("unexpected month: %+v", summary.Month)
+	}
+	if summary.Streak != 3 || summary.BestStreak != 3 {
+		t.Errorf("unexpected streak: %d (best %d)", summary.Streak, summary.BestStreak)
+	}
+
+	days["2022-10-05"] = dayStats{WorkMinutes: 5}
+	days["2022-10-01"] = dayStats{WorkMinutes: 5}
+	if summary := summarizeStats(days, now); summary.Streak != 6 || summary.BestStreak != 6 {
+		t.Errorf("unexpected streak: %d (best %d)", summary.Streak, summary.BestStreak)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+
+	s := newStatsStore(filepath.Join(t.TempDir(), "stats.json"))
+	for _, work := range []struct {
+		userID, date string
+		stats        dayStats
+	}{
+		{"a", "2022-10-03", dayStats{WorkMinutes: 45, Pomodoros: 1}},
+		{"a", "2022-10-09", dayStats{WorkMinutes: 45, Pomodoros: 1}},
+		{"b", "2022-10-04", dayStats{WorkMinutes: 120, Pomodoros: 2}},
+		{"c", "2022-10-10", dayStats{WorkMinutes: 300, Pomodoros: 5}},
+	} {
+		if err := s.addWork("guild", "channel", work.userID, work.date, work.stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := s.guild("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ChannelID != "channel" {
+		t.Errorf("unexpected channel: %s", stats.ChannelID)
+	}
+
+	if start := weekStart(time.Date(2022, 10, 9, 12, 0, 0, 0, time.UTC)); start.Format(dateLayout) != "2022-10-03" {
+		t.Errorf("unexpected week start: %v", start)
+	}
+
+	entries := leaderboard(stats, time.Date(2022, 10, 3, 0, 0, 0, 0, time.UTC))
+	if len(entries) != 2 || entries[0].UserID != "b" || entries[1].UserID != "a" || entries[1].Stats.WorkMinutes != 90 {
+		t.Errorf("unexpected leaderboard: %+v", entries)
+	}
+}
+
+func TestGoals(t *testing.T) {
+
+	s := newStatsStore(filepath.Join(t.TempDir(), "stats.json"))
+	startedAt := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	first := goalKey{SessionStartedAt: startedAt, Cycle: 1}
+	second := goalKey{SessionStartedAt: startedAt, Cycle: 2}
+
+	for _, update := range []struct {
+		userID string
+		key    goalKey
+		fn     func(goal *goalRecord)
+	}{
+		{"a", first, func(goal *goalRecord) { goal.Goal = "レポートを書く" }},
+		{"b", first, func(goal *goalRecord) { goal.Goal = "本を読む" }},
+		{"a", first, func(goal *goalRecord) { goal.Outcome = "半分書けた" }},
+		{"a", second, func(goal *goalRecord) { goal.Goal = "レポートを仕上げる" }},
+	} {
+		if err := s.updateGoal("guild", update.userID, update.key, "2022-10-01", update.fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	goals, err := s.goalsOf("guild", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goals) != 2 || goals["a"].Goal != "レポートを書く" || goals["a"].Outcome != "半分書けた" || goals["b"].Goal != "本を読む" {
+		t.Errorf("unexpected goals: %+v", goals)
+	}
+
+	stats, err := s.guild("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := stats.Goals["a"]; len(records) != 2 || records[1].Cycle != 2 {
+		t.Errorf("unexpected goal history: %+v", records)
+	}
+
+	// 古い目標から消える
+	goalHistoryLength = 2
+	defer func() { goalHistoryLength = 100 }()
+	third := goalKey{SessionStartedAt: startedAt, Cycle: 3}
+	if err := s.updateGoal("guild", "a", third, "2022-10-01", func(goal *goalRecord) { goal.Goal = "片付ける" }); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := s.guild("guild"); err != nil {
+		t.Fatal(err)
+	} else if records := stats.Goals["a"]; len(records) != 2 || records[0].Cycle != 2 || records[1].Cycle != 3 {
+		t.Errorf("unexpected pruned goal history: %+v", records)
+	}
+}
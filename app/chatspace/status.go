@@ -0,0 +1,179 @@
+package chatspace
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// statusMinEditInterval limits the edits of the status message caused by joins and phase changes.
+const statusMinEditInterval = 5 * time.Second
+
+// postStatus sends and pins the status message of the session
+func (ss *ServerStatus) postStatus() {
+	msg, err := ss.sess.ChannelMessageSendEmbed(ss.channelID, ss.statusEmbed(time.Now()))
+	if err != nil {
+		ss.logger.Error("failed send message", zap.String("channelID", ss.channelID), zap.Error(err))
+		return
+	}
+	ss.statusMessageID = msg.ID
+	ss.statusEditedAt = time.Now()
+
+	if err := ss.sess.ChannelMessagePin(ss.channelID, msg.ID); err != nil {
+		ss.logger.Warn("cannot pin status message", zap.Error(err))
+	}
+	ss.statusEvent = nil
+	if !ss.finished {
+		ss.statusEvent = ss.schedules.Schedule(time.Now().Add(timeStep), ss.refreshStatus)
+	}
+}
+
+// touchStatus edits the status message soon after a change
+func (ss *ServerStatus) touchStatus() {
+	if ss.statusMessageID == "" {
+		return
+	}
+
+	at := ss.statusEditedAt.Add(statusMinEditInterval)
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	if ss.statusEvent == nil || !ss.statusEvent.Reschedule(minTime(at, ss.statusEvent.At())) {
+		ss.statusEvent = ss.schedules.Schedule(at, ss.refreshStatus)
+	}
+}
+
+// refreshStatus edits the status message and schedules the next edit.
+func (ss *ServerStatus) refreshStatus() {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.isClosed || ss.statusMessageID == "" {
+		return
+	}
+
+	now := time.Now()
+	if _, err := ss.sess.ChannelMessageEditEmbed(ss.channelID, ss.statusMessageID, ss.statusEmbed(now)); isNotFound(err) {
+		// 消されたメッセージは送り直して新しい ID を保存する
+		ss.logger.Info("status message is deleted, post it again", zap.String("messageID", ss.statusMessageID))
+		ss.statusMessageID = ""
+		ss.statusEvent = nil
+		ss.postStatus()
+		ss.saveSession()
+		return
+	} else if err != nil {
+		ss.logger.Error("cannot edit status message", zap.Error(err))
+	}
+	ss.statusEditedAt = now
+	ss.statusEvent = nil
+	// 終わったセッションは残り時間が変わらないので、変化があったときだけ編集する
+	if !ss.finished {
+		ss.statusEvent = ss.schedules.Schedule(now.Add(timeStep), ss.refreshStatus)
+	}
+}
+
+func isNotFound(err error) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
+
+// finalizeStatus replaces the status message with the summary of the session and unpins it.
+func (ss *ServerStatus) finalizeStatus() {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.statusMessageID == "" {
+		return
+	}
+
+	minutes := int(math.Round(float64(time.Since(ss.startedAt)) / float64(timeStep)))
+	embed := &discordgo.MessageEmbed{
+		Title: "📋今回のセッションのまとめ",
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "作業回数", Value: fmt.Sprintf("%d回", ss.cycle), Inline: true},
+			{Name: "時間", Value: fmt.Sprintf("%d分", minutes), Inline: true},
+			{Name: "参加したメンバー", Value: mentionList(ss.participants)},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%s〜%s", ss.config.formatClock(ss.startedAt), ss.config.formatClock(time.Now())),
+		},
+	}
+
+	if _, err := ss.sess.ChannelMessageEditEmbed(ss.channelID, ss.statusMessageID, embed); err != nil {
+		ss.logger.Error("cannot edit status message", zap.Error(err))
+	}
+	if err := ss.sess.ChannelMessageUnpin(ss.channelID, ss.statusMessageID); err != nil {
+		ss.logger.Warn("cannot unpin status message", zap.Error(err))
+	}
+	ss.statusMessageID = ""
+}
+
+func (ss *ServerStatus) statusEmbed(now time.Time) *discordgo.MessageEmbed {
+	phase := "🌿休憩時間"
+	switch {
+	case ss.finished:
+		phase = "🎉セッション終了"
+	case ss.mode == serverStatusModeWork:
+		phase = "🚀作業時間"
+	case ss.config.isLongBreak(ss.cycle):
+		phase = "☕長めの休憩時間"
+	}
+
+	remaining := "-"
+	description := ""
+	if ss.isPaused() {
+		phase += "（一時停止中）"
+		remaining = fmt.Sprintf("約%d分（一時停止中）", ss.pausedMinutes())
+	} else if !ss.finished && !ss.phaseEndAt.IsZero() {
+		minutes := int(math.Ceil(float64(ss.phaseEndAt.Sub(now)) / float64(timeStep)))
+		if minutes < 0 {
+			minutes = 0
+		}
+		remaining = fmt.Sprintf("約%d分（%sまで）", minutes, ss.config.formatClock(ss.phaseEndAt))
+		// Discord のタイムスタンプ表記は見る人の画面で時間が進む
+		description = fmt.Sprintf("次の切り替えは <t:%d:R>", ss.phaseEndAt.Unix())
+	}
+
+	cycle := fmt.Sprintf("%d回目", ss.cycle)
+	if ss.config.CyclesPerSession > 0 {
+		cycle = fmt.Sprintf("%d/%d", ss.cycle, ss.config.CyclesPerSession)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "🍅作業部屋のようす",
+		Description: description,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "現在", Value: phase, Inline: true},
+			{Name: "残り", Value: remaining, Inline: true},
+			{Name: "サイクル", Value: cycle, Inline: true},
+			{Name: fmt.Sprintf("メンバー（%d人）", len(ss.memberIDs)), Value: mentionList(ss.memberIDs)},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "最終更新 " + ss.config.formatClock(now),
+		},
+	}
+}
+
+func mentionList(userIDs map[string]struct{}) string {
+	if len(userIDs) == 0 {
+		return "-"
+	}
+	mentions := []string{}
+	for _, userID := range sortedKeys(userIDs) {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", userID))
+	}
+	return strings.Join(mentions, " ")
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
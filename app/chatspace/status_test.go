@@ -0,0 +1,54 @@
+package chatspace
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusEmbed(t *testing.T) {
+
+	config := DefaultGuildConfig
+	config.CyclesPerSession = 4
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2022, 10, 1, 3, 0, 0, 0, time.UTC)
+	ss := &ServerStatus{
+		config:     config,
+		mode:       serverStatusModeWork,
+		cycle:      2,
+		phaseEndAt: now.Add(90 * time.Second),
+		memberIDs:  map[string]struct{}{"b": {}, "a": {}},
+	}
+
+	embed := ss.statusEmbed(now)
+	values := map[string]string{}
+	for _, field := range embed.Fields {
+		values[field.Name] = field.Value
+	}
+
+	if values["現在"] != "🚀作業時間" {
+		t.Errorf("unexpected phase: %s", values["現在"])
+	}
+	if values["残り"] != "約2分（12時1分まで）" {
+		t.Errorf("unexpected remaining: %s", values["残り"])
+	}
+	if values["サイクル"] != "2/4" {
+		t.Errorf("unexpected cycle: %s", values["サイクル"])
+	}
+	if values["メンバー（2人）"] != "<@a> <@b>" {
+		t.Errorf("unexpected members: %+v", values)
+	}
+	if !strings.Contains(embed.Description, "<t:") {
+		t.Errorf("description has no timestamp: %s", embed.Description)
+	}
+
+	ss.finished = true
+	for _, field := range ss.statusEmbed(now).Fields {
+		if field.Name == "残り" && field.Value != "-" {
+			t.Errorf("finished session has remaining time: %s", field.Value)
+		}
+	}
+}
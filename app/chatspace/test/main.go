@@ -28,6 +28,7 @@ func main() {
 	config := voicevox.InitConfig{
 		NumThreads:    2,
 		LoadAllModels: true,
+		ModelDir:      os.Getenv("VOICEVOX_MODELDIR"),
 	}
 
 	// voicevox application
@@ -42,7 +43,12 @@ func main() {
 
 	// chatspace application
 	discordToken := os.Getenv("CHATSPACE_DISCORD_TOKEN")
-	controller, err := chatspace.NewService(logger, discordToken, vv)
+	chatConfig, err := chatspace.LoadConfig(os.Getenv("CHATSPACE_CONFIG"))
+	if err != nil {
+		logger.Fatal("cannot load chatspace config", zap.Error(err))
+	}
+
+	controller, err := chatspace.NewService(logger, discordToken, vv, chatConfig, "data")
 	if err != nil {
 		logger.Error("cannot start chatspace application", zap.String("discordToken", discordToken[:8]+"***"+discordToken[len(discordToken)-8:]), zap.Error(err))
 	}
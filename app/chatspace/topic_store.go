@@ -0,0 +1,100 @@
+package chatspace
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/streamwest-1629/chatspace/lib/store"
+)
+
+var (
+	errTopicExists   = errors.New("topic already exists")
+	errTopicNotFound = errors.New("topic not found")
+)
+
+// guildTopics are the topics added by command and the recent picks of a guild.
+type guildTopics struct {
+	Topics []breakTopic `json:"topics"`
+	// 古い順に並べた最近選んだ話題の文
+	Recent []string `json:"recent"`
+}
+
+// guildID -> topics
+type topicData map[string]*guildTopics
+
+type topicStore struct {
+	file *store.File[topicData]
+}
+
+func newTopicStore(path string) *topicStore {
+	return &topicStore{
+		file: store.NewFile[topicData](path),
+	}
+}
+
+func (s *topicStore) guild(guildID string) (*guildTopics, error) {
+	data, err := s.file.Load()
+	if err != nil {
+		return nil, err
+	}
+	if topics, exist := data[guildID]; exist {
+		return topics, nil
+	}
+	return &guildTopics{}, nil
+}
+
+func (s *topicStore) update(guildID string, fn func(topics *guildTopics) error) error {
+	return s.file.Update(func(data *topicData) error {
+		if *data == nil {
+			*data = topicData{}
+		}
+		topics, exist := (*data)[guildID]
+		if !exist {
+			topics = &guildTopics{}
+			(*data)[guildID] = topics
+		}
+		return fn(topics)
+	})
+}
+
+// add adds the topic to the pool of the guild.
+func (s *topicStore) add(guildID string, topic breakTopic) error {
+	return s.update(guildID, func(topics *guildTopics) error {
+		for _, added := range topics.Topics {
+			if added.Text == topic.Text {
+				return errTopicExists
+			}
+		}
+		topics.Topics = append(topics.Topics, topic)
+		return nil
+	})
+}
+
+// remove removes the topic added by command from the pool of the guild.
+func (s *topicStore) remove(guildID, text string) error {
+	return s.update(guildID, func(topics *guildTopics) error {
+		for i, added := range topics.Topics {
+			if added.Text == text {
+				topics.Topics = append(topics.Topics[:i], topics.Topics[i+1:]...)
+				return nil
+			}
+		}
+		return errTopicNotFound
+	})
+}
+
+// pick chooses a topic of the guild which was not picked in the last config.TopicHistory breaks.
+func (s *topicStore) pick(guildID string, config GuildConfig) (picked breakTopic, exist bool, err error) {
+	err = s.update(guildID, func(topics *guildTopics) error {
+		picked, exist = pickTopic(config.topicPool(topics.Topics), topics.Recent, config.TopicHistory, rand.Intn)
+		if !exist {
+			return nil
+		}
+		topics.Recent = append(topics.Recent, picked.Text)
+		if over := len(topics.Recent) - config.TopicHistory; over > 0 {
+			topics.Recent = append([]string(nil), topics.Recent[over:]...)
+		}
+		return nil
+	})
+	return picked, exist, err
+}
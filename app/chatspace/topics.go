@@ -0,0 +1,136 @@
+package chatspace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	topicKindTalk     = "talk"
+	topicKindActivity = "activity"
+)
+
+// ファイル中でアクティビティを表す行の接頭辞
+const activityPrefix = "activity:"
+
+// breakTopic is a conversation topic or a short activity suggested at the start of breaks.
+type breakTopic struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+	// コマンドで追加したメンバー (ファイルや組み込みの話題は空)
+	AddedBy string `json:"addedBy,omitempty"`
+}
+
+// 話題のファイルを指定していないときの話題
+var defaultBreakTopics = []breakTopic{
+	{Kind: topicKindTalk, Text: "最近ハマっている食べ物は何ですか？"},
+	{Kind: topicKindTalk, Text: "今週いちばん進んだ作業は何ですか？"},
+	{Kind: topicKindTalk, Text: "最近読んだ本や見た動画でおすすめはありますか？"},
+	{Kind: topicKindTalk, Text: "作業中に聴いている音楽を教えてください。"},
+	{Kind: topicKindTalk, Text: "休日はどう過ごしていますか？"},
+	{Kind: topicKindActivity, Text: "立ち上がって両手を上に伸ばし、10秒キープしましょう。"},
+	{Kind: topicKindActivity, Text: "4秒吸って、4秒止めて、8秒かけて吐く深呼吸を3回しましょう。"},
+	{Kind: topicKindActivity, Text: "首と肩をゆっくり回してほぐしましょう。"},
+	{Kind: topicKindActivity, Text: "画面から目を離して、遠くを20秒眺めましょう。"},
+	{Kind: topicKindActivity, Text: "水を一杯飲みましょう。"},
+}
+
+// parseTopics reads one topic per line. ("activity:" で始まる行は活動、"#" の行は読み飛ばす)
+func parseTopics(r io.Reader) ([]breakTopic, error) {
+	topics := []breakTopic{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		topic := breakTopic{Kind: topicKindTalk, Text: line}
+		if strings.HasPrefix(strings.ToLower(line), activityPrefix) {
+			topic.Kind = topicKindActivity
+			topic.Text = strings.TrimSpace(line[len(activityPrefix):])
+		}
+		if topic.Text != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read topics: %w", err)
+	}
+	return topics, nil
+}
+
+// pickTopic chooses a topic at random, avoiding the last history picks in recent.
+func pickTopic(pool []breakTopic, recent []string, history int, intn func(int) int) (breakTopic, bool) {
+	if len(pool) == 0 {
+		return breakTopic{}, false
+	}
+	if history > len(pool)-1 {
+		history = len(pool) - 1
+	}
+	if history > len(recent) {
+		history = len(recent)
+	}
+
+	avoid := map[string]struct{}{}
+	for _, text := range recent[len(recent)-history:] {
+		avoid[text] = struct{}{}
+	}
+	candidates := []breakTopic{}
+	for _, topic := range pool {
+		if _, exist := avoid[topic.Text]; !exist {
+			candidates = append(candidates, topic)
+		}
+	}
+	// 同じ文の話題が重複していると候補がなくなることがある
+	if len(candidates) == 0 {
+		candidates = pool
+	}
+	return candidates[intn(len(candidates))], true
+}
+
+// spoken returns the words the announcer reads.
+func (topic breakTopic) spoken() string {
+	if topic.Kind == topicKindActivity {
+		return "休憩の始めに、" + topic.Text
+	}
+	return "休憩中の話題です。" + topic.Text
+}
+
+// embedField returns the field added to the break message.
+func (topic breakTopic) embedField() *discordgo.MessageEmbedField {
+	name := "💬休憩中の話題"
+	if topic.Kind == topicKindActivity {
+		name = "🧘ひと休みの運動"
+	}
+	return &discordgo.MessageEmbedField{
+		Name:  name,
+		Value: topic.Text,
+	}
+}
+
+// topicPool returns the topics of the file (or the default ones) followed by the ones added by command.
+func (gc GuildConfig) topicPool(added []breakTopic) []breakTopic {
+	pool := defaultBreakTopics
+	if gc.TopicsFile != "" {
+		pool = gc.topics
+	}
+	return append(append([]breakTopic(nil), pool...), added...)
+}
+
+// pickBreakTopic chooses the topic of the break
+func (ss *ServerStatus) pickBreakTopic() (breakTopic, bool) {
+	if !ss.config.BreakTopics {
+		return breakTopic{}, false
+	}
+	topic, exist, err := ss.stores.topics.pick(ss.guildID, ss.config)
+	if err != nil {
+		ss.logger.Error("cannot pick break topic", zap.Error(err))
+		return breakTopic{}, false
+	}
+	return topic, exist
+}
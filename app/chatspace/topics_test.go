@@ -0,0 +1,113 @@
+package chatspace
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTopics(t *testing.T) {
+
+	topics, err := parseTopics(strings.NewReader(`
+# 休憩中の話題
+最近ハマっていることは？
+
+Activity: 深呼吸を3回しましょう。
+activity:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []breakTopic{
+		{Kind: topicKindTalk, Text: "最近ハマっていることは？"},
+		{Kind: topicKindActivity, Text: "深呼吸を3回しましょう。"},
+	}
+	if len(topics) != len(expected) {
+		t.Fatalf("unexpected topics: %+v", topics)
+	}
+	for i := range expected {
+		if topics[i] != expected[i] {
+			t.Errorf("unexpected topics[%d]: %+v", i, topics[i])
+		}
+	}
+}
+
+func TestPickTopic(t *testing.T) {
+
+	pool := []breakTopic{{Text: "a"}, {Text: "b"}, {Text: "c"}}
+	first := func(int) int { return 0 }
+
+	for _, testcase := range []struct {
+		recent   []string
+		history  int
+		expected string
+	}{
+		{nil, 2, "a"},
+		{[]string{"a"}, 2, "b"},
+		{[]string{"a", "b"}, 2, "c"},
+		// 履歴より前に選んだ話題はまた選べる
+		{[]string{"a", "b", "c"}, 2, "a"},
+		{[]string{"a", "b"}, 1, "a"},
+		// 話題が足りなくても直前の話題以外は選べる
+		{[]string{"b", "c", "a"}, 5, "b"},
+		{[]string{"a"}, 0, "a"},
+	} {
+		picked, exist := pickTopic(pool, testcase.recent, testcase.history, first)
+		if !exist || picked.Text != testcase.expected {
+			t.Errorf("unexpected pick after %v (history %d): %+v", testcase.recent, testcase.history, picked)
+		}
+	}
+
+	if _, exist := pickTopic(nil, nil, 2, first); exist {
+		t.Errorf("picked from empty pool")
+	}
+}
+
+func TestTopicStorePick(t *testing.T) {
+
+	s := newTopicStore(filepath.Join(t.TempDir(), "topics.json"))
+	config := DefaultGuildConfig.clone()
+	config.TopicsFile = "topics.txt"
+	config.topics = []breakTopic{{Kind: topicKindTalk, Text: "a"}}
+	config.TopicHistory = 2
+
+	if err := s.add("guild", breakTopic{Kind: topicKindActivity, Text: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.add("guild", breakTopic{Kind: topicKindActivity, Text: "b"}); err != errTopicExists {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := s.add("guild", breakTopic{Kind: topicKindTalk, Text: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3件のうち直前の2件は選ばれない
+	picked := []string{}
+	for i := 0; i < 6; i++ {
+		topic, exist, err := s.pick("guild", config)
+		if err != nil || !exist {
+			t.Fatalf("cannot pick: %v", err)
+		}
+		for j := len(picked) - 2; j < len(picked); j++ {
+			if j >= 0 && picked[j] == topic.Text {
+				t.Errorf("picked %s again in %v", topic.Text, picked)
+			}
+		}
+		picked = append(picked, topic.Text)
+	}
+
+	topics, err := s.guild("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics.Recent) != 2 {
+		t.Errorf("unexpected recent picks: %v", topics.Recent)
+	}
+
+	if err := s.remove("guild", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.remove("guild", "a"); err != errTopicNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package chatspace
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// phaseWarning is an announcement made the minutes before the end of a phase.
+type phaseWarning struct {
+	at      time.Time
+	minutes int
+}
+
+// phaseWarnings returns the warnings still to come before the phase ends at end, in time order.
+func phaseWarnings(end time.Time, minutes []int, now time.Time) []phaseWarning {
+	warnings := []phaseWarning{}
+	seen := map[int]struct{}{}
+	for _, m := range minutes {
+		if _, exist := seen[m]; exist {
+			continue
+		}
+		seen[m] = struct{}{}
+		at := end.Add(-time.Duration(m) * timeStep)
+		if at.After(now) {
+			warnings = append(warnings, phaseWarning{at: at, minutes: m})
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].at.Before(warnings[j].at)
+	})
+	return warnings
+}
+
+// scheduleWarnings schedules the warnings of the current phase
+func (ss *ServerStatus) scheduleWarnings() {
+	ss.cancelWarnings()
+	if ss.finished || ss.phaseEndAt.IsZero() {
+		return
+	}
+
+	minutes := ss.config.BreakWarnings
+	if ss.mode == serverStatusModeWork {
+		minutes = ss.config.WorkWarnings
+	}
+	for _, warning := range phaseWarnings(ss.phaseEndAt, minutes, time.Now()) {
+		warning, mode := warning, ss.mode
+		ss.warningEvents = append(ss.warningEvents, ss.schedules.Schedule(warning.at, func() {
+			ss.warn(mode, warning.minutes)
+		}))
+	}
+}
+
+// cancelWarnings cancels the warnings not made yet
+func (ss *ServerStatus) cancelWarnings() {
+	for _, event := range ss.warningEvents {
+		event.Cancel()
+	}
+	ss.warningEvents = nil
+}
+
+func (ss *ServerStatus) warn(mode serverStatusMode, minutes int) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.isClosed || ss.mode != mode || ss.isPaused() {
+		return
+	}
+
+	content := fmt.Sprintf("残り%d分です。", minutes)
+	if mode == serverStatusModeChat {
+		content = fmt.Sprintf("もうすぐ作業再開です。残り%d分です。", minutes)
+	}
+	ss.logger.Debug("announce phase warning", zap.Stringer("mode", mode), zap.Int("minutes", minutes))
+
+	// 音声接続がない部屋では文字でお知らせする
+	if ss.voiceConn == nil {
+		if _, err := ss.sess.ChannelMessageSend(ss.channelID, "⏰"+content); err != nil {
+			ss.logger.Error("failed send message", zap.String("channelID", ss.channelID), zap.Error(err))
+		}
+		return
+	}
+	ss.announce(false, content)
+}
+
+// playChime plays the sound file at the transition of the phases
+func (ss *ServerStatus) playChime(path string) {
+	if ss.voiceConn == nil || path == "" {
+		return
+	}
+	ss.voiceConn.PlayFile(path, false)
+}
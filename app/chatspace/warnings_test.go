@@ -0,0 +1,27 @@
+package chatspace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseWarnings(t *testing.T) {
+
+	end := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	now := end.Add(-8 * timeStep)
+
+	warnings := phaseWarnings(end, []int{1, 5, 10, 5}, now)
+	if len(warnings) != 2 {
+		t.Fatalf("unexpected warnings: %+v", warnings)
+	}
+	if warnings[0].minutes != 5 || !warnings[0].at.Equal(end.Add(-5*timeStep)) {
+		t.Errorf("unexpected first warning: %+v", warnings[0])
+	}
+	if warnings[1].minutes != 1 || !warnings[1].at.Equal(end.Add(-timeStep)) {
+		t.Errorf("unexpected second warning: %+v", warnings[1])
+	}
+
+	if warnings := phaseWarnings(end, nil, now); len(warnings) != 0 {
+		t.Errorf("expected no warnings: %+v", warnings)
+	}
+}
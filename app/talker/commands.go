@@ -0,0 +1,552 @@
+package talker
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streamwest-1629/chatspace/app/voicevox"
+	"go.uber.org/zap"
+)
+
+// スラッシュコマンドの定義 (起動時に登録する。ギルドのボイスチャンネルで使うので DM では使えない)
+var applicationCommands = []*discordgo.ApplicationCommand{
+	{
+		Name:         "join",
+		Description:  "今いるボイスチャンネルに参加して読み上げを始めます",
+		DMPermission: new(bool),
+	},
+	{
+		Name:         "leave",
+		Description:  "ボイスチャンネルから退出します",
+		DMPermission: new(bool),
+	},
+	{
+		Name:         "voice",
+		Description:  "読み上げる声を設定します",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "声と話し方を設定します",
+				Options: append([]*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "name",
+						Description:  "設定する声の名前",
+						Autocomplete: true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "member",
+						Description: "声を設定するメンバー (省略時は自分)",
+					},
+				}, prosodyCommandOptions()...),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "使用できる声の一覧を表示します",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "preview",
+				Description: "声を試し聞きします",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "name",
+						Description:  "試し聞きする声の名前",
+						Required:     true,
+						Autocomplete: true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "読み上げる文章",
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:         "skip",
+		Description:  "読み上げ中の文章を飛ばします",
+		DMPermission: new(bool),
+	},
+	{
+		Name:         "stop",
+		Description:  "読み上げを止めて、読み上げ待ちの文章を取り消します",
+		DMPermission: new(bool),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "member",
+				Description: "このメンバーの文章だけ取り消す",
+			},
+		},
+	},
+	{
+		Name:         "help",
+		Description:  "使い方を表示します",
+		DMPermission: new(bool),
+	},
+}
+
+var setVoicePattern = regexp.MustCompile(`\s+([^\s]+?)\s*$`)
+
+func prosodyCommandOptions() []*discordgo.ApplicationCommandOption {
+	options := []*discordgo.ApplicationCommandOption{}
+	for _, option := range prosodyOptions {
+		min := option.min
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionNumber,
+			Name:        option.name(),
+			Description: fmt.Sprintf("%s (%g ～ %g)", option.label, option.min, option.max),
+			MinValue:    &min,
+			MaxValue:    option.max,
+		})
+	}
+	return options
+}
+
+type commandContext struct {
+	guildID   string
+	channelID string
+	authorID  string
+	reply     replyFunc
+}
+
+// イベントループが保持する状態 (イベントループのゴルーチンからのみ触る)
+type serviceState struct {
+	logger         *zap.Logger
+	baseLogger     *zap.Logger
+	sess           *discordgo.Session
+	voicevoxApp    *voicevox.VoiceVox
+	voices         *voiceStore
+	memberJoinVCs  map[string]map[string]string
+	serverStatuses map[string]*joinedServerStatus
+}
+
+func (st *serviceState) currentChannelID(guildID, memberID string) string {
+	if guildMemberJoinVCs, joined := st.memberJoinVCs[guildID]; joined {
+		return guildMemberJoinVCs[memberID]
+	}
+	return ""
+}
+
+// join returns the server status for the author's voice channel, starting it if needed.
+// It replies and returns nil if the bot cannot read the channel.
+func (st *serviceState) join(ctx commandContext) *joinedServerStatus {
+
+	currentChannelId := st.currentChannelID(ctx.guildID, ctx.authorID)
+	if currentChannelId == "" {
+		ctx.reply(strings.Join([]string{"😑", "ボイスチャンネルに入室しているときのみ利用可能です"}, " "), nil)
+		return nil
+	}
+
+	if serverStatus, exist := st.serverStatuses[ctx.guildID]; exist {
+		if serverStatus.voiceConn.ChannelID != currentChannelId {
+			ctx.reply(strings.Join([]string{"💔", "他のボイスチャンネルにいるので動けません"}, " "), nil)
+			return nil
+		}
+		return serverStatus
+	}
+
+	serverStatus, err := newJoinedServerStatus(st.baseLogger, st.sess, st.voicevoxApp, st.voices, ctx.guildID, ctx.channelID, currentChannelId)
+	if err != nil {
+		st.logger.Error("failed start server", zap.Error(err))
+		ctx.reply(strings.Join([]string{"🤯", "ボイスチャットに入ることができませんでした．"}, " "), nil)
+		return nil
+	}
+	st.serverStatuses[ctx.guildID] = serverStatus
+	return serverStatus
+}
+
+func (st *serviceState) leave(ctx commandContext) {
+	serverStatus, exist := st.serverStatuses[ctx.guildID]
+	if !exist {
+		ctx.reply(strings.Join([]string{"🤔", "ボイスチャンネルに参加していません"}, " "), nil)
+		return
+	}
+
+	if err := serverStatus.Leave(ctx.reply); err != nil {
+		st.logger.Error("failed close voice connection", zap.Error(err))
+	}
+	delete(st.serverStatuses, ctx.guildID)
+}
+
+func (st *serviceState) help(ctx commandContext) {
+	ctx.reply(
+		strings.Join([]string{"😶", "ヘルプ"}, " "),
+		&discordgo.MessageEmbed{
+			Description: strings.Join([]string{
+				"`/join`: ボイスチャンネルに参加",
+				"`/leave`: Bot退出",
+				"`/voice list`: 使用できるボイスの一覧を表示",
+				"`/voice set`: ボイスと話速・音高・抑揚・音量を設定",
+				"`/voice preview`: ボイスを試し聞き",
+				"`/skip`: 読み上げ中の文章を飛ばす",
+				"`/stop`: 読み上げを止めて読み上げ待ちの文章を取り消す (メンバーを指定するとその人の文章だけ)",
+				"`/help`: ヘルプ表示",
+				"",
+				"<このボットへのメンション> <コマンド> （その他）でも操作できます",
+				"`--list-voice`: 使用できるボイスの一覧を表示",
+				"`--set-voice`: ボイスを設定",
+				"```",
+				"--set-voice <ボイスを設定するメンバーへのメンション>(...) <設定するボイスの名前>",
+				"```",
+				"`--set-speed`, `--set-pitch`, `--set-intonation`, `--set-volume`: 話速・音高・抑揚・音量を設定",
+				"```",
+				"--set-speed 1.3 --set-pitch -0.05 (<調整するメンバーへのメンション>(...))",
+				"```",
+				"`--skip`, `--stop`: 読み上げを飛ばす・止める",
+				"`--leave`: Bot退出",
+				"`--help`: ヘルプ表示",
+			}, "\n"),
+		},
+	)
+}
+
+func (st *serviceState) listVoices(ctx commandContext) {
+	speakers, err := st.voicevoxApp.GetSpeakers("", true)
+	if err != nil {
+		st.logger.Error("failed get voicevox speakers", zap.Error(err))
+		ctx.reply(strings.Join([]string{"🤯", "声の一覧を取得できませんでした"}, " "), nil)
+		return
+	}
+
+	speakerNames := []string{}
+	for _, speaker := range speakers {
+		speakerNames = append(speakerNames, fmt.Sprintf("- %s", speaker.Name))
+	}
+
+	ctx.reply(
+		strings.Join([]string{"🥳", "担当可能な声の一覧"}, " "),
+		&discordgo.MessageEmbed{
+			Description: strings.Join(speakerNames, "\n"),
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: "ボイスを設定するときは: /voice set",
+			},
+		},
+	)
+}
+
+// searchSpeaker replies and returns false if no speaker matches searchName.
+func (st *serviceState) searchSpeaker(ctx commandContext, searchName string) (voicevox.VoiceSpeaker, bool) {
+	speakers, err := st.voicevoxApp.GetSpeakers(searchName, false)
+	if err != nil {
+		st.logger.Error("failed get voicevox speakers", zap.Error(err))
+		ctx.reply(strings.Join([]string{"🤯", "声の一覧を取得できませんでした"}, " "), nil)
+		return voicevox.VoiceSpeaker{}, false
+	}
+
+	if len(speakers) == 0 {
+		ctx.reply(strings.Join([]string{"🤯", "当てはまる声がみつかりませんでした「" + searchName + "」"}, " "), nil)
+		return voicevox.VoiceSpeaker{}, false
+	}
+
+	// 完全一致する名前があればそれを優先する
+	for _, speaker := range speakers {
+		if speaker.Name == searchName {
+			return speaker, true
+		}
+	}
+	return speakers[0], true
+}
+
+func (st *serviceState) setVoice(ctx commandContext, memberIds []string, searchName string) {
+	serverStatus := st.join(ctx)
+	if serverStatus == nil {
+		return
+	}
+
+	speaker, found := st.searchSpeaker(ctx, searchName)
+	if !found {
+		return
+	}
+
+	for _, memberId := range memberIds {
+		serverStatus.SetVoiceSpeaker(ctx.reply, memberId, speaker)
+	}
+}
+
+func (st *serviceState) tuneVoice(ctx commandContext, memberIds []string, apply func(*voicevox.Prosody)) {
+	serverStatus := st.join(ctx)
+	if serverStatus == nil {
+		return
+	}
+
+	for _, memberId := range memberIds {
+		serverStatus.SetProsody(ctx.reply, memberId, apply)
+	}
+}
+
+func (st *serviceState) previewVoice(ctx commandContext, searchName, text string) {
+	serverStatus := st.join(ctx)
+	if serverStatus == nil {
+		return
+	}
+
+	speaker, found := st.searchSpeaker(ctx, searchName)
+	if !found {
+		return
+	}
+
+	serverStatus.Preview(ctx.reply, ctx.authorID, speaker, text)
+}
+
+func (st *serviceState) skip(ctx commandContext) {
+	serverStatus, exist := st.serverStatuses[ctx.guildID]
+	if !exist {
+		ctx.reply(strings.Join([]string{"🤔", "ボイスチャンネルに参加していません"}, " "), nil)
+		return
+	}
+	serverStatus.Skip(ctx.reply)
+}
+
+func (st *serviceState) stop(ctx commandContext, memberId string) {
+	serverStatus, exist := st.serverStatuses[ctx.guildID]
+	if !exist {
+		ctx.reply(strings.Join([]string{"🤔", "ボイスチャンネルに参加していません"}, " "), nil)
+		return
+	}
+	serverStatus.Stop(ctx.reply, memberId)
+}
+
+func (st *serviceState) onInteraction(event *discordgo.InteractionCreate) {
+	// DM からのコマンドにはメンバー情報がない
+	if event.Member == nil {
+		return
+	}
+
+	switch event.Type {
+	case discordgo.InteractionApplicationCommand:
+		// 声の生成やボイスチャンネルへの参加は3秒以上かかることがあるので先に応答しておく
+		if err := st.sess.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		}); err != nil {
+			st.logger.Error("failed respond interaction", zap.Error(err))
+			return
+		}
+
+		ctx := commandContext{
+			guildID:   event.GuildID,
+			channelID: event.ChannelID,
+			authorID:  event.Member.User.ID,
+			reply: func(mainContent string, embed *discordgo.MessageEmbed) {
+				SendFollowup(st.sess, st.logger, event.Interaction, mainContent, embed)
+			},
+		}
+
+		data := event.ApplicationCommandData()
+		switch data.Name {
+		case "join":
+			if serverStatus := st.join(ctx); serverStatus != nil {
+				ctx.reply(strings.Join([]string{"🥰", "読み上げを始めます"}, " "), nil)
+			}
+		case "leave":
+			st.leave(ctx)
+		case "help":
+			st.help(ctx)
+		case "skip":
+			st.skip(ctx)
+		case "stop":
+			memberId := ""
+			for _, option := range data.Options {
+				if option.Name == "member" {
+					memberId = option.UserValue(nil).ID
+				}
+			}
+			st.stop(ctx, memberId)
+		case "voice":
+			if len(data.Options) == 0 {
+				break
+			}
+			subCommand := data.Options[0]
+			options := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+			for _, option := range subCommand.Options {
+				options[option.Name] = option
+			}
+
+			switch subCommand.Name {
+			case "list":
+				st.listVoices(ctx)
+			case "preview":
+				text := ""
+				if option, exist := options["text"]; exist {
+					text = option.StringValue()
+				}
+				st.previewVoice(ctx, options["name"].StringValue(), text)
+			case "set":
+				memberIds := []string{ctx.authorID}
+				if option, exist := options["member"]; exist {
+					memberIds = []string{option.UserValue(nil).ID}
+				}
+
+				prosodySet := false
+				for _, prosodyOption := range prosodyOptions {
+					if _, exist := options[prosodyOption.name()]; exist {
+						prosodySet = true
+					}
+				}
+
+				name, nameSet := options["name"]
+				if !nameSet && !prosodySet {
+					ctx.reply(strings.Join([]string{"🤔", "設定する声の名前か話し方を指定してください"}, " "), nil)
+					break
+				}
+				if nameSet {
+					st.setVoice(ctx, memberIds, name.StringValue())
+				}
+				if prosodySet {
+					st.tuneVoice(ctx, memberIds, func(prosody *voicevox.Prosody) {
+						for _, prosodyOption := range prosodyOptions {
+							if option, exist := options[prosodyOption.name()]; exist {
+								prosodyOption.set(prosody, voicevox.ProsodyValue(option.FloatValue()))
+							}
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+// onAutocomplete answers from the cached speakers. It runs outside the event loop.
+func onAutocomplete(sess *discordgo.Session, logger *zap.Logger, speakers *speakerCache, event *discordgo.InteractionCreate) {
+	data := event.ApplicationCommandData()
+
+	// サブコマンドの中から入力中の項目を探す
+	searchName := ""
+	for _, subCommand := range data.Options {
+		for _, option := range subCommand.Options {
+			if option.Focused {
+				searchName = option.StringValue()
+			}
+		}
+	}
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, speaker := range speakers.search(searchName) {
+		// Discordの上限は25件
+		if len(choices) >= 25 {
+			break
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  speaker.Name,
+			Value: speaker.Name,
+		})
+	}
+
+	if err := sess.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	}); err != nil {
+		logger.Error("failed respond autocomplete", zap.Error(err))
+	}
+}
+
+// onMentioned handles the "<mention> --command" syntax.
+func (st *serviceState) onMentioned(event *discordgo.MessageCreate) {
+
+	ctx := commandContext{
+		guildID:   event.GuildID,
+		channelID: event.ChannelID,
+		authorID:  event.Author.ID,
+		reply: func(mainContent string, embed *discordgo.MessageEmbed) {
+			SendMessage(st.sess, st.logger, event.ID, event.ChannelID, mainContent, embed)
+		},
+	}
+
+	content := event.Content
+	for _, mention := range event.Mentions {
+		content = strings.ReplaceAll(content, mention.Mention(), " ")
+	}
+	for _, mention := range event.MentionChannels {
+		content = strings.ReplaceAll(content, mention.Mention(), " ")
+	}
+	for _, mention := range event.MentionRoles {
+		content = strings.ReplaceAll(content, mention, " ")
+	}
+
+	mentionedIds := []string{}
+	for _, mention := range event.Mentions {
+		if !mention.Bot {
+			mentionedIds = append(mentionedIds, mention.ID)
+		}
+	}
+
+	switch {
+	case strings.Contains(content, "--help"):
+		st.help(ctx)
+	case strings.Contains(content, "--leave"):
+		st.leave(ctx)
+	case strings.Contains(content, "--skip"):
+		st.skip(ctx)
+	case strings.Contains(content, "--stop"):
+		// メンションがあればその人の文章だけ取り消す
+		if len(mentionedIds) == 0 {
+			st.stop(ctx, "")
+		}
+		for _, memberId := range mentionedIds {
+			st.stop(ctx, memberId)
+		}
+	case strings.Contains(content, "--list-voice"):
+		st.listVoices(ctx)
+
+	case strings.Contains(content, "--set-voice"):
+		if len(mentionedIds) == 0 {
+			example := "ずんだもん"
+			if speakers, err := st.voicevoxApp.GetSpeakers("", false); err == nil && len(speakers) > 0 {
+				example = speakers[rand.Intn(len(speakers))].Name
+			}
+
+			ctx.reply(
+				strings.Join([]string{"🤔", "声を設定するメンバーを指定してください"}, " "),
+				&discordgo.MessageEmbed{
+					Description: strings.Join([]string{
+						"声を設定するには，<Botへのメンション> --set-voice <声を設定するメンバーへのメンション>(...) <設定する声の名前>を指定していください",
+						strings.Join([]string{"例:", "--set-voice", event.Author.Mention(), example}, " "),
+					}, "\n"),
+				},
+			)
+			break
+		}
+
+		searchName := func() string {
+			if matched := setVoicePattern.FindStringSubmatch(content); len(matched) == 2 {
+				return matched[1]
+			} else {
+				return "**Unknown**"
+			}
+		}()
+
+		st.setVoice(ctx, mentionedIds, searchName)
+
+	case hasProsodyFlag(content):
+		// 失敗した場合は設定を変えない
+		if err := parseProsodyFlags(content, &voicevox.Prosody{}); err != nil {
+			ctx.reply(strings.Join([]string{"🤔", err.Error()}, " "), nil)
+			break
+		}
+
+		// メンションがなければ発言者自身の声を調整する
+		if len(mentionedIds) == 0 {
+			mentionedIds = append(mentionedIds, event.Author.ID)
+		}
+
+		st.tuneVoice(ctx, mentionedIds, func(prosody *voicevox.Prosody) {
+			parseProsodyFlags(content, prosody)
+		})
+
+	default:
+		// コマンドがなければ参加するだけ
+		st.join(ctx)
+	}
+}
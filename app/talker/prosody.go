@@ -0,0 +1,88 @@
+package talker
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/streamwest-1629/chatspace/app/voicevox"
+)
+
+type prosodyOption struct {
+	flag     string
+	label    string
+	min, max float64
+	// 未設定の場合は nil
+	get func(p voicevox.Prosody) *float64
+	set func(p *voicevox.Prosody, value *float64)
+}
+
+// メンバーごとに調整できる声のパラメータ
+var prosodyOptions = []prosodyOption{
+	{
+		flag: "--set-speed", label: "話速", min: 0.5, max: 2.0,
+		get: func(p voicevox.Prosody) *float64 { return p.SpeedScale },
+		set: func(p *voicevox.Prosody, value *float64) { p.SpeedScale = value },
+	},
+	{
+		flag: "--set-pitch", label: "音高", min: -0.15, max: 0.15,
+		get: func(p voicevox.Prosody) *float64 { return p.PitchScale },
+		set: func(p *voicevox.Prosody, value *float64) { p.PitchScale = value },
+	},
+	{
+		flag: "--set-intonation", label: "抑揚", min: 0, max: 2.0,
+		get: func(p voicevox.Prosody) *float64 { return p.IntonationScale },
+		set: func(p *voicevox.Prosody, value *float64) { p.IntonationScale = value },
+	},
+	{
+		flag: "--set-volume", label: "音量", min: 0, max: 2.0,
+		get: func(p voicevox.Prosody) *float64 { return p.VolumeScale },
+		set: func(p *voicevox.Prosody, value *float64) { p.VolumeScale = value },
+	},
+}
+
+// name is the option name used in the slash command.
+func (o prosodyOption) name() string {
+	return strings.TrimPrefix(o.flag, "--set-")
+}
+
+func hasProsodyFlag(content string) bool {
+	for _, option := range prosodyOptions {
+		if strings.Contains(content, option.flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProsodyFlags applies every "--set-xxx <value>" in content to prosody.
+func parseProsodyFlags(content string, prosody *voicevox.Prosody) error {
+	for _, option := range prosodyOptions {
+		matched := regexp.MustCompile(regexp.QuoteMeta(option.flag) + `(?:\s+(\S+))?`).FindStringSubmatch(content)
+		if matched == nil {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(matched[1], 64)
+		if err != nil {
+			return fmt.Errorf("%s には数値を指定してください", option.flag)
+		} else if value < option.min || option.max < value {
+			return fmt.Errorf("%s は %g から %g の範囲で指定してください", option.flag, option.min, option.max)
+		}
+		option.set(prosody, &value)
+	}
+	return nil
+}
+
+func describeProsody(prosody voicevox.Prosody) string {
+	lines := []string{}
+	for _, option := range prosodyOptions {
+		if value := option.get(prosody); value != nil {
+			lines = append(lines, fmt.Sprintf("%s: %g", option.label, *value))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: 標準", option.label))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
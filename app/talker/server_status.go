@@ -1,6 +1,7 @@
 package talker
 
 import (
+	"fmt"
 	"math/rand"
 	"strings"
 	"sync"
@@ -13,73 +14,116 @@ import (
 )
 
 type joinedServerStatus struct {
-	lock           sync.Mutex
-	logger         *zap.Logger
-	sess           *discordgo.Session
-	voiceConn      *voicevox.ManagedDiscordVoiceConnection
-	guildID        string
-	memberIds      map[string]struct{}
-	memberSpeakers map[string]voicevox.VoiceSpeaker
-	prevChannelID  string
+	lock          sync.Mutex
+	logger        *zap.Logger
+	sess          *discordgo.Session
+	voiceConn     *voicevox.ManagedDiscordVoiceConnection
+	voices        *voiceStore
+	guildID       string
+	memberIds     map[string]struct{}
+	memberVoices  map[string]memberVoice
+	prevChannelID string
 }
 
-func newJoinedServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, event *discordgo.MessageCreate, voiceChannelId string) (*joinedServerStatus, error) {
+type memberVoice struct {
+	Speaker voicevox.VoiceSpeaker
+	Prosody voicevox.Prosody
+}
+
+// 返信先 (メンションへの返信 / スラッシュコマンドへの応答) の違いを吸収する
+type replyFunc func(mainContent string, embed *discordgo.MessageEmbed)
+
+func newJoinedServerStatus(baseLogger *zap.Logger, sess *discordgo.Session, voicevoxApp *voicevox.VoiceVox, voices *voiceStore, guildID, textChannelId, voiceChannelId string) (*joinedServerStatus, error) {
 
 	vc, err := voicevox.StartManagedDiscordVoiceConnection(
 		baseLogger.With(zap.String("feature", "voicevoxRequest")),
-		sess, event.GuildID, voiceChannelId, voicevoxApp,
+		sess, guildID, voiceChannelId, voicevoxApp,
 		util.ReplaceMsgFunc(sess),
 	)
 
 	if err != nil {
-		SendMessage(
-			sess, baseLogger, event.ID, event.ChannelID,
-			strings.Join([]string{"🤯", "ボイスチャットに入ることができませんでした．"}, " "),
-			nil,
-		)
 		return nil, err
 	}
 
 	baseLogger = baseLogger.With(
 		zap.Time("launchAt", time.Now().UTC()),
-		zap.String("guildID", event.GuildID),
+		zap.String("guildID", guildID),
 	)
 
 	ss := &joinedServerStatus{
-		logger:         baseLogger.With(zap.String("feature", "serverStatus")),
-		sess:           sess,
-		voiceConn:      vc,
-		guildID:        event.GuildID,
-		prevChannelID:  event.ChannelID,
-		memberIds:      make(map[string]struct{}),
-		memberSpeakers: make(map[string]voicevox.VoiceSpeaker),
+		logger:        baseLogger.With(zap.String("feature", "serverStatus")),
+		sess:          sess,
+		voiceConn:     vc,
+		voices:        voices,
+		guildID:       guildID,
+		prevChannelID: textChannelId,
+		memberIds:     make(map[string]struct{}),
+		memberVoices:  make(map[string]memberVoice),
 	}
+	ss.loadVoices()
 
 	return ss, nil
 }
 
-func (ss *joinedServerStatus) SetVoiceSpeaker(event *discordgo.MessageCreate, memberId string, speaker voicevox.VoiceSpeaker) {
+// 以前に設定された声を復元する
+func (ss *joinedServerStatus) loadVoices() {
+	records, err := ss.voices.load(ss.guildID)
+	if err != nil {
+		ss.logger.Error("cannot load saved voices", zap.Error(err))
+		return
+	} else if len(records) == 0 {
+		return
+	}
+
+	speakers, err := ss.voiceConn.GetSpeakers("", true)
+	if err != nil {
+		ss.logger.Error("cannot get speakers status", zap.Error(err))
+		return
+	}
+	speakerNameMap := map[string]voicevox.VoiceSpeaker{}
+	for _, speaker := range speakers {
+		speakerNameMap[speaker.Name] = speaker
+	}
+
+	for memberId, record := range records {
+		speaker, exist := speakerNameMap[record.SpeakerName]
+		if !exist {
+			ss.logger.Warn("saved speaker is not available", zap.String("userID", memberId), zap.String("speakerName", record.SpeakerName))
+			continue
+		}
+		ss.memberVoices[memberId] = memberVoice{
+			Speaker: speaker,
+			Prosody: record.Prosody,
+		}
+	}
+	ss.logger.Info("loaded saved voices", zap.Int("count", len(ss.memberVoices)))
+}
+
+func (ss *joinedServerStatus) saveVoice(memberId string, voice memberVoice) {
+	if err := ss.voices.save(ss.guildID, memberId, voice); err != nil {
+		ss.logger.Error("cannot save voice", zap.String("userID", memberId), zap.Error(err))
+	}
+}
+
+func (ss *joinedServerStatus) SetVoiceSpeaker(reply replyFunc, memberId string, speaker voicevox.VoiceSpeaker) {
 	ss.lock.Lock()
 	defer ss.lock.Unlock()
 
-	ss.memberSpeakers[memberId] = speaker
+	voice := ss.memberVoices[memberId]
+	voice.Speaker = speaker
+	ss.memberVoices[memberId] = voice
+	ss.saveVoice(memberId, voice)
 
-	member, err := ss.sess.GuildMember(ss.guildID, memberId)
+	memberName, err := ss.memberName(memberId)
 	if err != nil {
 		ss.logger.Error("failed get discord server member status", zap.Error(err))
 		return
 	}
 
-	memberName := member.Nick
-	if memberName == "" {
-		memberName = member.User.Username
-	}
-
 	expression := voicevox.CharacterExpression(speaker.Character)
 	intro := strings.Join([]string{memberName, expression.Hello()}, "、")
 
-	SendMessage(
-		ss.sess, ss.logger, event.ID, event.ChannelID,
+	reply(
 		strings.Join([]string{"💕", intro}, " "),
 		&discordgo.MessageEmbed{
 			Description: "あなたの声はこれから「" + speaker.Name + "」が担当させていただきます。",
@@ -87,36 +131,159 @@ func (ss *joinedServerStatus) SetVoiceSpeaker(event *discordgo.MessageCreate, me
 	)
 }
 
-func (ss *joinedServerStatus) Speak(event *discordgo.MessageCreate) {
+func (ss *joinedServerStatus) SetProsody(reply replyFunc, memberId string, apply func(*voicevox.Prosody)) {
 	ss.lock.Lock()
 	defer ss.lock.Unlock()
 
-	speaker, exist := ss.memberSpeakers[event.Author.ID]
-	if !exist {
-		speakers, err := ss.voiceConn.GetSpeakers("", true)
-		if err != nil {
-			ss.logger.Error("cannot get speakers status", zap.Error(err))
-			return
-		}
+	voice, err := ss.memberVoice(memberId)
+	if err != nil {
+		ss.logger.Error("cannot get speakers status", zap.Error(err))
+		return
+	}
+	apply(&voice.Prosody)
+	ss.memberVoices[memberId] = voice
+	ss.saveVoice(memberId, voice)
+
+	memberName, err := ss.memberName(memberId)
+	if err != nil {
+		ss.logger.Error("failed get discord server member status", zap.Error(err))
+		return
+	}
+
+	lines := []string{
+		"声: " + voice.Speaker.Name,
+		describeProsody(voice.Prosody),
+	}
+	title := memberName + "さんの声を調整しました"
+	if !ss.voiceConn.SupportsProsody() {
+		// 設定は保存しておき、対応した音声合成エンジンに替えたら反映する
+		title = memberName + "さんの声の調整を保存しました"
+		lines = append(lines, "⚠️ いまの音声合成エンジンでは話速・音高などの調整は読み上げに反映されません。")
+	}
+	reply(
+		strings.Join([]string{"🎛️", title}, " "),
+		&discordgo.MessageEmbed{Description: strings.Join(lines, "\n")},
+	)
+}
+
+func (ss *joinedServerStatus) Speak(event *discordgo.MessageCreate) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
 
-		speaker = speakers[rand.Intn(len(speakers))]
-		ss.memberSpeakers[event.Author.ID] = speaker
+	voice, err := ss.memberVoice(event.Author.ID)
+	if err != nil {
+		ss.logger.Error("cannot get speakers status", zap.Error(err))
+		return
 	}
 
 	contents := util.WordSpliter(event.ContentWithMentionsReplaced())
 	for _, content := range contents {
-		ss.voiceConn.Speak(speaker.Id, false, content)
+		ss.voiceConn.SpeakUtterance(voicevox.Utterance{
+			SpeakerID: voice.Speaker.Id,
+			Prosody:   voice.Prosody,
+			Content:   content,
+			Owner:     event.Author.ID,
+		}, false)
 	}
 	ss.prevChannelID = event.ChannelID
 }
 
-func (ss *joinedServerStatus) Close() error {
+func (ss *joinedServerStatus) memberName(memberId string) (string, error) {
+	member, err := ss.sess.GuildMember(ss.guildID, memberId)
+	if err != nil {
+		return "", err
+	}
+
+	if member.Nick != "" {
+		return member.Nick, nil
+	}
+	return member.User.Username, nil
+}
+
+// 声が未設定のメンバーにはランダムに割り当てる (ss.lock を取得した状態で呼ぶ)
+func (ss *joinedServerStatus) memberVoice(memberId string) (memberVoice, error) {
+	voice, exist := ss.memberVoices[memberId]
+	if exist {
+		return voice, nil
+	}
+
+	speakers, err := ss.voiceConn.GetSpeakers("", true)
+	if err != nil {
+		return memberVoice{}, err
+	}
+
+	voice.Speaker = speakers[rand.Intn(len(speakers))]
+	ss.memberVoices[memberId] = voice
+	ss.saveVoice(memberId, voice)
+	return voice, nil
+}
+
+// Preview speaks text with the speaker without changing anyone's voice.
+func (ss *joinedServerStatus) Preview(reply replyFunc, memberId string, speaker voicevox.VoiceSpeaker, text string) {
 	ss.lock.Lock()
 	defer ss.lock.Unlock()
-	SendMessage(ss.sess, ss.logger, "", ss.prevChannelID, "🤗 退出します．", nil)
+
+	if text == "" {
+		text = voicevox.CharacterExpression(speaker.Character).Hello()
+	}
+
+	reply(strings.Join([]string{"🔊", "「" + speaker.Name + "」の声を再生します"}, " "), nil)
+	for _, content := range util.WordSpliter(text) {
+		ss.voiceConn.SpeakUtterance(voicevox.Utterance{
+			SpeakerID: speaker.Id,
+			Content:   content,
+			Owner:     memberId,
+		}, false)
+	}
+}
+
+// Skip stops the sentence read now and goes on to the next one.
+func (ss *joinedServerStatus) Skip(reply replyFunc) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if !ss.voiceConn.Skip() {
+		reply(strings.Join([]string{"🤔", "読み上げている文章はありません"}, " "), nil)
+		return
+	}
+	reply(strings.Join([]string{"⏭️", "読み上げ中の文章を飛ばしました"}, " "), nil)
+}
+
+// Stop stops reading and drops the queued sentences. Only the sentences of memberId are dropped if it is set.
+func (ss *joinedServerStatus) Stop(reply replyFunc, memberId string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if memberId == "" {
+		count := ss.voiceConn.Clear()
+		ss.logger.Info("cleared reading", zap.Int("count", count))
+		reply(strings.Join([]string{"⏹️", "読み上げを止めました"}, " "), &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("%d件の文章を取り消しました。", count),
+		})
+		return
+	}
+
+	count := ss.voiceConn.ClearUser(memberId)
+	ss.logger.Info("cleared reading of member", zap.String("userID", memberId), zap.Int("count", count))
+	reply(strings.Join([]string{"⏹️", "読み上げを止めました"}, " "), &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("<@%s> さんの%d件の文章を取り消しました。", memberId, count),
+	})
+}
+
+// Leave replies to the command and closes the voice connection.
+func (ss *joinedServerStatus) Leave(reply replyFunc) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	reply("🤗 退出します．", nil)
 	return ss.voiceConn.Close()
 }
 
+func (ss *joinedServerStatus) Close() error {
+	return ss.Leave(func(mainContent string, embed *discordgo.MessageEmbed) {
+		SendMessage(ss.sess, ss.logger, "", ss.prevChannelID, mainContent, embed)
+	})
+}
+
 func SendMessage(sess *discordgo.Session, logger *zap.Logger, replyMessageID, channelID, mainContent string, embed *discordgo.MessageEmbed) {
 	if replyMessageID == "" {
 		if embed == nil {
@@ -124,15 +291,7 @@ func SendMessage(sess *discordgo.Session, logger *zap.Logger, replyMessageID, ch
 				logger.Error("failed send message", zap.Error(err), zap.String("channelID", channelID))
 			}
 		} else {
-			if embed.Title == "" {
-				embed.Title = mainContent
-			} else if embed.Description == "" {
-				embed.Description = mainContent
-			} else if embed.Footer == nil {
-				embed.Footer = &discordgo.MessageEmbedFooter{
-					Text: mainContent,
-				}
-			}
+			fillEmbed(embed, mainContent)
 
 			if _, err := sess.ChannelMessageSendEmbed(channelID, embed); err != nil {
 				logger.Error("failed send message", zap.Error(err), zap.String("channelID", channelID))
@@ -146,15 +305,7 @@ func SendMessage(sess *discordgo.Session, logger *zap.Logger, replyMessageID, ch
 				logger.Error("failed send message", zap.Error(err), zap.String("channelID", channelID), zap.String("replyMsgID", replyMessageID))
 			}
 		} else {
-			if embed.Title == "" {
-				embed.Title = mainContent
-			} else if embed.Description == "" {
-				embed.Description = mainContent
-			} else if embed.Footer == nil {
-				embed.Footer = &discordgo.MessageEmbedFooter{
-					Text: mainContent,
-				}
-			}
+			fillEmbed(embed, mainContent)
 
 			if _, err := sess.ChannelMessageSendEmbedReply(channelID, embed, &replyReference); err != nil {
 				logger.Error("failed send message", zap.Error(err), zap.String("channelID", channelID), zap.String("replyMsgID", replyMessageID))
@@ -162,3 +313,31 @@ func SendMessage(sess *discordgo.Session, logger *zap.Logger, replyMessageID, ch
 		}
 	}
 }
+
+// SendFollowup answers a deferred interaction in the same form as SendMessage.
+func SendFollowup(sess *discordgo.Session, logger *zap.Logger, interaction *discordgo.Interaction, mainContent string, embed *discordgo.MessageEmbed) {
+	params := &discordgo.WebhookParams{}
+	if embed == nil {
+		params.Content = mainContent
+	} else {
+		fillEmbed(embed, mainContent)
+		params.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+
+	if _, err := sess.FollowupMessageCreate(interaction, false, params); err != nil {
+		logger.Error("failed send followup message", zap.Error(err), zap.String("channelID", interaction.ChannelID))
+	}
+}
+
+// 埋め込みの空いている欄に本文を入れる
+func fillEmbed(embed *discordgo.MessageEmbed, mainContent string) {
+	if embed.Title == "" {
+		embed.Title = mainContent
+	} else if embed.Description == "" {
+		embed.Description = mainContent
+	} else if embed.Footer == nil {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: mainContent,
+		}
+	}
+}
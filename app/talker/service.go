@@ -2,9 +2,7 @@ package talker
 
 import (
 	"fmt"
-	"math/rand"
-	"regexp"
-	"strings"
+	"path/filepath"
 	"sync"
 
 	"github.com/bwmarrin/discordgo"
@@ -19,7 +17,7 @@ type ServiceController struct {
 	app     *discordgo.Application
 }
 
-func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicevox.VoiceVox) (*ServiceController, error) {
+func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicevox.VoiceVox, dataDir string) (*ServiceController, error) {
 
 	baseLogger = baseLogger.With(zap.String("package", "talker"))
 
@@ -35,7 +33,11 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 		return nil, fmt.Errorf("failed get application status: %w", err)
 	}
 
+	voices := newVoiceStore(filepath.Join(dataDir, "talker_voices.json"))
+	speakers := newSpeakerCache(baseLogger.With(zap.String("feature", "autocomplete")), voicevoxApp)
+
 	messageCreateListener := make(chan discordgo.MessageCreate)
+	interactionCreateListener := make(chan discordgo.InteractionCreate)
 	voiceStateUpdateListener := make(chan discordgo.VoiceStateUpdate)
 	quit := make(chan *sync.WaitGroup)
 
@@ -45,6 +47,17 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 			messageCreateListener <- *arg
 		}
 	})
+	sess.AddHandler(func(_ *discordgo.Session, arg *discordgo.InteractionCreate) {
+		if arg.GuildID == "" || arg.Member == nil {
+			return
+		}
+		// 補完は3秒以内に応答する必要があるのでイベントループを通さない
+		if arg.Type == discordgo.InteractionApplicationCommandAutocomplete {
+			onAutocomplete(sess, baseLogger, speakers, arg)
+			return
+		}
+		interactionCreateListener <- *arg
+	})
 	sess.AddHandler(func(_ *discordgo.Session, arg *discordgo.VoiceStateUpdate) {
 		if arg.Member.User.ID != app.ID {
 			voiceStateUpdateListener <- *arg
@@ -60,8 +73,17 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 
 	go func() {
 		logger := baseLogger.With(zap.String("feature", "eventListener"))
-		memberJoinVCs := map[string]map[string]string{}
-		serverStatuses := map[string]*joinedServerStatus{}
+		st := &serviceState{
+			logger:         logger,
+			baseLogger:     baseLogger,
+			sess:           sess,
+			voicevoxApp:    voicevoxApp,
+			voices:         voices,
+			memberJoinVCs:  map[string]map[string]string{},
+			serverStatuses: map[string]*joinedServerStatus{},
+		}
+		memberJoinVCs := st.memberJoinVCs
+		serverStatuses := st.serverStatuses
 
 		for {
 			select {
@@ -70,155 +92,11 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 				return
 			case event := <-messageCreateListener:
 
-				currentChannelId := ""
-				guildMemberJoinVCs, joined := memberJoinVCs[event.GuildID]
-				if joined {
-					currentChannelId = guildMemberJoinVCs[event.Author.ID]
-				}
-
 				if sc.IsMentioned(event) {
-					if currentChannelId == "" {
-						SendMessage(
-							sess, logger, event.ID, event.ChannelID,
-							strings.Join([]string{"😑", "ボイスチャンネルに入室しているときのみ利用可能です"}, " "),
-							nil,
-						)
-						break
-					}
-
-					serverStatus, exist := serverStatuses[event.GuildID]
-					if exist {
-						if serverStatus.voiceConn.ChannelID != currentChannelId {
-							SendMessage(
-								sess, logger, event.ID, event.ChannelID,
-								strings.Join([]string{"💔", "他のボイスチャンネルにいるので動けません"}, " "),
-								nil,
-							)
-						}
-					} else {
-						if ss, err := newJoinedServerStatus(baseLogger, sess, voicevoxApp, &event, currentChannelId); err != nil {
-							logger.Error("failed start server", zap.Error(err))
-						} else {
-							serverStatus = ss
-							serverStatuses[event.GuildID] = serverStatus
-						}
-					}
-
-					content := event.Content
-					for _, mention := range event.Mentions {
-						content = strings.ReplaceAll(content, mention.Mention(), " ")
-					}
-					for _, mention := range event.MentionChannels {
-						content = strings.ReplaceAll(content, mention.Mention(), " ")
-					}
-					for _, mention := range event.MentionRoles {
-						content = strings.ReplaceAll(content, mention, " ")
-					}
-
-					switch {
-					case strings.Contains(content, "--help"):
-						SendMessage(
-							sess, logger, event.ID, event.ChannelID,
-							strings.Join([]string{"😶", "ヘルプ"}, " "),
-							&discordgo.MessageEmbed{
-								Description: strings.Join([]string{
-									"<このボットへのメンション> <コマンド> （その他）",
-									"`--list-voice`: 使用できるボイスの一覧を表示",
-									"`--set-voice`: ボイスを設定",
-									"```",
-									"--set-voice <ボイスを設定するメンバーへのメンション>(...) <設定するボイスの名前>",
-									"```",
-									"`--leave`: Bot退出",
-									"`--help`: ヘルプ表示",
-								}, "\n"),
-							},
-						)
-					case strings.Contains(content, "--leave"):
-						serverStatus.Close()
-						delete(serverStatuses, event.GuildID)
-					case strings.Contains(content, "--list-voice"):
-						speakers, err := voicevoxApp.GetSpeakers("", true)
-						if err != nil {
-							logger.Error("failed get voicevox speakers", zap.Error(err))
-							break
-						}
-
-						speakerNames := []string{}
-						for _, speaker := range speakers {
-							speakerNames = append(speakerNames, fmt.Sprintf("- %s", speaker.Name))
-						}
-
-						SendMessage(
-							sess, logger, event.ID, event.ChannelID,
-							strings.Join([]string{"🥳", "担当可能な声の一覧"}, " "),
-							&discordgo.MessageEmbed{
-								Description: strings.Join(speakerNames, "\n"),
-								Footer: &discordgo.MessageEmbedFooter{
-									Text: "ボイスを設定するときは: --set-voice <声を設定するメンバーへのメンション>(...) <設定する声の名前>",
-								},
-							},
-						)
-
-					case strings.Contains(content, "--set-voice"):
-
-						speakers, err := voicevoxApp.GetSpeakers("", false)
-						if err != nil {
-							logger.Error("failed get voicevox speakers", zap.Error(err))
-							break
-						}
-						mentionedUser := []*discordgo.User{}
-						for _, mention := range event.Mentions {
-							if !mention.Bot {
-								mentionedUser = append(mentionedUser, mention)
-							}
-						}
-
-						if len(mentionedUser) == 0 {
-							SendMessage(
-								sess, logger, event.ID, event.ChannelID,
-								strings.Join([]string{"🤔", "声を設定するメンバーを指定してください"}, " "),
-								&discordgo.MessageEmbed{
-									Description: strings.Join([]string{
-										"声を設定するには，<Botへのメンション> --set-voice <声を設定するメンバーへのメンション>(...) <設定する声の名前>を指定していください",
-										strings.Join([]string{"例:", "--set-voice", event.Author.Mention(), speakers[rand.Intn(len(speakers))].Name}, " "),
-									}, "\n"),
-								},
-							)
-							break
-						}
-
-						searchName := func() string {
-							if matched := regexp.MustCompile(`\s+([^\s]+?)\s*$`).FindStringSubmatch(content); len(matched) == 2 {
-								return matched[1]
-							} else {
-								return "**Unknown**"
-							}
-						}()
-
-						speakers, err = voicevoxApp.GetSpeakers(searchName, false)
-						if err != nil {
-							logger.Error("failed get voicevox speakers", zap.Error(err))
-							break
-						}
-
-						if len(speakers) == 0 {
-							SendMessage(
-								sess, logger, event.ID, event.ChannelID,
-								strings.Join([]string{"🤯", "当てはまる声がみつかりませんでした「" + searchName + "」"}, " "),
-								nil,
-							)
-							break
-						}
-
-						for _, mention := range event.Mentions {
-							if !mention.Bot {
-								serverStatus.SetVoiceSpeaker(&event, mention.ID, speakers[0])
-							}
-						}
-					}
-
+					st.onMentioned(&event)
 				} else {
-					if !joined {
+					currentChannelId := st.currentChannelID(event.GuildID, event.Author.ID)
+					if currentChannelId == "" {
 						break
 					}
 
@@ -229,6 +107,9 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 					}
 				}
 
+			case event := <-interactionCreateListener:
+				st.onInteraction(&event)
+
 			case event := <-voiceStateUpdateListener:
 				guildMemberJoinVCs, exist := memberJoinVCs[event.GuildID]
 				if !exist {
@@ -277,6 +158,9 @@ func NewService(baseLogger *zap.Logger, discordToken string, voicevoxApp *voicev
 	}()
 
 	sess.Open()
+	if _, err := sess.ApplicationCommandBulkOverwrite(app.ID, "", applicationCommands); err != nil {
+		baseLogger.Error("failed register application commands", zap.Error(err))
+	}
 	return sc, nil
 }
 
@@ -0,0 +1,82 @@
+package talker
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streamwest-1629/chatspace/app/voicevox"
+	"go.uber.org/zap"
+)
+
+// 声の一覧を取り直す間隔 (エンジンの再読み込みで変わることがある)
+const speakerCacheTTL = 10 * time.Minute
+
+// speakerCache keeps the speaker list for autocomplete, which must answer within 3 seconds
+// and so cannot wait for the event loop or the engine.
+type speakerCache struct {
+	logger      *zap.Logger
+	voicevoxApp *voicevox.VoiceVox
+
+	lock       sync.Mutex
+	speakers   []voicevox.VoiceSpeaker
+	updatedAt  time.Time
+	refreshing bool
+}
+
+func newSpeakerCache(logger *zap.Logger, voicevoxApp *voicevox.VoiceVox) *speakerCache {
+	c := &speakerCache{
+		logger:      logger,
+		voicevoxApp: voicevoxApp,
+	}
+	c.refresh()
+	return c
+}
+
+// search returns the cached speakers whose name contains nameFilter.
+// It starts refreshing in the background if the cache is old.
+func (c *speakerCache) search(nameFilter string) []voicevox.VoiceSpeaker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if time.Since(c.updatedAt) > speakerCacheTTL {
+		c.refreshLocked()
+	}
+
+	result := []voicevox.VoiceSpeaker{}
+	for _, speaker := range c.speakers {
+		if strings.Contains(speaker.Name, nameFilter) {
+			result = append(result, speaker)
+		}
+	}
+	return result
+}
+
+func (c *speakerCache) refresh() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.refreshLocked()
+}
+
+// (c.lock を取得した状態で呼ぶ)
+func (c *speakerCache) refreshLocked() {
+	if c.refreshing {
+		return
+	}
+	c.refreshing = true
+
+	go func() {
+		// エンジンの起動や再読み込みを待ってから取得する
+		speakers, err := c.voicevoxApp.GetSpeakers("", true)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.refreshing = false
+		if err != nil {
+			c.logger.Warn("failed get voicevox speakers", zap.Error(err))
+			return
+		}
+		c.speakers = speakers
+		c.updatedAt = time.Now()
+	}()
+}
@@ -28,6 +28,7 @@ func main() {
 	config := voicevox.InitConfig{
 		NumThreads:    2,
 		LoadAllModels: true,
+		ModelDir:      os.Getenv("VOICEVOX_MODELDIR"),
 	}
 
 	// voicevox application
@@ -43,7 +44,7 @@ func main() {
 
 	// chatspace application
 	discordToken := os.Getenv("TALKER_DISCORD_TOKEN")
-	controller, err := talker.NewService(logger, discordToken, vv)
+	controller, err := talker.NewService(logger, discordToken, vv, "data")
 	if err != nil {
 		logger.Error("cannot start talker application", zap.String("discordToken", discordToken[:8]+"***"+discordToken[len(discordToken)-8:]), zap.Error(err))
 	}
@@ -0,0 +1,50 @@
+package talker
+
+import (
+	"github.com/streamwest-1629/chatspace/app/voicevox"
+	"github.com/streamwest-1629/chatspace/lib/store"
+)
+
+// 声はスタイルIDではなく名前で保存する (エンジンの更新でIDが変わることがあるため)
+type voiceRecord struct {
+	SpeakerName string           `json:"speakerName"`
+	Prosody     voicevox.Prosody `json:"prosody"`
+}
+
+// guildID -> memberID -> voice
+type voiceStoreData map[string]map[string]voiceRecord
+
+type voiceStore struct {
+	file *store.File[voiceStoreData]
+}
+
+func newVoiceStore(path string) *voiceStore {
+	return &voiceStore{
+		file: store.NewFile[voiceStoreData](path),
+	}
+}
+
+func (vs *voiceStore) load(guildID string) (map[string]voiceRecord, error) {
+	data, err := vs.file.Load()
+	if err != nil {
+		return nil, err
+	}
+	return data[guildID], nil
+}
+
+func (vs *voiceStore) save(guildID, memberID string, voice memberVoice) error {
+	return vs.file.Update(func(data *voiceStoreData) error {
+		if *data == nil {
+			*data = voiceStoreData{}
+		}
+		if (*data)[guildID] == nil {
+			(*data)[guildID] = map[string]voiceRecord{}
+		}
+
+		(*data)[guildID][memberID] = voiceRecord{
+			SpeakerName: voice.Speaker.Name,
+			Prosody:     voice.Prosody,
+		}
+		return nil
+	})
+}
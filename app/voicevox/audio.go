@@ -0,0 +1,129 @@
+package voicevox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+)
+
+// pcmFrames yields the frames of 48 kHz stereo audio sent to Discord.
+type pcmFrames interface {
+	// next returns io.EOF after the last frame.
+	next() ([]int16, error)
+	Close() error
+}
+
+// decodeAudio converts the audio to frames for Discord.
+// WAV (such as the voices of VOICEVOX) is decoded in process and the other formats are converted by ffmpeg.
+func decodeAudio(r io.Reader) (pcmFrames, error) {
+	buffered := bufio.NewReader(r)
+	// 12 バイトに満たない場合は ffmpeg に判断させる
+	header, _ := buffered.Peek(12)
+	if !isWAV(header) {
+		return ffmpegConvert(buffered)
+	}
+
+	audio, err := decodeWAV(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedFrames{samples: discordPCM(audio)}, nil
+}
+
+// discordPCM resamples the audio to 48 kHz stereo, padding the last frame with silence.
+func discordPCM(audio *pcmAudio) []int16 {
+	left := audio.channel(0)
+	right := left
+	// 3 チャンネル以上は先頭の 2 チャンネルを使う
+	if audio.channels >= 2 {
+		right = audio.channel(1)
+	}
+	if audio.sampleRate != frameRate {
+		r := newResampler(audio.sampleRate, frameRate)
+		left = r.resample(left)
+		if audio.channels >= 2 {
+			right = r.resample(right)
+		} else {
+			right = left
+		}
+	}
+
+	frames := (len(left) + frameSize - 1) / frameSize
+	out := make([]int16, frames*frameSize*channels)
+	for i := range left {
+		out[i*channels] = toInt16(left[i])
+		out[i*channels+1] = toInt16(right[i])
+	}
+	return out
+}
+
+func toInt16(v float32) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(float64(v)*math.MaxInt16))))
+}
+
+// bufferedFrames are frames decoded in memory.
+type bufferedFrames struct {
+	samples []int16
+}
+
+func (f *bufferedFrames) next() ([]int16, error) {
+	if len(f.samples) == 0 {
+		return nil, io.EOF
+	}
+	frame := f.samples[:frameSize*channels]
+	f.samples = f.samples[frameSize*channels:]
+	return frame, nil
+}
+
+func (f *bufferedFrames) Close() error {
+	f.samples = nil
+	return nil
+}
+
+// ffmpegFrames are frames converted by an ffmpeg process.
+type ffmpegFrames struct {
+	run    *exec.Cmd
+	stdout *bufio.Reader
+}
+
+func ffmpegConvert(input io.Reader) (*ffmpegFrames, error) {
+
+	run := exec.Command("ffmpeg", "-i", "pipe:", "-f", "s16le", "-ar", strconv.Itoa(frameRate), "-ac", strconv.Itoa(channels), "pipe:1")
+	run.Stdin = input
+	ffmpegout, err := run.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot ffmpeg stdout pipe: %w", err)
+	}
+
+	if err = run.Start(); err != nil {
+		return nil, fmt.Errorf("failed run ffmpeg command: %w", err)
+	}
+
+	return &ffmpegFrames{
+		run:    run,
+		stdout: bufio.NewReaderSize(ffmpegout, 16384),
+	}, nil
+}
+
+func (f *ffmpegFrames) next() ([]int16, error) {
+	frame := make([]int16, frameSize*channels)
+	err := binary.Read(f.stdout, binary.LittleEndian, &frame)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("error from ffmpeg: %w", err)
+	}
+	return frame, nil
+}
+
+// Close stops ffmpeg if the audio is not played to the end.
+func (f *ffmpegFrames) Close() error {
+	// 終了済みのプロセスを止めようとしたエラーは無視する
+	f.run.Process.Kill()
+	f.run.Wait()
+	return nil
+}
@@ -1,18 +1,13 @@
 package voicevox
 
 import (
-	"bufio"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"io"
-	"os/exec"
-	"strconv"
+	"os"
 	"sync"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/gammazero/deque"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"layeh.com/gopus"
 )
@@ -23,6 +18,11 @@ type ManagedDiscordVoiceConnection struct {
 	dvc       *DiscordVoiceConnection
 	vc        *discordgo.VoiceConnection
 	app       *VoiceVox
+
+	// discordgo のハンドラーは外せないので、1つだけ登録して振り分ける
+	speakingLock     sync.Mutex
+	speakingHandlers map[int]func(userID string)
+	speakingSeq      int
 }
 
 func StartManagedDiscordVoiceConnection(appLogger *zap.Logger, sess *discordgo.Session, guildID, channelID string, voiceVox *VoiceVox, replaceFn func(input string) string) (*ManagedDiscordVoiceConnection, error) {
@@ -31,16 +31,23 @@ func StartManagedDiscordVoiceConnection(appLogger *zap.Logger, sess *discordgo.S
 		return nil, err
 	}
 
-	return &ManagedDiscordVoiceConnection{
-		GuildID:   guildID,
-		ChannelID: channelID,
-		dvc:       StartDiscordVoiceConnection(appLogger, vc, voiceVox, replaceFn),
-		vc:        vc,
-		app:       voiceVox,
-	}, nil
+	m := &ManagedDiscordVoiceConnection{
+		GuildID:          guildID,
+		ChannelID:        channelID,
+		dvc:              StartDiscordVoiceConnection(appLogger, vc, voiceVox, replaceFn),
+		vc:               vc,
+		app:              voiceVox,
+		speakingHandlers: map[int]func(userID string){},
+	}
+	vc.AddHandler(m.onSpeakingUpdate)
+	return m, nil
 }
 
 func (m *ManagedDiscordVoiceConnection) Close() error {
+	m.speakingLock.Lock()
+	m.speakingHandlers = map[int]func(userID string){}
+	m.speakingLock.Unlock()
+
 	m.dvc.Quit()
 	if err := m.vc.Disconnect(); err != nil {
 		m.vc.Close()
@@ -54,133 +61,217 @@ func (m *ManagedDiscordVoiceConnection) Speak(speakerID int, waitSpeaked bool, c
 	m.dvc.Speak(speakerID, waitSpeaked, content)
 }
 
+func (m *ManagedDiscordVoiceConnection) SpeakUtterance(utterance Utterance, waitSpeaked bool) {
+	m.dvc.SpeakUtterance(utterance, waitSpeaked)
+}
+
+func (m *ManagedDiscordVoiceConnection) PlayFile(path string, waitPlayed bool) {
+	m.dvc.PlayFile(path, waitPlayed)
+}
+
+func (m *ManagedDiscordVoiceConnection) Skip() bool {
+	return m.dvc.Skip()
+}
+
+func (m *ManagedDiscordVoiceConnection) Clear() int {
+	return m.dvc.Clear()
+}
+
+func (m *ManagedDiscordVoiceConnection) ClearUser(userID string) int {
+	return m.dvc.ClearUser(userID)
+}
+
+func (m *ManagedDiscordVoiceConnection) ClearMessages() int {
+	return m.dvc.ClearMessages()
+}
+
+// OnSpeaking calls fn when a member starts speaking in the voice channel.
+// The returned function removes fn.
+func (m *ManagedDiscordVoiceConnection) OnSpeaking(fn func(userID string)) (remove func()) {
+	m.speakingLock.Lock()
+	defer m.speakingLock.Unlock()
+
+	m.speakingSeq++
+	id := m.speakingSeq
+	m.speakingHandlers[id] = fn
+	return func() {
+		m.speakingLock.Lock()
+		defer m.speakingLock.Unlock()
+		delete(m.speakingHandlers, id)
+	}
+}
+
+func (m *ManagedDiscordVoiceConnection) onSpeakingUpdate(_ *discordgo.VoiceConnection, vs *discordgo.VoiceSpeakingUpdate) {
+	if !vs.Speaking {
+		return
+	}
+	m.speakingLock.Lock()
+	handlers := make([]func(userID string), 0, len(m.speakingHandlers))
+	for _, fn := range m.speakingHandlers {
+		handlers = append(handlers, fn)
+	}
+	m.speakingLock.Unlock()
+
+	for _, fn := range handlers {
+		fn(vs.UserID)
+	}
+}
+
+func (m *ManagedDiscordVoiceConnection) SupportsProsody() bool {
+	return m.app.SupportsProsody()
+}
+
 func (m *ManagedDiscordVoiceConnection) GetSpeakers(nameFilter string, waitResume bool) ([]VoiceSpeaker, error) {
 	return m.app.GetSpeakers(nameFilter, waitResume)
 }
 
+// DiscordVoiceConnection plays utterances and sound files in the order they are queued.
 type DiscordVoiceConnection struct {
-	genQueueQuit  chan<- *sync.WaitGroup
-	generateQueue chan<- generateVoiceArgs
+	pipeline *playbackPipeline
+	done     <-chan struct{}
+	cancel   context.CancelFunc
+}
+
+// Utterance is a sentence queued on DiscordVoiceConnection.
+type Utterance struct {
+	SpeakerID int
+	Prosody   Prosody
+	Content   string
+	// Owner is the user whose message is read, used by ClearUser and ClearMessages. It is empty for announcements.
+	Owner string
 }
 
 type generateVoiceArgs struct {
-	speakerID int
-	content   string
-	wg        *sync.WaitGroup
+	utterance Utterance
+	// soundFile is played instead of the utterance if set
+	soundFile string
+	// 合成・変換が終わると閉じる (待たない場合は nil)
+	ready chan struct{}
 }
 
 func StartDiscordVoiceConnection(appLogger *zap.Logger, vc *discordgo.VoiceConnection, voiceVox *VoiceVox, replaceFn func(input string) string) *DiscordVoiceConnection {
 
-	var (
-		genQueueQuit  = make(chan *sync.WaitGroup)
-		generateQueue = make(chan generateVoiceArgs)
+	ctx, cancel := context.WithCancel(context.Background())
+	pipeline := startPlaybackPipeline(ctx,
+		func(args generateVoiceArgs) pcmFrames {
+			return prepareAudio(appLogger, voiceVox, args)
+		},
+		func(ctx context.Context, content string, frames pcmFrames) {
+			if err := playAudio(ctx, appLogger, vc, frames); err != nil {
+				appLogger.Error("cannot play audio", zap.String("content", content), zap.Error(err))
+			}
+		},
 	)
 
-	speakUUIDQueue := deque.New[string]()
-	speakUUIDQueueLock := sync.Mutex{}
+	return &DiscordVoiceConnection{
+		pipeline: pipeline,
+		done:     ctx.Done(),
+		cancel:   cancel,
+	}
+}
 
-	go func() {
-		for {
-			select {
-			case wg := <-genQueueQuit:
-				defer wg.Done()
-				return
-
-			case args := <-generateQueue:
-				func() {
-					defer func() {
-						// finalize operation for call event ended
-						if args.wg != nil {
-							args.wg.Done()
-						}
-					}()
-
-					appLogger.Debug("voicevox generate request recieved", zap.String("content", args.content))
-
-					wav, err := voiceVox.GenerateVoice(args.content, args.speakerID, false)
-					if err != nil {
-						appLogger.Error("failed to generate voice", zap.Int("speakerId", args.speakerID), zap.Error(err))
-						return
-					}
-					appLogger.Debug("voicevox generate finished", zap.String("content", args.content))
-
-					key := uuid.NewString()
-					func() {
-						speakUUIDQueueLock.Lock()
-						defer speakUUIDQueueLock.Unlock()
-						speakUUIDQueue.PushBack(key)
-					}()
-
-					go func() {
-						defer wav.Close()
-						appLogger.Debug("ffmpeg convert request start", zap.String("content", args.content))
-						ffmpegout, process, err := ffmpegConvert(wav)
-						if err != nil {
-							appLogger.Error("convert error by ffmpeg", zap.Error(err))
-							return
-						}
-						defer ffmpegout.Close()
-						appLogger.Debug("ffmpeg convert finished", zap.String("content", args.content))
-
-						for func() string {
-							speakUUIDQueueLock.Lock()
-							defer speakUUIDQueueLock.Unlock()
-							return speakUUIDQueue.Front()
-						}() != key {
-							time.Sleep(50 * time.Millisecond)
-						}
-
-						if err := playAudio(appLogger, vc, ffmpegout, process); err != nil {
-							appLogger.Error("cannot play audio", zap.Error(err))
-						}
-						func() {
-							speakUUIDQueueLock.Lock()
-							defer speakUUIDQueueLock.Unlock()
-
-							speakUUIDQueue.PopFront()
-						}()
-					}()
-				}()
-			}
+// prepareAudio synthesizes the utterance or opens the sound file (失敗すると nil)
+func prepareAudio(appLogger *zap.Logger, voiceVox *VoiceVox, args generateVoiceArgs) pcmFrames {
+	utterance := args.utterance
+	var wav io.ReadCloser
+	if args.soundFile != "" {
+		file, err := os.Open(args.soundFile)
+		if err != nil {
+			appLogger.Error("cannot open sound file", zap.String("path", args.soundFile), zap.Error(err))
+			return nil
 		}
-	}()
+		wav = file
+		utterance.Content = args.soundFile
+	} else {
+		appLogger.Debug("voicevox generate request recieved", zap.String("content", utterance.Content))
 
-	return &DiscordVoiceConnection{
-		genQueueQuit:  genQueueQuit,
-		generateQueue: generateQueue,
+		generated, err := voiceVox.GenerateVoiceWithProsody(utterance.Content, utterance.SpeakerID, utterance.Prosody, false)
+		if err != nil {
+			appLogger.Error("failed to generate voice", zap.Int("speakerId", utterance.SpeakerID), zap.Error(err))
+			return nil
+		}
+		appLogger.Debug("voicevox generate finished", zap.String("content", utterance.Content))
+		wav = generated
+	}
+
+	frames, err := decodeAudio(wav)
+	if err != nil {
+		wav.Close()
+		appLogger.Error("cannot decode audio", zap.String("content", utterance.Content), zap.Error(err))
+		return nil
 	}
+	return &closingFrames{pcmFrames: frames, source: wav}
 }
 
+// closingFrames closes the source of the frames together.
+type closingFrames struct {
+	pcmFrames
+	source io.Closer
+}
+
+func (f *closingFrames) Close() error {
+	f.pcmFrames.Close()
+	return f.source.Close()
+}
+
+// Quit stops the playback and drops the queued audio. It waits until the playback stops.
 func (d *DiscordVoiceConnection) Quit() {
-	if d.genQueueQuit != nil {
-		defer func() { d.genQueueQuit = nil }()
-		wg := sync.WaitGroup{}
-		wg.Add(1)
-		d.genQueueQuit <- &wg
-		wg.Wait()
-	}
+	d.cancel()
+	d.pipeline.wg.Wait()
+}
+
+// Skip stops the audio playing now and goes on to the next one. It reports whether any audio was playing.
+func (d *DiscordVoiceConnection) Skip() bool {
+	return d.pipeline.skip()
+}
+
+// Clear stops the audio playing now and drops all the queued audio. It returns the number of dropped audio.
+func (d *DiscordVoiceConnection) Clear() int {
+	return d.pipeline.clear(func(string) bool { return true })
+}
+
+// ClearUser drops the utterances of the user, including the one playing now. It returns the number of dropped utterances.
+func (d *DiscordVoiceConnection) ClearUser(userID string) int {
+	return d.pipeline.clear(func(owner string) bool { return owner == userID })
+}
+
+// ClearMessages drops the utterances reading the messages of the members and keeps the announcements.
+func (d *DiscordVoiceConnection) ClearMessages() int {
+	return d.pipeline.clear(func(owner string) bool { return owner != "" })
 }
 
 func (d *DiscordVoiceConnection) Speak(speakerID int, waitSpeaked bool, content string) {
-	var wg *sync.WaitGroup
-	if waitSpeaked {
-		wg = &sync.WaitGroup{}
-		wg.Add(1)
-	}
+	d.SpeakUtterance(Utterance{SpeakerID: speakerID, Content: content}, waitSpeaked)
+}
+
+// SpeakUtterance queues the utterance. waitSpeaked waits until its voice is synthesized.
+func (d *DiscordVoiceConnection) SpeakUtterance(utterance Utterance, waitSpeaked bool) {
+	d.enqueue(generateVoiceArgs{utterance: utterance}, waitSpeaked)
+}
+
+// PlayFile plays the sound file in turn with the utterances.
+func (d *DiscordVoiceConnection) PlayFile(path string, waitPlayed bool) {
+	d.enqueue(generateVoiceArgs{soundFile: path}, waitPlayed)
+}
 
-	d.generateQueue <- generateVoiceArgs{
-		wg:        wg,
-		speakerID: speakerID,
-		content:   content,
+func (d *DiscordVoiceConnection) enqueue(args generateVoiceArgs, wait bool) {
+	if wait {
+		args.ready = make(chan struct{})
 	}
 
-	if wg != nil {
-		wg.Wait()
+	select {
+	case d.pipeline.queue <- args:
+	case <-d.done:
+		// 終了後に積まれた音声は捨てる
+		return
 	}
-}
 
-type Killer interface {
-	Kill() error
+	if args.ready != nil {
+		select {
+		case <-args.ready:
+		case <-d.done:
+		}
+	}
 }
 
 const (
@@ -190,28 +281,8 @@ const (
 	maxBytes  = 3840
 )
 
-func ffmpegConvert(wavReader io.Reader) (ffmpegout io.ReadCloser, processKiller Killer, err error) {
+func playAudio(ctx context.Context, logger *zap.Logger, vcConn *discordgo.VoiceConnection, frames pcmFrames) error {
 
-	run := exec.Command("ffmpeg", "-i", "pipe:", "-f", "s16le", "-ar", strconv.Itoa(frameRate), "-ac", strconv.Itoa(channels), "pipe:1")
-	run.Stdin = wavReader
-	// binary := bytes.NewBuffer([]byte{})
-	// run.Stdout = binary
-	ffmpegout, err = run.StdoutPipe()
-	if err != nil {
-		return nil, nil, fmt.Errorf("cannot ffmpeg stdout pipe: %w", err)
-	}
-
-	if err = run.Start(); err != nil {
-		return nil, nil, fmt.Errorf("failed run ffmpeg command: %w", err)
-	}
-
-	return ffmpegout, run.Process, nil
-	// return io.NopCloser(binary), run.Process, nil
-}
-
-func playAudio(logger *zap.Logger, vcConn *discordgo.VoiceConnection, ffmpegout io.Reader, processKiller Killer) error {
-
-	ffmpegbuf := bufio.NewReaderSize(ffmpegout, 16384)
 	if err := vcConn.Speaking(true); err != nil {
 		return fmt.Errorf("could not speaking: %w", err)
 	}
@@ -222,36 +293,39 @@ func playAudio(logger *zap.Logger, vcConn *discordgo.VoiceConnection, ffmpegout
 	}()
 
 	send := make(chan []int16, 2)
-	defer close(send)
-
-	close := make(chan bool)
+	stopped := make(chan struct{})
 	go func() {
-		if err := sendPCM(vcConn, send); err != nil {
+		defer close(stopped)
+		if err := sendPCM(ctx, vcConn, send); err != nil {
 			logger.Error("playing audio error", zap.Error(err))
 		}
-		close <- true
+	}()
+	// 次の音声と混ざらないように送信し終えるまで待つ
+	defer func() {
+		close(send)
+		<-stopped
 	}()
 
 	for {
-		audiobuf := make([]int16, frameSize*channels)
-		err := binary.Read(ffmpegbuf, binary.LittleEndian, &audiobuf)
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
+		audiobuf, err := frames.next()
+		if err == io.EOF {
 			return nil
 		}
 		if err != nil {
-			return fmt.Errorf("error from ffmpeg: %w", err)
+			return err
 		}
 
 		select {
 		case send <- audiobuf:
-		case <-close:
-			processKiller.Kill()
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
-func sendPCM(vcConn *discordgo.VoiceConnection, pcm <-chan []int16) error {
+func sendPCM(ctx context.Context, vcConn *discordgo.VoiceConnection, pcm <-chan []int16) error {
 	if pcm == nil {
 		return nil
 	}
@@ -275,8 +349,12 @@ func sendPCM(vcConn *discordgo.VoiceConnection, pcm <-chan []int16) error {
 			return fmt.Errorf("voice connection is not ready")
 		} else if vcConn.OpusSend == nil {
 			return fmt.Errorf("opus sender is nil")
-		} else {
-			vcConn.OpusSend <- opus
+		}
+
+		select {
+		case vcConn.OpusSend <- opus:
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
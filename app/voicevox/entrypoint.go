@@ -12,6 +12,7 @@ import (
 )
 
 type InitConfig = voicevox.InitConfig
+type AudioQuery = voicevox.AudioQuery
 
 type VoiceVox struct {
 	client      *voicevox.Client
@@ -19,9 +20,13 @@ type VoiceVox struct {
 	logger      *zap.Logger
 	config      voicevox.InitConfig
 	genQueue    chan<- generateSpeakerConfig
+	queryQueue  chan<- audioQueryConfig
+	synthQueue  chan<- synthesisConfig
 	reloadQueue chan<- func()
 	statusQueue chan<- statusMonitor
 	quit        chan<- *sync.WaitGroup
+	// 調整を無視したことは一度だけ警告する
+	prosodyIgnored sync.Once
 }
 
 type VoiceSpeaker struct {
@@ -38,6 +43,18 @@ type generateSpeakerConfig struct {
 	Receiver   func(io.ReadCloser, error)
 }
 
+type audioQueryConfig struct {
+	Text      string
+	SpeakerId int
+	Receiver  func(*voicevox.AudioQuery, error)
+}
+
+type synthesisConfig struct {
+	Query     *voicevox.AudioQuery
+	SpeakerId int
+	Receiver  func(io.ReadCloser, error)
+}
+
 type loadInfo struct {
 	speakerIdxNameMap map[string]int
 	speakerIdxIdMap   map[int]int
@@ -63,9 +80,12 @@ func Start(appLogger *zap.Logger, corePath, jTalkDir string, config voicevox.Ini
 	if err != nil {
 		return &VoiceVox{}, err
 	}
+	appLogger.Info("voicevox core library loaded", zap.Stringer("abi", client.ABI()))
 
 	var (
 		genQueue    = make(chan generateSpeakerConfig)
+		queryQueue  = make(chan audioQueryConfig)
+		synthQueue  = make(chan synthesisConfig)
 		reloadQueue = make(chan func(), 1)
 		statusQueue = make(chan statusMonitor)
 		quit        = make(chan *sync.WaitGroup, 1)
@@ -76,6 +96,8 @@ func Start(appLogger *zap.Logger, corePath, jTalkDir string, config voicevox.Ini
 		config:      config,
 		logger:      appLogger,
 		genQueue:    genQueue,
+		queryQueue:  queryQueue,
+		synthQueue:  synthQueue,
 		reloadQueue: reloadQueue,
 		statusQueue: statusQueue,
 		quit:        quit,
@@ -92,10 +114,16 @@ func Start(appLogger *zap.Logger, corePath, jTalkDir string, config voicevox.Ini
 			reloadQueueReceiver := (<-chan func())(reloadQueue)
 			statusQueueReceiver := (<-chan statusMonitor)(statusQueue)
 			genQueueReceiver := (<-chan generateSpeakerConfig)(genQueue)
+			queryQueueReceiver := (<-chan audioQueryConfig)(queryQueue)
+			synthQueueReceiver := (<-chan synthesisConfig)(synthQueue)
 			switch {
 			case len(statusQueue) > 0:
 				fallthrough
 			case len(genQueue) > 0:
+				fallthrough
+			case len(queryQueue) > 0:
+				fallthrough
+			case len(synthQueue) > 0:
 				reloadQueueReceiver = nil
 			}
 
@@ -173,6 +201,20 @@ func Start(appLogger *zap.Logger, corePath, jTalkDir string, config voicevox.Ini
 					req.Receiver(wav, err)
 				}
 
+			case req := <-queryQueueReceiver:
+				if _, exist := status.info.speakerIdxIdMap[req.SpeakerId]; !exist {
+					req.Receiver(nil, ErrUnknownSpeaker)
+				} else {
+					req.Receiver(client.AudioQuery(req.Text, req.SpeakerId))
+				}
+
+			case req := <-synthQueueReceiver:
+				if _, exist := status.info.speakerIdxIdMap[req.SpeakerId]; !exist {
+					req.Receiver(nil, ErrUnknownSpeaker)
+				} else {
+					req.Receiver(client.Synthesis(req.Query, req.SpeakerId))
+				}
+
 			}
 		}
 	}()
@@ -230,6 +272,92 @@ func (v *VoiceVox) GenerateVoice(text string, speakerId int, waitResume bool) (w
 	}
 }
 
+// AudioQuery analyzes text into an editable query.
+// It returns voicevox.ErrNotSupported if the loaded core has no AudioQuery API.
+func (v *VoiceVox) AudioQuery(text string, speakerId int, waitResume bool) (query *AudioQuery, err error) {
+
+	unlock, err := v.rLock(waitResume)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	v.queryQueue <- audioQueryConfig{
+		Text:      text,
+		SpeakerId: speakerId,
+		Receiver: func(q *voicevox.AudioQuery, genErr error) {
+			query, err = q, genErr
+			wg.Done()
+		},
+	}
+	wg.Wait()
+
+	return query, err
+}
+
+// Synthesis renders the query made by AudioQuery into WAV.
+func (v *VoiceVox) Synthesis(query *AudioQuery, speakerId int, waitResume bool) (wav io.ReadCloser, err error) {
+
+	unlock, err := v.rLock(waitResume)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	v.synthQueue <- synthesisConfig{
+		Query:     query,
+		SpeakerId: speakerId,
+		Receiver: func(rc io.ReadCloser, genErr error) {
+			wav, err = rc, genErr
+			wg.Done()
+		},
+	}
+	wg.Wait()
+
+	return wav, err
+}
+
+// SupportsProsody reports whether the loaded core can apply Prosody.
+func (v *VoiceVox) SupportsProsody() bool {
+	return v.client != nil && v.client.ABI() == voicevox.ABISynthesizer
+}
+
+// GenerateVoiceWithProsody generates voice with the prosody applied to its AudioQuery.
+// If the loaded core has no AudioQuery API, the prosody is ignored.
+func (v *VoiceVox) GenerateVoiceWithProsody(text string, speakerId int, prosody Prosody, waitResume bool) (wav io.ReadCloser, err error) {
+
+	if prosody.IsZero() {
+		return v.GenerateVoice(text, speakerId, waitResume)
+	}
+
+	query, err := v.AudioQuery(text, speakerId, waitResume)
+	if errors.Is(err, voicevox.ErrNotSupported) {
+		v.prosodyIgnored.Do(func() {
+			v.logger.Warn("audio query is not supported by the loaded core, prosody is ignored", zap.Stringer("abi", v.client.ABI()))
+		})
+		return v.GenerateVoice(text, speakerId, waitResume)
+	} else if err != nil {
+		return nil, err
+	}
+
+	prosody.Apply(query)
+	return v.Synthesis(query, speakerId, waitResume)
+}
+
+func (v *VoiceVox) rLock(waitResume bool) (unlock func(), err error) {
+	if waitResume {
+		return func() {}, nil
+	}
+	if v.restartLock.TryRLock() {
+		return v.restartLock.RUnlock, nil
+	}
+	return nil, ErrRestarting
+}
+
 func (v *VoiceVox) GetSpeakers(nameFilter string, waitResume bool) ([]VoiceSpeaker, error) {
 
 	status, err := v.getStatus(waitResume)
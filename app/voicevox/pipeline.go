@@ -0,0 +1,222 @@
+package voicevox
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// 読み上げを待てる文章の数 (超えると SpeakUtterance が空くまで待つ)
+	playbackBuffer = 256
+	// 再生より先に合成・変換しておく数 (メモリに置く変換済みの音声の上限)
+	prefetchDepth = 4
+	// 同時に音声合成・変換する数
+	synthesisWorkers = 2
+)
+
+// finish tells the caller waiting for the audio that it is ready.
+func (args generateVoiceArgs) finish() {
+	if args.ready != nil {
+		close(args.ready)
+	}
+}
+
+// playbackItem is an audio waiting for its turn to play.
+type playbackItem struct {
+	args    generateVoiceArgs
+	content string
+	owner   string
+	// 変換済みの音声を再生のゴルーチンに渡す (失敗した場合は nil)
+	frames chan pcmFrames
+	// 取り消すと合成・変換・再生のどの段階でも捨てられる
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// playbackPipeline plays the audio sent to queue in order.
+type playbackPipeline struct {
+	queue chan<- generateVoiceArgs
+	// 受付・先読み・再生のゴルーチン
+	wg *sync.WaitGroup
+
+	lock sync.Mutex
+	// 再生し終えていない音声 (再生中のものを含む)
+	items   map[*playbackItem]struct{}
+	playing *playbackItem
+}
+
+// startPlaybackPipeline prepares the next audio concurrently and plays them in order until ctx is cancelled.
+func startPlaybackPipeline(ctx context.Context, prepare func(args generateVoiceArgs) pcmFrames, play func(ctx context.Context, content string, frames pcmFrames)) *playbackPipeline {
+
+	var (
+		generateQueue = make(chan generateVoiceArgs)
+		waitQueue     = make(chan *playbackItem, playbackBuffer)
+		readyQueue    = make(chan *playbackItem, prefetchDepth)
+		workerSlots   = make(chan struct{}, synthesisWorkers)
+	)
+	p := &playbackPipeline{
+		queue: generateQueue,
+		wg:    &sync.WaitGroup{},
+		items: map[*playbackItem]struct{}{},
+	}
+
+	// 受け付けた順に待ち行列に積む
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(waitQueue)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case args := <-generateQueue:
+				item := &playbackItem{
+					args:    args,
+					content: args.utterance.Content,
+					owner:   args.utterance.Owner,
+					frames:  make(chan pcmFrames),
+				}
+				if args.soundFile != "" {
+					item.content = args.soundFile
+				}
+				item.ctx, item.cancel = context.WithCancel(ctx)
+				p.track(item)
+
+				select {
+				case waitQueue <- item:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	// 再生が近づいた順に合成と変換をワーカーに任せる
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(readyQueue)
+
+		for item := range waitQueue {
+			if item.ctx.Err() != nil {
+				// 待っている間に取り消された
+				item.args.finish()
+				p.untrack(item)
+				continue
+			}
+
+			go func(item *playbackItem) {
+				frames := func() pcmFrames {
+					defer item.args.finish()
+					select {
+					case workerSlots <- struct{}{}:
+					case <-item.ctx.Done():
+						return nil
+					}
+					defer func() { <-workerSlots }()
+					if item.ctx.Err() != nil {
+						return nil
+					}
+					return prepare(item.args)
+				}()
+
+				// 再生のゴルーチンが受け取らなかった音声は自分で片付ける
+				select {
+				case item.frames <- frames:
+				case <-item.ctx.Done():
+					if frames != nil {
+						frames.Close()
+					}
+				}
+			}(item)
+
+			select {
+			case readyQueue <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// 再生は 1 つのゴルーチンで順番に行う
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		for item := range readyQueue {
+			var frames pcmFrames
+			select {
+			case frames = <-item.frames:
+			case <-item.ctx.Done():
+			}
+			if ctx.Err() != nil {
+				if frames != nil {
+					frames.Close()
+				}
+				return
+			}
+			if frames == nil {
+				p.untrack(item)
+				continue
+			}
+
+			p.setPlaying(item)
+			if item.ctx.Err() == nil {
+				play(item.ctx, item.content, frames)
+			}
+			frames.Close()
+			p.setPlaying(nil)
+			p.untrack(item)
+		}
+	}()
+
+	return p
+}
+
+func (p *playbackPipeline) track(item *playbackItem) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.items[item] = struct{}{}
+}
+
+func (p *playbackPipeline) untrack(item *playbackItem) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.items, item)
+	item.cancel()
+}
+
+func (p *playbackPipeline) setPlaying(item *playbackItem) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.playing = item
+}
+
+// skip stops the audio playing now. It reports whether any audio was playing.
+func (p *playbackPipeline) skip() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.playing == nil || p.playing.ctx.Err() != nil {
+		return false
+	}
+	p.playing.cancel()
+	return true
+}
+
+// clear drops the queued and playing audio whose owner matches. It returns the number of dropped audio.
+func (p *playbackPipeline) clear(match func(owner string) bool) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	count := 0
+	for item := range p.items {
+		if item.ctx.Err() == nil && match(item.owner) {
+			item.cancel()
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,257 @@
+package voicevox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeFrames struct {
+	closed *int32
+}
+
+func (f *fakeFrames) next() ([]int16, error) {
+	return nil, io.EOF
+}
+
+func (f *fakeFrames) Close() error {
+	atomic.AddInt32(f.closed, 1)
+	return nil
+}
+
+func TestPlaybackPipelineOrder(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const count = 8
+	var (
+		closed, running, maxRunning int32
+		lock                        sync.Mutex
+		played                      []string
+		done                        = make(chan struct{})
+	)
+	p := startPlaybackPipeline(ctx,
+		func(args generateVoiceArgs) pcmFrames {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			// 後の音声ほど早く変換が終わる
+			time.Sleep(time.Duration(count-args.utterance.SpeakerID) * 5 * time.Millisecond)
+			if args.utterance.SpeakerID == 3 {
+				// 変換に失敗した音声は飛ばす
+				return nil
+			}
+			return &fakeFrames{closed: &closed}
+		},
+		func(ctx context.Context, content string, frames pcmFrames) {
+			lock.Lock()
+			defer lock.Unlock()
+			played = append(played, content)
+			if len(played) == count-1 {
+				close(done)
+			}
+		},
+	)
+
+	for i := 0; i < count; i++ {
+		p.queue <- generateVoiceArgs{utterance: Utterance{SpeakerID: i, Content: fmt.Sprint(i)}}
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout: played %v", played)
+	}
+
+	expected := []string{"0", "1", "2", "4", "5", "6", "7"}
+	for i := range expected {
+		if played[i] != expected[i] {
+			t.Fatalf("unexpected order: %v", played)
+		}
+	}
+	if maxRunning > synthesisWorkers {
+		t.Errorf("too many workers: %d", maxRunning)
+	}
+
+	cancel()
+	p.wg.Wait()
+	if closed != count-1 {
+		t.Errorf("unexpected closed frames: %d", closed)
+	}
+}
+
+func TestPlaybackPipelineCancel(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var prepared, closed int32
+	started := make(chan struct{}, 1)
+	p := startPlaybackPipeline(ctx,
+		func(args generateVoiceArgs) pcmFrames {
+			atomic.AddInt32(&prepared, 1)
+			return &fakeFrames{closed: &closed}
+		},
+		func(ctx context.Context, content string, frames pcmFrames) {
+			// 止められるまで再生し続ける
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+		},
+	)
+	d := &DiscordVoiceConnection{pipeline: p, done: ctx.Done(), cancel: cancel}
+
+	// 合成が終わるまで待つ音声も止められる
+	for i := 0; i < 4; i++ {
+		d.Speak(0, i == 3, fmt.Sprint(i))
+	}
+	<-started
+	d.Quit()
+
+	// Quit の後に積んだ音声は待たずに捨てられる
+	finished := make(chan struct{})
+	go func() {
+		d.Speak(0, true, "after quit")
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("speak after quit is blocked")
+	}
+
+	// 受け渡されなかった音声はワーカーが片付ける
+	waitClosed(t, &prepared, &closed)
+}
+
+func waitClosed(t *testing.T, prepared, closed *int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(closed) != atomic.LoadInt32(prepared) {
+		if time.Now().After(deadline) {
+			t.Fatalf("frames are leaked: prepared %d, closed %d", atomic.LoadInt32(prepared), atomic.LoadInt32(closed))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPlaybackPipelineClear(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var prepared, closed int32
+	started := make(chan string)
+	p := startPlaybackPipeline(ctx,
+		func(args generateVoiceArgs) pcmFrames {
+			atomic.AddInt32(&prepared, 1)
+			return &fakeFrames{closed: &closed}
+		},
+		func(ctx context.Context, content string, frames pcmFrames) {
+			// 長い文章のように止められるまで再生し続ける
+			started <- content
+			<-ctx.Done()
+		},
+	)
+	d := &DiscordVoiceConnection{pipeline: p, done: ctx.Done(), cancel: cancel}
+	defer d.Quit()
+
+	expectStarted := func(expected string) {
+		t.Helper()
+		select {
+		case content := <-started:
+			if content != expected {
+				t.Fatalf("unexpected playback: %s (expected %s)", content, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s is not played", expected)
+		}
+	}
+
+	for i, owner := range []string{"a", "b", "a", "b"} {
+		d.SpeakUtterance(Utterance{Content: fmt.Sprint(i), Owner: owner}, false)
+	}
+	expectStarted("0")
+
+	// 再生中のものも含めて a の文章を消す
+	if count := d.ClearUser("a"); count != 2 {
+		t.Errorf("unexpected cleared utterances: %d", count)
+	}
+	expectStarted("1")
+
+	if !d.Skip() {
+		t.Errorf("nothing is skipped")
+	}
+	expectStarted("3")
+
+	if count := d.Clear(); count != 1 {
+		t.Errorf("unexpected cleared utterances: %d", count)
+	}
+	select {
+	case content := <-started:
+		t.Errorf("cleared utterance is played: %s", content)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if d.Skip() {
+		t.Errorf("skipped while nothing is playing")
+	}
+
+	// 消した後に積んだ文章は読み上げる
+	d.SpeakUtterance(Utterance{Content: "4", Owner: "a"}, false)
+	expectStarted("4")
+
+	// お知らせは残してメンバーの文章だけを消す
+	d.SpeakUtterance(Utterance{Content: "5", Owner: "b"}, false)
+	d.SpeakUtterance(Utterance{Content: "6"}, false)
+	if count := d.ClearMessages(); count != 2 {
+		t.Errorf("unexpected cleared utterances: %d", count)
+	}
+	expectStarted("6")
+	d.Clear()
+	waitClosed(t, &prepared, &closed)
+}
+
+func TestPlaybackPipelinePrefetch(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var prepared, closed int32
+	started := make(chan struct{}, 1)
+	p := startPlaybackPipeline(ctx,
+		func(args generateVoiceArgs) pcmFrames {
+			atomic.AddInt32(&prepared, 1)
+			return &fakeFrames{closed: &closed}
+		},
+		func(ctx context.Context, content string, frames pcmFrames) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+		},
+	)
+	d := &DiscordVoiceConnection{pipeline: p, done: ctx.Done(), cancel: cancel}
+
+	// 長い文章を貼られても呼び出し側は待たされない
+	for i := 0; i < 100; i++ {
+		d.Speak(0, false, fmt.Sprint(i))
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond)
+
+	// 変換済みの音声は再生中のものと先読みの分だけ
+	if n := atomic.LoadInt32(&prepared); n > prefetchDepth+2 {
+		t.Errorf("too many prepared audio: %d", n)
+	}
+	if count := d.Clear(); count != 100 {
+		t.Errorf("unexpected cleared utterances: %d", count)
+	}
+	d.Quit()
+	waitClosed(t, &prepared, &closed)
+}
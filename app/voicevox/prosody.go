@@ -0,0 +1,43 @@
+package voicevox
+
+// Prosody overrides the parameters of an AudioQuery.
+// Nil fields keep the values estimated by the engine.
+type Prosody struct {
+	SpeedScale        *float64 `json:"speedScale,omitempty"`
+	PitchScale        *float64 `json:"pitchScale,omitempty"`
+	IntonationScale   *float64 `json:"intonationScale,omitempty"`
+	VolumeScale       *float64 `json:"volumeScale,omitempty"`
+	PrePhonemeLength  *float64 `json:"prePhonemeLength,omitempty"`
+	PostPhonemeLength *float64 `json:"postPhonemeLength,omitempty"`
+}
+
+// ProsodyValue returns a pointer to the value for the fields of Prosody.
+func ProsodyValue(value float64) *float64 {
+	return &value
+}
+
+func (p Prosody) IsZero() bool {
+	return p.SpeedScale == nil && p.PitchScale == nil && p.IntonationScale == nil &&
+		p.VolumeScale == nil && p.PrePhonemeLength == nil && p.PostPhonemeLength == nil
+}
+
+func (p Prosody) Apply(query *AudioQuery) {
+	if p.SpeedScale != nil {
+		query.SpeedScale = *p.SpeedScale
+	}
+	if p.PitchScale != nil {
+		query.PitchScale = *p.PitchScale
+	}
+	if p.IntonationScale != nil {
+		query.IntonationScale = *p.IntonationScale
+	}
+	if p.VolumeScale != nil {
+		query.VolumeScale = *p.VolumeScale
+	}
+	if p.PrePhonemeLength != nil {
+		query.PrePhonemeLength = *p.PrePhonemeLength
+	}
+	if p.PostPhonemeLength != nil {
+		query.PostPhonemeLength = *p.PostPhonemeLength
+	}
+}
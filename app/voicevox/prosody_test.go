@@ -0,0 +1,22 @@
+package voicevox
+
+import "testing"
+
+func TestProsodyApply(t *testing.T) {
+
+	query := &AudioQuery{SpeedScale: 1, PitchScale: 0.1, IntonationScale: 1, VolumeScale: 1}
+	prosody := Prosody{
+		SpeedScale: ProsodyValue(1.2),
+		// 0 も指定した値として扱う
+		PitchScale:      ProsodyValue(0),
+		IntonationScale: ProsodyValue(0),
+	}
+	if prosody.IsZero() || !(Prosody{}).IsZero() {
+		t.Errorf("unexpected IsZero")
+	}
+
+	prosody.Apply(query)
+	if query.SpeedScale != 1.2 || query.PitchScale != 0 || query.IntonationScale != 0 || query.VolumeScale != 1 {
+		t.Errorf("unexpected query: %+v", query)
+	}
+}
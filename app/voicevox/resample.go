@@ -0,0 +1,137 @@
+package voicevox
+
+import (
+	"math"
+)
+
+const (
+	// 片側のゼロ交差の数 (多いほど遷移帯域が狭くなる)
+	resampleZeroCrossings = 32
+	// カイザー窓の β (阻止域の減衰はおよそ 80 dB)
+	resampleKaiserBeta = 8.0
+	// 通過させる帯域の、変換後のナイキスト周波数に対する割合
+	resampleRolloff = 0.95
+	// 位相の数がこれを超える変換比ではフィルタを表にせず都度計算する
+	maxResamplePhases = 4096
+)
+
+// resampler converts the sample rate with a Kaiser windowed sinc filter.
+type resampler struct {
+	// 出力1サンプルごとに入力が step/phases サンプル進む
+	step, phases int
+	// 入力のナイキスト周波数を 1 としたカットオフ周波数
+	cutoff float64
+	// フィルタの片側の長さ (入力のサンプル数)
+	halfWidth int
+	// [phase][tap] (位相が多い場合は nil)
+	table [][]float32
+}
+
+func newResampler(from, to int) *resampler {
+	g := gcd(from, to)
+	r := &resampler{
+		step:   from / g,
+		phases: to / g,
+		cutoff: resampleRolloff * math.Min(1, float64(to)/float64(from)),
+	}
+	r.halfWidth = int(math.Ceil(resampleZeroCrossings / r.cutoff))
+
+	if r.phases <= maxResamplePhases {
+		r.table = make([][]float32, r.phases)
+		for phase := range r.table {
+			r.table[phase] = r.taps(phase)
+		}
+	}
+	return r
+}
+
+// taps returns the filter coefficients for the output sample at the phase,
+// normalized so that a constant signal keeps its level.
+func (r *resampler) taps(phase int) []float32 {
+	frac := float64(phase) / float64(r.phases)
+	taps := make([]float32, 2*r.halfWidth)
+	sum := 0.0
+	coefficients := make([]float64, len(taps))
+	for j := range taps {
+		// 出力位置から入力サンプルまでの距離
+		d := frac + float64(r.halfWidth-1-j)
+		coefficients[j] = r.cutoff * sinc(r.cutoff*d) * kaiser(d/float64(r.halfWidth), resampleKaiserBeta)
+		sum += coefficients[j]
+	}
+	for j, c := range coefficients {
+		taps[j] = float32(c / sum)
+	}
+	return taps
+}
+
+// outputLength returns the number of samples resampled from n samples.
+func (r *resampler) outputLength(n int) int {
+	return (n*r.phases + r.step - 1) / r.step
+}
+
+// resample converts the samples of one channel.
+func (r *resampler) resample(in []float32) []float32 {
+	out := make([]float32, r.outputLength(len(in)))
+	for n := range out {
+		position := n * r.step
+		base := position/r.phases - r.halfWidth + 1
+		phase := position % r.phases
+
+		var taps []float32
+		if r.table != nil {
+			taps = r.table[phase]
+		} else {
+			taps = r.taps(phase)
+		}
+
+		// 範囲外の入力は無音として扱う
+		begin, end := 0, len(taps)
+		if base < 0 {
+			begin = -base
+		}
+		if base+end > len(in) {
+			end = len(in) - base
+		}
+		acc := float32(0)
+		for j := begin; j < end; j++ {
+			acc += in[base+j] * taps[j]
+		}
+		out[n] = acc
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser returns the Kaiser window at x in [-1, 1].
+func kaiser(x, beta float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 is the modified Bessel function of the first kind of order zero.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 64; k++ {
+		term *= (x / 2) / float64(k)
+		sum += term * term
+		if term*term < sum*1e-16 {
+			break
+		}
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,74 @@
+package voicevox
+
+import (
+	"math"
+	"testing"
+)
+
+func sineWave(frequency float64, sampleRate, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float32(math.Sin(2 * math.Pi * frequency * float64(i) / float64(sampleRate)))
+	}
+	return out
+}
+
+// rms returns the root mean square of the samples apart from margin samples at both ends.
+func rms(samples []float32, margin int) float64 {
+	sum := 0.0
+	for _, v := range samples[margin : len(samples)-margin] {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(samples)-2*margin))
+}
+
+func TestResample(t *testing.T) {
+
+	for _, testcase := range []struct {
+		from, to  int
+		frequency float64
+	}{
+		{24000, 48000, 1000},
+		{24000, 48000, 10000},
+		{44100, 48000, 3000},
+		{48000, 24000, 5000},
+		// 表を作らない変換比
+		{47993, 48000, 2000},
+	} {
+		r := newResampler(testcase.from, testcase.to)
+		in := sineWave(testcase.frequency, testcase.from, testcase.from/10)
+		out := r.resample(in)
+
+		if expected := int(math.Ceil(float64(len(in)) * float64(testcase.to) / float64(testcase.from))); len(out) != expected {
+			t.Errorf("%d -> %d: unexpected length: %d (expected %d)", testcase.from, testcase.to, len(out), expected)
+			continue
+		}
+
+		// 通過域の波形は理想的な正弦波とほぼ一致する
+		ideal := sineWave(testcase.frequency, testcase.to, len(out))
+		diff := make([]float32, len(out))
+		for i := range out {
+			diff[i] = out[i] - ideal[i]
+		}
+		if e := rms(diff, 200); e > 1e-3 {
+			t.Errorf("%d -> %d (%.0f Hz): too large error: %g", testcase.from, testcase.to, testcase.frequency, e)
+		}
+	}
+}
+
+func TestResampleAntiAliasing(t *testing.T) {
+
+	// 変換後のナイキスト周波数 (12 kHz) を超える成分は落とす
+	r := newResampler(48000, 24000)
+	out := r.resample(sineWave(18000, 48000, 4800))
+	if level := rms(out, 100); level > 1e-3 {
+		t.Errorf("aliasing remains: %g", level)
+	}
+}
+
+func TestResampleEmpty(t *testing.T) {
+
+	if out := newResampler(24000, 48000).resample(nil); len(out) != 0 {
+		t.Errorf("unexpected output: %v", out)
+	}
+}
@@ -0,0 +1,180 @@
+package voicevox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// errNotWAV is returned by decodeWAV for data which is not a RIFF WAVE file.
+var errNotWAV = errors.New("not a wav file")
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xfffe
+)
+
+// pcmAudio is decoded audio. Samples are interleaved and scaled to [-1, 1].
+type pcmAudio struct {
+	sampleRate int
+	channels   int
+	samples    []float32
+}
+
+// frames returns the number of samples per channel.
+func (a *pcmAudio) frames() int {
+	return len(a.samples) / a.channels
+}
+
+// channel returns the samples of the channel.
+func (a *pcmAudio) channel(ch int) []float32 {
+	out := make([]float32, a.frames())
+	for i := range out {
+		out[i] = a.samples[i*a.channels+ch]
+	}
+	return out
+}
+
+// isWAV reports whether the header is of a RIFF WAVE file.
+func isWAV(header []byte) bool {
+	return len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE"
+}
+
+// decodeWAV reads a RIFF WAVE file of integer PCM (8, 16, 24 or 32 bits) or 32 and 64 bits float.
+func decodeWAV(r io.Reader) (*pcmAudio, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errNotWAV
+		}
+		return nil, fmt.Errorf("cannot read wav header: %w", err)
+	}
+	if !isWAV(header) {
+		return nil, errNotWAV
+	}
+
+	var (
+		format        uint16
+		channels      int
+		sampleRate    int
+		bitsPerSample int
+		hasFormat     bool
+	)
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return nil, fmt.Errorf("cannot find wav data chunk: %w", err)
+		}
+		id := string(chunkHeader[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("wav fmt chunk is too short: %d", size)
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, fmt.Errorf("cannot read wav fmt chunk: %w", err)
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			// WAVE_FORMAT_EXTENSIBLE はサブフォーマットの GUID の先頭2バイトが形式を表す
+			if format == wavFormatExtensible {
+				if size < 26 {
+					return nil, fmt.Errorf("wav extensible fmt chunk is too short: %d", size)
+				}
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			hasFormat = true
+
+		case "data":
+			if !hasFormat {
+				return nil, fmt.Errorf("wav data chunk appears before fmt chunk")
+			}
+			if channels <= 0 || sampleRate <= 0 {
+				return nil, fmt.Errorf("invalid wav format: %d channels, %d Hz", channels, sampleRate)
+			}
+			// ストリームで書き出された WAV はサイズが 0 や最大値になっていることがあるので最後まで読む
+			dataReader := io.LimitReader(r, size)
+			if size == 0 || size == math.MaxUint32 {
+				dataReader = r
+			}
+			data, err := io.ReadAll(dataReader)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read wav data chunk: %w", err)
+			}
+			samples, err := decodeSamples(data, format, bitsPerSample)
+			if err != nil {
+				return nil, err
+			}
+			// 途中で切れたフレームは捨てる
+			samples = samples[:len(samples)/channels*channels]
+			return &pcmAudio{
+				sampleRate: sampleRate,
+				channels:   channels,
+				samples:    samples,
+			}, nil
+
+		default:
+			// チャンクは偶数バイトに揃えられている
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, fmt.Errorf("cannot skip wav %q chunk: %w", id, err)
+			}
+			continue
+		}
+
+		if size%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return nil, fmt.Errorf("cannot skip wav padding: %w", err)
+			}
+		}
+	}
+}
+
+func decodeSamples(data []byte, format uint16, bitsPerSample int) ([]float32, error) {
+	bytesPerSample := bitsPerSample / 8
+	if bitsPerSample%8 != 0 || bytesPerSample == 0 {
+		return nil, fmt.Errorf("unsupported wav bits per sample: %d", bitsPerSample)
+	}
+	samples := make([]float32, len(data)/bytesPerSample)
+
+	switch {
+	case format == wavFormatPCM && bitsPerSample == 8:
+		// 8 ビットだけは符号なし
+		for i := range samples {
+			samples[i] = (float32(data[i]) - 128) / 128
+		}
+	case format == wavFormatPCM && bitsPerSample == 16:
+		for i := range samples {
+			samples[i] = float32(int16(binary.LittleEndian.Uint16(data[i*2:]))) / (1 << 15)
+		}
+	case format == wavFormatPCM && bitsPerSample == 24:
+		for i := range samples {
+			b := data[i*3:]
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			samples[i] = float32(v) / (1 << 23)
+		}
+	case format == wavFormatPCM && bitsPerSample == 32:
+		for i := range samples {
+			samples[i] = float32(float64(int32(binary.LittleEndian.Uint32(data[i*4:]))) / (1 << 31))
+		}
+	case format == wavFormatFloat && bitsPerSample == 32:
+		if err := binary.Read(bytes.NewReader(data[:len(samples)*4]), binary.LittleEndian, samples); err != nil {
+			return nil, fmt.Errorf("cannot read wav samples: %w", err)
+		}
+	case format == wavFormatFloat && bitsPerSample == 64:
+		for i := range samples {
+			samples[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:])))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported wav format: 0x%04x (%d bits)", format, bitsPerSample)
+	}
+	return samples, nil
+}
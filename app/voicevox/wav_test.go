@@ -0,0 +1,150 @@
+package voicevox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// makeWAV builds a WAV file with an extra chunk before the data chunk.
+func makeWAV(format uint16, channels, sampleRate, bitsPerSample int, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	fmtChunk := &bytes.Buffer{}
+	binary.Write(fmtChunk, binary.LittleEndian, format)
+	binary.Write(fmtChunk, binary.LittleEndian, uint16(channels))
+	binary.Write(fmtChunk, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(fmtChunk, binary.LittleEndian, uint32(sampleRate*channels*bitsPerSample/8))
+	binary.Write(fmtChunk, binary.LittleEndian, uint16(channels*bitsPerSample/8))
+	binary.Write(fmtChunk, binary.LittleEndian, uint16(bitsPerSample))
+	if format == wavFormatExtensible {
+		binary.Write(fmtChunk, binary.LittleEndian, uint16(22))
+		binary.Write(fmtChunk, binary.LittleEndian, uint16(bitsPerSample))
+		binary.Write(fmtChunk, binary.LittleEndian, uint32(0))
+		binary.Write(fmtChunk, binary.LittleEndian, uint16(wavFormatPCM))
+		fmtChunk.Write(make([]byte, 14))
+	}
+
+	body := &bytes.Buffer{}
+	body.WriteString("WAVE")
+	body.WriteString("fmt ")
+	binary.Write(body, binary.LittleEndian, uint32(fmtChunk.Len()))
+	body.Write(fmtChunk.Bytes())
+	// 奇数バイトのチャンクはパディングが入る
+	body.WriteString("LIST")
+	binary.Write(body, binary.LittleEndian, uint32(3))
+	body.Write([]byte{1, 2, 3, 0})
+	body.WriteString("data")
+	binary.Write(body, binary.LittleEndian, uint32(len(data)))
+	body.Write(data)
+
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func TestDecodeWAV(t *testing.T) {
+
+	pcm16 := &bytes.Buffer{}
+	binary.Write(pcm16, binary.LittleEndian, []int16{0, 16384, -32768, 32767})
+	float32le := &bytes.Buffer{}
+	binary.Write(float32le, binary.LittleEndian, []float32{0, 0.5, -1, 1})
+
+	for _, testcase := range []struct {
+		name          string
+		format        uint16
+		channels      int
+		bitsPerSample int
+		data          []byte
+		expected      []float32
+	}{
+		{"pcm16 mono", wavFormatPCM, 1, 16, pcm16.Bytes(), []float32{0, 0.5, -1, 32767.0 / 32768}},
+		{"pcm16 stereo", wavFormatPCM, 2, 16, pcm16.Bytes(), []float32{0, 0.5, -1, 32767.0 / 32768}},
+		{"pcm8", wavFormatPCM, 1, 8, []byte{128, 192, 0}, []float32{0, 0.5, -1}},
+		{"pcm24", wavFormatPCM, 1, 24, []byte{0, 0, 0x40, 0, 0, 0x80}, []float32{0.5, -1}},
+		{"float32", wavFormatFloat, 1, 32, float32le.Bytes(), []float32{0, 0.5, -1, 1}},
+		{"extensible", wavFormatExtensible, 1, 16, pcm16.Bytes(), []float32{0, 0.5, -1, 32767.0 / 32768}},
+		// 途中で切れたフレームは捨てる
+		{"partial frame", wavFormatPCM, 2, 16, pcm16.Bytes()[:6], []float32{0, 0.5}},
+	} {
+		audio, err := decodeWAV(bytes.NewReader(makeWAV(testcase.format, testcase.channels, 24000, testcase.bitsPerSample, testcase.data)))
+		if err != nil {
+			t.Errorf("%s: %v", testcase.name, err)
+			continue
+		}
+		if audio.sampleRate != 24000 || audio.channels != testcase.channels {
+			t.Errorf("%s: unexpected format: %d Hz, %d channels", testcase.name, audio.sampleRate, audio.channels)
+		}
+		if len(audio.samples) != len(testcase.expected) {
+			t.Errorf("%s: unexpected samples: %v", testcase.name, audio.samples)
+			continue
+		}
+		for i, expected := range testcase.expected {
+			if math.Abs(float64(audio.samples[i]-expected)) > 1e-6 {
+				t.Errorf("%s: unexpected samples[%d]: %f", testcase.name, i, audio.samples[i])
+			}
+		}
+	}
+}
+
+func TestDecodeWAVErrors(t *testing.T) {
+
+	if _, err := decodeWAV(strings.NewReader("ID3\x03\x00\x00\x00\x00\x00\x00\x00\x00")); err != errNotWAV {
+		t.Errorf("unexpected error for mp3: %v", err)
+	}
+	if _, err := decodeWAV(strings.NewReader("RIFF")); err != errNotWAV {
+		t.Errorf("unexpected error for short input: %v", err)
+	}
+	if _, err := decodeWAV(bytes.NewReader(makeWAV(0x0055, 1, 24000, 16, []byte{0, 0}))); err == nil {
+		t.Errorf("decoded unsupported format")
+	}
+	truncated := makeWAV(wavFormatPCM, 1, 24000, 16, nil)
+	if _, err := decodeWAV(bytes.NewReader(truncated[:30])); err == nil {
+		t.Errorf("decoded truncated wav")
+	}
+}
+
+func TestDiscordPCM(t *testing.T) {
+
+	// VOICEVOX と同じ 24 kHz モノラル
+	audio := &pcmAudio{sampleRate: 24000, channels: 1, samples: make([]float32, 1000)}
+	for i := range audio.samples {
+		audio.samples[i] = 0.5
+	}
+
+	pcm := discordPCM(audio)
+	if len(pcm)%(frameSize*channels) != 0 || len(pcm) < 2000*channels {
+		t.Fatalf("unexpected length: %d", len(pcm))
+	}
+	for i := 0; i < len(pcm); i += channels {
+		if pcm[i] != pcm[i+1] {
+			t.Fatalf("left and right differ at %d: %d, %d", i, pcm[i], pcm[i+1])
+		}
+	}
+	// フィルタの端を除けば元の音量のまま
+	if v := pcm[1000*channels]; math.Abs(float64(v)-0.5*math.MaxInt16) > 2 {
+		t.Errorf("unexpected level: %d", v)
+	}
+	// 最後のフレームの残りは無音
+	if v := pcm[len(pcm)-1]; v != 0 {
+		t.Errorf("unexpected padding: %d", v)
+	}
+
+	frames := &bufferedFrames{samples: pcm}
+	count := 0
+	for {
+		frame, err := frames.next()
+		if err != nil {
+			break
+		}
+		if len(frame) != frameSize*channels {
+			t.Errorf("unexpected frame size: %d", len(frame))
+		}
+		count++
+	}
+	if count != len(pcm)/(frameSize*channels) {
+		t.Errorf("unexpected frames: %d", count)
+	}
+}
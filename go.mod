@@ -5,7 +5,6 @@ go 1.19
 require (
 	github.com/bwmarrin/dgvoice v0.0.0-20210225172318-caaac756e02e
 	github.com/bwmarrin/discordgo v0.26.1
-	github.com/gammazero/deque v0.2.1
 	github.com/google/uuid v1.3.0
 	github.com/rs/zerolog v1.28.0
 	go.uber.org/zap v1.23.0
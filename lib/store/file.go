@@ -0,0 +1,114 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File is a JSON document persisted on the local disk. (一度読んだらメモリに置き、変わったときだけ書く)
+type File[T any] struct {
+	lock sync.Mutex
+	path string
+	// 最後に読み書きした内容 (nil なら未読込)
+	cache []byte
+}
+
+func NewFile[T any](path string) *File[T] {
+	return &File[T]{path: path}
+}
+
+// Load reads the document. A missing file is loaded as the zero value.
+func (f *File[T]) Load() (T, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.load()
+}
+
+// Update loads the document, applies fn and saves the result.
+// Nothing is saved if fn returns an error.
+func (f *File[T]) Update(fn func(*T) error) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(&v); err != nil {
+		return err
+	}
+	return f.save(v)
+}
+
+func (f *File[T]) load() (v T, err error) {
+	if f.cache != nil {
+		// 毎回デコードして呼び出し側の変更がキャッシュに及ばないようにする
+		if err := json.Unmarshal(f.cache, &v); err != nil {
+			return v, fmt.Errorf("cannot parse store file: %s: %w", f.path, err)
+		}
+		return v, nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		f.cache, err = encode(v)
+		return v, err
+	} else if err != nil {
+		return v, fmt.Errorf("cannot read store file: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return v, fmt.Errorf("cannot parse store file: %s: %w", f.path, err)
+	}
+	f.cache = b
+	return v, nil
+}
+
+func encode(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode store file: %w", err)
+	}
+	return b, nil
+}
+
+// 書き込み途中で落ちても壊れないように一時ファイルを置き換える
+func (f *File[T]) save(v T) error {
+	b, err := encode(v)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b, f.cache) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("cannot make store directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write store file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write store file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("cannot replace store file: %w", err)
+	}
+	f.cache = b
+	return nil
+}
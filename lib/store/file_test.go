@@ -0,0 +1,88 @@
+package store_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/streamwest-1629/chatspace/lib/store"
+)
+
+func TestFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nested", "store.json")
+	file := store.NewFile[map[string]int](path)
+
+	if v, err := file.Load(); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 0 {
+		t.Fatalf("expected empty document, got %v", v)
+	}
+
+	if err := file.Update(func(v *map[string]int) error {
+		*v = map[string]int{"a": 1}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errCanceled := errors.New("canceled")
+	if err := file.Update(func(v *map[string]int) error {
+		(*v)["a"] = 2
+		return errCanceled
+	}); err != errCanceled {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+
+	reopened := store.NewFile[map[string]int](path)
+	if v, err := reopened.Load(); err != nil {
+		t.Fatal(err)
+	} else if v["a"] != 1 {
+		t.Fatalf("expected saved value 1, got %v", v)
+	}
+}
+
+func TestFileWritesOnlyChanges(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "store.json")
+	file := store.NewFile[map[string]int](path)
+
+	if err := file.Update(func(v *map[string]int) error {
+		*v = map[string]int{"a": 1}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 変更のない更新は書き込まない
+	if err := file.Update(func(v *map[string]int) error {
+		(*v)["a"] = 1
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unchanged document is written: %v", err)
+	}
+
+	// 読み込みはメモリから
+	if v, err := file.Load(); err != nil {
+		t.Fatal(err)
+	} else if v["a"] != 1 {
+		t.Fatalf("expected cached value 1, got %v", v)
+	}
+
+	if err := file.Update(func(v *map[string]int) error {
+		(*v)["a"] = 2
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("changed document is not written: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package voicevox
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+)
+
+var stubFunction byte
+
+// stubSymbols resolves only the given symbols, like dlsym for a library exporting them.
+func stubSymbols(symbols ...string) func(string) (unsafe.Pointer, error) {
+	table := map[string]unsafe.Pointer{}
+	for _, symbol := range symbols {
+		table[symbol] = unsafe.Pointer(&stubFunction)
+	}
+	return func(symbolName string) (unsafe.Pointer, error) {
+		if symbol, exist := table[symbolName]; exist {
+			return symbol, nil
+		}
+		return nil, fmt.Errorf("cannot found symbol: %s", symbolName)
+	}
+}
+
+var (
+	legacySymbols = []string{
+		"initialize", "load_model", "is_model_loaded", "finalize", "metas",
+		"last_error_message", "voicevox_load_openjtalk_dict", "voicevox_tts", "voicevox_wav_free",
+	}
+	synthesizerSymbols = []string{
+		"voicevox_get_onnxruntime_lib_versioned_filename", "voicevox_onnxruntime_load_once",
+		"voicevox_open_jtalk_rc_new", "voicevox_open_jtalk_rc_delete",
+		"voicevox_synthesizer_new", "voicevox_synthesizer_delete",
+		"voicevox_voice_model_file_open", "voicevox_voice_model_file_delete",
+		"voicevox_synthesizer_load_voice_model", "voicevox_synthesizer_create_metas_json",
+		"voicevox_json_free", "voicevox_synthesizer_tts", "voicevox_synthesizer_create_audio_query",
+		"voicevox_synthesizer_synthesis", "voicevox_error_result_to_message", "voicevox_wav_free",
+	}
+	// 0.15 は合成器APIを持つが onnxruntime を読み込む関数がない
+	synthesizer015Symbols = []string{
+		"voicevox_open_jtalk_rc_new", "voicevox_open_jtalk_rc_delete",
+		"voicevox_synthesizer_new", "voicevox_synthesizer_delete",
+		"voicevox_synthesizer_tts", "voicevox_wav_free",
+	}
+)
+
+func TestDetectABI(t *testing.T) {
+
+	for _, testcase := range []struct {
+		name     string
+		symbols  []string
+		expected ABI
+		err      error
+	}{
+		{"0.13", legacySymbols, ABILegacy, nil},
+		{"0.16", synthesizerSymbols, ABISynthesizer, nil},
+		// 合成器APIがあればそちらを使う
+		{"both", append(append([]string{}, legacySymbols...), synthesizerSymbols...), ABISynthesizer, nil},
+		{"0.15", synthesizer015Symbols, 0, ErrUnsupportedABI},
+		{"unknown", []string{"voicevox_wav_free"}, 0, ErrUnsupportedABI},
+	} {
+		abi, err := detectABI(stubSymbols(testcase.symbols...))
+		if err != testcase.err {
+			t.Errorf("%s: unexpected error: %v", testcase.name, err)
+		} else if err == nil && abi != testcase.expected {
+			t.Errorf("%s: unexpected abi: %s", testcase.name, abi)
+		}
+	}
+}
+
+func TestLoadSymbols(t *testing.T) {
+
+	if client, err := loadLegacy(stubSymbols(legacySymbols...)); err != nil {
+		t.Errorf("cannot load legacy symbols: %v", err)
+	} else if client.ABI() != ABILegacy || client.text2Speech == nil || client.freeWave == nil {
+		t.Errorf("legacy symbols are not set: %+v", client)
+	}
+
+	s, err := loadSynthesizer(stubSymbols(synthesizerSymbols...), "dict")
+	if err != nil {
+		t.Fatalf("cannot load synthesizer symbols: %v", err)
+	}
+	if s.openJtalkNew == nil || s.openJtalkDelete == nil || s.synthesis == nil || s.dictPath != "dict" {
+		t.Errorf("synthesizer symbols are not set: %+v", s)
+	}
+
+	// 足りないシンボルがあれば読み込まない
+	if _, err := loadLegacy(stubSymbols(legacySymbols[1:]...)); err == nil {
+		t.Errorf("loaded legacy symbols without initialize")
+	}
+	if _, err := loadSynthesizer(stubSymbols(synthesizerSymbols[1:]...), "dict"); err == nil {
+		t.Errorf("loaded synthesizer symbols without onnxruntime")
+	}
+}
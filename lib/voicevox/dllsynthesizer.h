@@ -0,0 +1,109 @@
+#pragma once
+
+#if __cplusplus
+#include <cstdint>
+#else
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+#endif
+
+// Layouts of voicevox_core 0.16 (synthesizer API) option structures.
+
+typedef struct {
+  const char *filename;
+} VoicevoxLoadOnnxruntimeOptions;
+
+typedef struct {
+  int32_t acceleration_mode;
+  uint16_t cpu_num_threads;
+} VoicevoxInitializeOptions;
+
+typedef struct {
+  bool enable_interrogative_upspeak;
+} VoicevoxTtsOptions;
+
+typedef struct {
+  bool enable_interrogative_upspeak;
+} VoicevoxSynthesisOptions;
+
+const char *synthOnnxruntimeFilename(void *ptr) {
+  const char *(*fn)() = ptr;
+  return fn();
+}
+
+int32_t synthOnnxruntimeLoad(void *ptr, const char *filename, void **out) {
+  int32_t (*fn)(VoicevoxLoadOnnxruntimeOptions, void **) = ptr;
+  VoicevoxLoadOnnxruntimeOptions options = {filename};
+  return fn(options, out);
+}
+
+int32_t synthOpenJtalkNew(void *ptr, const char *dictPath, void **out) {
+  int32_t (*fn)(const char *, void **) = ptr;
+  return fn(dictPath, out);
+}
+
+void synthDelete(void *ptr, void *handle) {
+  void (*fn)(void *) = ptr;
+  fn(handle);
+}
+
+int32_t synthNew(void *ptr, void *onnxruntime, void *openJtalk,
+                 int32_t accelerationMode, uint16_t numThreads, void **out) {
+  int32_t (*fn)(void *, void *, VoicevoxInitializeOptions, void **) = ptr;
+  VoicevoxInitializeOptions options = {accelerationMode, numThreads};
+  return fn(onnxruntime, openJtalk, options, out);
+}
+
+int32_t synthModelOpen(void *ptr, const char *path, void **out) {
+  int32_t (*fn)(const char *, void **) = ptr;
+  return fn(path, out);
+}
+
+int32_t synthLoadModel(void *ptr, void *synthesizer, void *model) {
+  int32_t (*fn)(void *, void *) = ptr;
+  return fn(synthesizer, model);
+}
+
+char *synthMetas(void *ptr, void *synthesizer) {
+  char *(*fn)(void *) = ptr;
+  return fn(synthesizer);
+}
+
+void synthFreeJson(void *ptr, char *json) {
+  void (*fn)(char *) = ptr;
+  fn(json);
+}
+
+uint8_t *synthTts(void *ptr, void *synthesizer, const char *text,
+                  uint32_t styleId, size_t *outputSize, int32_t *result) {
+  int32_t (*fn)(void *, const char *, uint32_t, VoicevoxTtsOptions, size_t *,
+                uint8_t **) = ptr;
+  VoicevoxTtsOptions options = {true};
+  uint8_t *outputWav = NULL;
+  (*result) = fn(synthesizer, text, styleId, options, outputSize, &outputWav);
+  return outputWav;
+}
+
+const char *synthErrMsg(void *ptr, int32_t result) {
+  const char *(*fn)(int32_t) = ptr;
+  return fn(result);
+}
+
+int32_t synthAudioQuery(void *ptr, void *synthesizer, const char *text,
+                        uint32_t styleId, char **outputJson) {
+  int32_t (*fn)(void *, const char *, uint32_t, char **) = ptr;
+  return fn(synthesizer, text, styleId, outputJson);
+}
+
+uint8_t *synthSynthesis(void *ptr, void *synthesizer, const char *queryJson,
+                        uint32_t styleId, size_t *outputSize,
+                        int32_t *result) {
+  int32_t (*fn)(void *, const char *, uint32_t, VoicevoxSynthesisOptions,
+                size_t *, uint8_t **) = ptr;
+  VoicevoxSynthesisOptions options = {true};
+  uint8_t *outputWav = NULL;
+  (*result) =
+      fn(synthesizer, queryJson, styleId, options, outputSize, &outputWav);
+  return outputWav;
+}
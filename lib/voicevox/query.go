@@ -0,0 +1,125 @@
+package voicevox
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// AudioQuery is the editable intermediate representation between text and speech.
+// Field names follow the JSON of voicevox_core (the same as VOICEVOX ENGINE).
+type AudioQuery struct {
+	AccentPhrases      []AccentPhrase `json:"accent_phrases"`
+	SpeedScale         float64        `json:"speedScale"`
+	PitchScale         float64        `json:"pitchScale"`
+	IntonationScale    float64        `json:"intonationScale"`
+	VolumeScale        float64        `json:"volumeScale"`
+	PrePhonemeLength   float64        `json:"prePhonemeLength"`
+	PostPhonemeLength  float64        `json:"postPhonemeLength"`
+	OutputSamplingRate int            `json:"outputSamplingRate"`
+	OutputStereo       bool           `json:"outputStereo"`
+	Kana               *string        `json:"kana,omitempty"`
+	// 構造体にないフィールド (Synthesis にそのまま渡す)
+	extra map[string]json.RawMessage
+}
+
+type AccentPhrase struct {
+	Moras           []Mora `json:"moras"`
+	Accent          int    `json:"accent"`
+	PauseMora       *Mora  `json:"pause_mora"`
+	IsInterrogative bool   `json:"is_interrogative"`
+	extra           map[string]json.RawMessage
+}
+
+type Mora struct {
+	Text            string   `json:"text"`
+	Consonant       *string  `json:"consonant"`
+	ConsonantLength *float64 `json:"consonant_length"`
+	Vowel           string   `json:"vowel"`
+	VowelLength     float64  `json:"vowel_length"`
+	Pitch           float64  `json:"pitch"`
+	extra           map[string]json.RawMessage
+}
+
+var ErrNotSupported = errors.New("not supported by the loaded library")
+
+// メソッドを持たない型に変換して既知のフィールドだけを読み書きする
+type (
+	audioQueryFields   AudioQuery
+	accentPhraseFields AccentPhrase
+	moraFields         Mora
+)
+
+func (q *AudioQuery) UnmarshalJSON(data []byte) (err error) {
+	q.extra, err = unmarshalKeepingExtra(data, (*audioQueryFields)(q))
+	return err
+}
+
+func (q AudioQuery) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(audioQueryFields(q), q.extra)
+}
+
+func (a *AccentPhrase) UnmarshalJSON(data []byte) (err error) {
+	a.extra, err = unmarshalKeepingExtra(data, (*accentPhraseFields)(a))
+	return err
+}
+
+func (a AccentPhrase) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(accentPhraseFields(a), a.extra)
+}
+
+func (m *Mora) UnmarshalJSON(data []byte) (err error) {
+	m.extra, err = unmarshalKeepingExtra(data, (*moraFields)(m))
+	return err
+}
+
+func (m Mora) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(moraFields(m), m.extra)
+}
+
+// unmarshalKeepingExtra decodes data into fields and returns the members unknown to it.
+func unmarshalKeepingExtra(data []byte, fields interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, fields); err != nil {
+		return nil, err
+	}
+	extra := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return nil, err
+	}
+	for _, name := range jsonNames(reflect.TypeOf(fields).Elem()) {
+		delete(extra, name)
+	}
+	if len(extra) == 0 {
+		return nil, nil
+	}
+	return extra, nil
+}
+
+// marshalWithExtra encodes fields and adds the members kept by unmarshalKeepingExtra.
+func marshalWithExtra(fields interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(fields)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for name, value := range extra {
+		if _, exist := members[name]; !exist {
+			members[name] = value
+		}
+	}
+	return json.Marshal(members)
+}
+
+func jsonNames(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		if tag, exist := t.Field(i).Tag.Lookup("json"); exist {
+			names = append(names, strings.Split(tag, ",")[0])
+		}
+	}
+	return names
+}
@@ -0,0 +1,69 @@
+package voicevox
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// voicevox_core 0.16 の AudioQuery (pauseLength などは構造体にないフィールド)
+const sampleQuery = `{
+	"accent_phrases": [{
+		"moras": [
+			{"text": "コ", "consonant": "k", "consonant_length": 0.05, "vowel": "o", "vowel_length": 0.1, "pitch": 5.5},
+			{"text": "ン", "consonant": null, "consonant_length": null, "vowel": "N", "vowel_length": 0.08, "pitch": 5.6, "future": 1}
+		],
+		"accent": 1,
+		"pause_mora": null,
+		"is_interrogative": false
+	}],
+	"speedScale": 1.0,
+	"pitchScale": 0.0,
+	"intonationScale": 1.0,
+	"volumeScale": 1.0,
+	"prePhonemeLength": 0.1,
+	"postPhonemeLength": 0.1,
+	"pauseLength": null,
+	"pauseLengthScale": 1.0,
+	"outputSamplingRate": 24000,
+	"outputStereo": false,
+	"kana": "コ'ン"
+}`
+
+func TestAudioQueryRoundTrip(t *testing.T) {
+
+	query := &AudioQuery{}
+	if err := json.Unmarshal([]byte(sampleQuery), query); err != nil {
+		t.Fatal(err)
+	}
+	if query.OutputSamplingRate != 24000 || query.PrePhonemeLength != 0.1 || len(query.AccentPhrases) != 1 ||
+		len(query.AccentPhrases[0].Moras) != 2 || query.AccentPhrases[0].Moras[0].Text != "コ" {
+		t.Fatalf("unexpected query: %+v", query)
+	}
+
+	query.SpeedScale = 1.5
+	query.IntonationScale = 0
+	data, err := json.Marshal(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := map[string]interface{}{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		t.Fatal(err)
+	}
+	// 編集した値はコアの名前で書き出す
+	if members["speedScale"] != 1.5 || members["intonationScale"] != 0.0 {
+		t.Errorf("edited scales are not written: %s", data)
+	}
+	// 知らないフィールドも残す
+	if members["pauseLengthScale"] != 1.0 || members["kana"] != "コ'ン" {
+		t.Errorf("unknown fields are dropped: %s", data)
+	}
+	if _, exist := members["pauseLength"]; !exist {
+		t.Errorf("null field is dropped: %s", data)
+	}
+	mora := members["accent_phrases"].([]interface{})[0].(map[string]interface{})["moras"].([]interface{})[1].(map[string]interface{})
+	if mora["future"] != 1.0 || mora["consonant"] != nil {
+		t.Errorf("unexpected mora: %v", mora)
+	}
+}
@@ -0,0 +1,265 @@
+package voicevox
+
+import (
+	/*
+		#cgo CPPFLAGS: -I.
+		#cgo LDFLAGS: -ldl
+		#include <stdlib.h>
+		#include <dlfcn.h>
+		#include "dllsynthesizer.h"
+	*/
+	"C"
+)
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"unsafe"
+)
+
+// voicevox_core 0.16+ では音声合成器・OpenJTalk・音声モデル(.vvm)を個別に扱う
+type synthesizerClient struct {
+	onnxruntimeFilename unsafe.Pointer
+	onnxruntimeLoad     unsafe.Pointer
+	openJtalkNew        unsafe.Pointer
+	openJtalkDelete     unsafe.Pointer
+	synthesizerNew      unsafe.Pointer
+	synthesizerDelete   unsafe.Pointer
+	modelOpen           unsafe.Pointer
+	modelDelete         unsafe.Pointer
+	loadModel           unsafe.Pointer
+	getMeta             unsafe.Pointer
+	freeJson            unsafe.Pointer
+	text2Speech         unsafe.Pointer
+	audioQuery          unsafe.Pointer
+	synthesis           unsafe.Pointer
+	getErrMsg           unsafe.Pointer
+
+	dictPath    string
+	openJtalk   unsafe.Pointer
+	synthesizer unsafe.Pointer
+}
+
+const (
+	accelerationModeCPU = 1
+	accelerationModeGPU = 2
+)
+
+func loadSynthesizer(solveSymbol func(string) (unsafe.Pointer, error), dictPath string) (*synthesizerClient, error) {
+
+	s := &synthesizerClient{dictPath: dictPath}
+	for symbolName, ptr := range map[string]*unsafe.Pointer{
+		"voicevox_get_onnxruntime_lib_versioned_filename": &s.onnxruntimeFilename,
+		"voicevox_onnxruntime_load_once":                  &s.onnxruntimeLoad,
+		"voicevox_open_jtalk_rc_new":                      &s.openJtalkNew,
+		"voicevox_open_jtalk_rc_delete":                   &s.openJtalkDelete,
+		"voicevox_synthesizer_new":                        &s.synthesizerNew,
+		"voicevox_synthesizer_delete":                     &s.synthesizerDelete,
+		"voicevox_voice_model_file_open":                  &s.modelOpen,
+		"voicevox_voice_model_file_delete":                &s.modelDelete,
+		"voicevox_synthesizer_load_voice_model":           &s.loadModel,
+		"voicevox_synthesizer_create_metas_json":          &s.getMeta,
+		"voicevox_json_free":                              &s.freeJson,
+		"voicevox_synthesizer_tts":                        &s.text2Speech,
+		"voicevox_synthesizer_create_audio_query":         &s.audioQuery,
+		"voicevox_synthesizer_synthesis":                  &s.synthesis,
+		"voicevox_error_result_to_message":                &s.getErrMsg,
+	} {
+		symbol, err := solveSymbol(symbolName)
+		if err != nil {
+			return nil, err
+		}
+		*ptr = symbol
+	}
+
+	return s, nil
+}
+
+func (s *synthesizerClient) getError(result C.int32_t) error {
+	msg := C.synthErrMsg(s.getErrMsg, result)
+	return fmt.Errorf("voicevox error: %s (code %d)", C.GoString(msg), int(result))
+}
+
+func (s *synthesizerClient) loadDictionary() error {
+	cDictPath := C.CString(s.dictPath)
+	defer C.free(unsafe.Pointer(cDictPath))
+
+	var openJtalk unsafe.Pointer
+	if result := C.synthOpenJtalkNew(s.openJtalkNew, cDictPath, &openJtalk); result != 0 {
+		return s.getError(result)
+	}
+	s.openJtalk = openJtalk
+	return nil
+}
+
+func (s *synthesizerClient) open(config InitConfig) error {
+
+	// 再初期化の場合は古い合成器と辞書を破棄する
+	s.close()
+
+	if err := s.loadDictionary(); err != nil {
+		return err
+	}
+
+	var cFilename *C.char
+	if config.OnnxruntimePath != "" {
+		cFilename = C.CString(config.OnnxruntimePath)
+		defer C.free(unsafe.Pointer(cFilename))
+	} else {
+		cFilename = C.synthOnnxruntimeFilename(s.onnxruntimeFilename)
+	}
+
+	var onnxruntime unsafe.Pointer
+	if result := C.synthOnnxruntimeLoad(s.onnxruntimeLoad, cFilename, &onnxruntime); result != 0 {
+		return s.getError(result)
+	}
+
+	accelerationMode := accelerationModeCPU
+	if config.UseGPU {
+		accelerationMode = accelerationModeGPU
+	}
+
+	var synthesizer unsafe.Pointer
+	if result := C.synthNew(
+		s.synthesizerNew, onnxruntime, s.openJtalk,
+		C.int32_t(accelerationMode), C.uint16_t(config.NumThreads),
+		&synthesizer,
+	); result != 0 {
+		return s.getError(result)
+	}
+	s.synthesizer = synthesizer
+
+	// メタ情報は読み込み済みのモデルしか返さないため，常にすべてのモデルを読み込む
+	modelPaths, err := filepath.Glob(filepath.Join(config.ModelDir, "*.vvm"))
+	if err != nil {
+		return fmt.Errorf("cannot list voice models: %w", err)
+	} else if len(modelPaths) == 0 {
+		return fmt.Errorf("voice model (.vvm) not found: %s", config.ModelDir)
+	}
+	sort.Strings(modelPaths)
+
+	for _, modelPath := range modelPaths {
+		if err := s.loadVoiceModel(modelPath); err != nil {
+			return fmt.Errorf("cannot load voice model: %s: %w", modelPath, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *synthesizerClient) loadVoiceModel(modelPath string) error {
+	if _, err := os.Stat(modelPath); err != nil {
+		return err
+	}
+
+	cModelPath := C.CString(modelPath)
+	defer C.free(unsafe.Pointer(cModelPath))
+
+	var model unsafe.Pointer
+	if result := C.synthModelOpen(s.modelOpen, cModelPath, &model); result != 0 {
+		return s.getError(result)
+	}
+	defer C.synthDelete(s.modelDelete, model)
+
+	if result := C.synthLoadModel(s.loadModel, s.synthesizer, model); result != 0 {
+		return s.getError(result)
+	}
+	return nil
+}
+
+func (s *synthesizerClient) close() {
+	if s.synthesizer != nil {
+		C.synthDelete(s.synthesizerDelete, s.synthesizer)
+		s.synthesizer = nil
+	}
+	if s.openJtalk != nil {
+		C.synthDelete(s.openJtalkDelete, s.openJtalk)
+		s.openJtalk = nil
+	}
+}
+
+func (s *synthesizerClient) getVoiceSpeakers() ([]VoiceSpeaker, error) {
+	if s.synthesizer == nil {
+		return nil, ErrLibraryNotLoaded
+	}
+
+	rawJson := C.synthMetas(s.getMeta, s.synthesizer)
+	defer C.synthFreeJson(s.freeJson, rawJson)
+
+	speakers := []VoiceSpeaker{}
+	err := json.Unmarshal([]byte(C.GoString(rawJson)), &speakers)
+	return speakers, err
+}
+
+func (s *synthesizerClient) tts(c *Client, text string, speakerId int) (wav io.ReadCloser, err error) {
+	if s.synthesizer == nil {
+		return nil, ErrLibraryNotLoaded
+	}
+
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+
+	var result C.int32_t
+	var size C.size_t
+	rawWav := C.synthTts(s.text2Speech, s.synthesizer, cText, C.uint32_t(speakerId), &size, &result)
+	if result != 0 {
+		return nil, s.getError(result)
+	}
+
+	return &waveData{
+		Reader: *bytes.NewReader(C.GoBytes(unsafe.Pointer(rawWav), C.int(size))),
+		rawPtr: unsafe.Pointer(rawWav),
+		client: c,
+	}, nil
+}
+
+func (s *synthesizerClient) createAudioQuery(text string, speakerId int) (*AudioQuery, error) {
+	if s.synthesizer == nil {
+		return nil, ErrLibraryNotLoaded
+	}
+
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+
+	var rawJson *C.char
+	if result := C.synthAudioQuery(s.audioQuery, s.synthesizer, cText, C.uint32_t(speakerId), &rawJson); result != 0 {
+		return nil, s.getError(result)
+	}
+	defer C.synthFreeJson(s.freeJson, rawJson)
+
+	query := &AudioQuery{}
+	if err := json.Unmarshal([]byte(C.GoString(rawJson)), query); err != nil {
+		return nil, fmt.Errorf("cannot parse audio query: %w", err)
+	}
+	return query, nil
+}
+
+func (s *synthesizerClient) synthesize(c *Client, query *AudioQuery, speakerId int) (wav io.ReadCloser, err error) {
+	if s.synthesizer == nil {
+		return nil, ErrLibraryNotLoaded
+	}
+
+	queryJson, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode audio query: %w", err)
+	}
+	cQueryJson := C.CString(string(queryJson))
+	defer C.free(unsafe.Pointer(cQueryJson))
+
+	var result C.int32_t
+	var size C.size_t
+	rawWav := C.synthSynthesis(s.synthesis, s.synthesizer, cQueryJson, C.uint32_t(speakerId), &size, &result)
+	if result != 0 {
+		return nil, s.getError(result)
+	}
+
+	return &waveData{
+		Reader: *bytes.NewReader(C.GoBytes(unsafe.Pointer(rawWav), C.int(size))),
+		rawPtr: unsafe.Pointer(rawWav),
+		client: c,
+	}, nil
+}
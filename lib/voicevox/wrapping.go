@@ -19,6 +19,7 @@ import (
 )
 
 type Client struct {
+	abi              ABI
 	library          unsafe.Pointer
 	initialize       unsafe.Pointer
 	finalize         unsafe.Pointer
@@ -29,12 +30,40 @@ type Client struct {
 	loadDict         unsafe.Pointer
 	text2Speech      unsafe.Pointer
 	freeWave         unsafe.Pointer
+	synthesizer      *synthesizerClient
+}
+
+// ABI is the generation of the C API exposed by the loaded libcore.so.
+type ABI int
+
+const (
+	// ABILegacy is the voicevox_core 0.13 API (initialize, load_model, voicevox_tts, ...).
+	ABILegacy ABI = iota
+	// ABISynthesizer is the voicevox_core 0.16+ API (voicevox_synthesizer_*, voicevox_open_jtalk_rc_*).
+	ABISynthesizer
+)
+
+func (a ABI) String() string {
+	switch a {
+	case ABILegacy:
+		return "legacy"
+	case ABISynthesizer:
+		return "synthesizer"
+	default:
+		return fmt.Sprintf("ABI(%d)", int(a))
+	}
 }
 
 type InitConfig struct {
 	UseGPU        bool
 	NumThreads    int
 	LoadAllModels bool
+	// ModelDir is the directory containing voice model files (*.vvm).
+	// It is used only with ABISynthesizer, which always loads every model found.
+	ModelDir string
+	// OnnxruntimePath is the onnxruntime library loaded with ABISynthesizer.
+	// The versioned filename bundled with voicevox_core is used if empty.
+	OnnxruntimePath string
 }
 
 type waveData struct {
@@ -45,6 +74,9 @@ type waveData struct {
 
 var ErrLibraryNotLoaded = errors.New("library have not been loaded yet")
 
+// ErrUnsupportedABI is returned by LoadLib for libraries other than voicevox_core 0.13 and 0.16+.
+var ErrUnsupportedABI = errors.New("unsupported voicevox_core version (0.13 or 0.16+ is required)")
+
 func LoadLib(libPath, dictPath string) (*Client, error) {
 
 	// ライブラリ
@@ -64,6 +96,54 @@ func LoadLib(libPath, dictPath string) (*Client, error) {
 		return symbol, nil
 	}
 
+	abi, err := detectABI(solveSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if abi == ABISynthesizer {
+		synthesizer, err := loadSynthesizer(solveSymbol, dictPath)
+		if err != nil {
+			return nil, err
+		}
+		freeWave, err := solveSymbol("voicevox_wav_free")
+		if err != nil {
+			return nil, err
+		}
+
+		// OpenJTalk の辞書は Open で読み込む
+		return &Client{
+			abi:         ABISynthesizer,
+			library:     library,
+			freeWave:    freeWave,
+			synthesizer: synthesizer,
+		}, nil
+	}
+
+	client, err := loadLegacy(solveSymbol)
+	if err != nil {
+		return nil, err
+	}
+	client.library = library
+	return client, client.loadDictionary(dictPath)
+}
+
+// detectABI decides the ABI from the symbols the library exports.
+func detectABI(solveSymbol func(string) (unsafe.Pointer, error)) (ABI, error) {
+	if _, err := solveSymbol("voicevox_synthesizer_new"); err == nil {
+		// 0.14, 0.15 は合成器APIを持つが onnxruntime の読み込み方が異なる
+		if _, err := solveSymbol("voicevox_onnxruntime_load_once"); err != nil {
+			return 0, ErrUnsupportedABI
+		}
+		return ABISynthesizer, nil
+	}
+	if _, err := solveSymbol("initialize"); err != nil {
+		return 0, ErrUnsupportedABI
+	}
+	return ABILegacy, nil
+}
+
+func loadLegacy(solveSymbol func(string) (unsafe.Pointer, error)) (*Client, error) {
 	if initialize, err := solveSymbol("initialize"); err != nil {
 		return nil, err
 	} else if loadModel, err := solveSymbol("load_model"); err != nil {
@@ -83,7 +163,8 @@ func LoadLib(libPath, dictPath string) (*Client, error) {
 	} else if freeWave, err := solveSymbol("voicevox_wav_free"); err != nil {
 		return nil, err
 	} else {
-		client := &Client{
+		return &Client{
+			abi:              ABILegacy,
 			initialize:       initialize,
 			finalize:         finalize,
 			loadModel:        loadModel,
@@ -93,12 +174,15 @@ func LoadLib(libPath, dictPath string) (*Client, error) {
 			loadDict:         loadDict,
 			text2Speech:      text2Speech,
 			freeWave:         freeWave,
-		}
-
-		return client, client.loadDictionary(dictPath)
+		}, nil
 	}
 }
 
+// ABI reports which C API generation the loaded library exposes.
+func (c *Client) ABI() ABI {
+	return c.abi
+}
+
 func (c *Client) getError() error {
 	result := C.getErrMsg(c.getErrMsg)
 	return fmt.Errorf("voicevox error: %s", C.GoString(result))
@@ -113,6 +197,13 @@ func (c *Client) loadDictionary(dictPath string) error {
 }
 
 func (c *Client) Open(config InitConfig) (*Client, error) {
+	if c.synthesizer != nil {
+		if err := c.synthesizer.open(config); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
 	result := C.initialize(
 		c.initialize,
 		C.bool(config.UseGPU),
@@ -127,17 +218,29 @@ func (c *Client) Open(config InitConfig) (*Client, error) {
 }
 
 func (c *Client) Close() error {
+	if c.synthesizer != nil {
+		c.synthesizer.close()
+		return nil
+	}
+
 	C.finalize(c.finalize)
 	return nil
 }
 
 func (c *Client) GetVoiceSpeakers() ([]VoiceSpeaker, error) {
+	if c.synthesizer != nil {
+		return c.synthesizer.getVoiceSpeakers()
+	}
+
 	speakers := []VoiceSpeaker{}
 	err := json.Unmarshal([]byte(C.GoString(C.getMeta(c.getMeta))), &speakers)
 	return speakers, err
 }
 
 func (c *Client) Text2Speech(text string, speakerId int) (wav io.ReadCloser, err error) {
+	if c.synthesizer != nil {
+		return c.synthesizer.tts(c, text, speakerId)
+	}
 
 	var result C.int
 	var size C.int
@@ -155,6 +258,24 @@ func (c *Client) Text2Speech(text string, speakerId int) (wav io.ReadCloser, err
 	}, nil
 }
 
+// AudioQuery analyzes text into an editable query for Synthesis.
+// It returns ErrNotSupported with ABILegacy.
+func (c *Client) AudioQuery(text string, speakerId int) (*AudioQuery, error) {
+	if c.synthesizer == nil {
+		return nil, ErrNotSupported
+	}
+	return c.synthesizer.createAudioQuery(text, speakerId)
+}
+
+// Synthesis renders the query made by AudioQuery into WAV.
+// It returns ErrNotSupported with ABILegacy.
+func (c *Client) Synthesis(query *AudioQuery, speakerId int) (wav io.ReadCloser, err error) {
+	if c.synthesizer == nil {
+		return nil, ErrNotSupported
+	}
+	return c.synthesizer.synthesize(c, query, speakerId)
+}
+
 func (w *waveData) Close() error {
 	C.freeWave(w.client.freeWave, (*C.uint8_t)(w.rawPtr))
 	return nil
@@ -54,6 +54,7 @@ func main() {
 	config := voicevox.InitConfig{
 		NumThreads:    2,
 		LoadAllModels: true,
+		ModelDir:      os.Getenv("VOICEVOX_MODELDIR"),
 	}
 
 	vv, err := voicevox.Start(logger, os.Getenv("VOICEVOX_COREPATH"), os.Getenv("VOICEVOX_JTALKDIR"), config)
@@ -67,9 +68,20 @@ func main() {
 		logger.Fatal("cannot start voicevox application", zap.Error(err))
 	}
 
+	// local data directory
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
 	// chatspace application
+	chatConfig, err := chatspace.LoadConfig(os.Getenv("CHATSPACE_CONFIG"))
+	if err != nil {
+		logger.Fatal("cannot load chatspace config", zap.Error(err))
+	}
+
 	chatDiscordToken := os.Getenv("CHATSPACE_DISCORD_TOKEN")
-	chat, err := chatspace.NewService(logger, chatDiscordToken, vv)
+	chat, err := chatspace.NewService(logger, chatDiscordToken, vv, chatConfig, dataDir)
 	if err != nil {
 		logger.Error("cannot start chatspace application", zap.String("discordToken", chatDiscordToken[:8]+"***"+chatDiscordToken[len(chatDiscordToken)-8:]), zap.Error(err))
 	}
@@ -77,7 +89,7 @@ func main() {
 
 	// creato application
 	creatoDiscordToken := os.Getenv("CREATO_DISCORD_TOKEN")
-	creato, err := talker.NewService(logger, creatoDiscordToken, vv)
+	creato, err := talker.NewService(logger, creatoDiscordToken, vv, dataDir)
 	if err != nil {
 		logger.Error("cannot start creato application", zap.String("discordToken", creatoDiscordToken[:8]+"***"+creatoDiscordToken[len(creatoDiscordToken)-8:]), zap.Error(err))
 	}